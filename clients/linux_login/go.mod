@@ -1,5 +0,0 @@
-module clients/linux_login
-
-go 1.22.4
-
-
@@ -0,0 +1,454 @@
+package agent
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"motadata/internal/frame"
+	"motadata/internal/tlsconfig"
+)
+
+// Event is the client payload the collector's TCP listener accepts.
+type Event struct {
+	Timestamp string `json:"timestamp"`
+	Hostname  string `json:"hostname"`
+	Source    string `json:"event.source.type"`
+	Category  string `json:"event.category"`
+	Message   string `json:"message"`
+
+	Username   string            `json:"username,omitempty"`
+	Severity   string            `json:"severity,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Protocols spoken to the collector.
+const (
+	ProtocolNDJSON = "ndjson" // newline-delimited JSON, not acknowledged
+	ProtocolAck    = "ack"    // windows of frames the collector acknowledges
+)
+
+type Config struct {
+	Addr          string
+	Protocol      string
+	DialTimeout   time.Duration
+	WriteTimeout  time.Duration
+	AckTimeout    time.Duration // longest wait for the next ack of a window
+	BufferSize    int           // events held while disconnected; the oldest are dropped
+	BatchSize     int           // events written per flush
+	FlushInterval time.Duration // longest a partial batch waits
+	MinBackoff    time.Duration
+	MaxBackoff    time.Duration
+
+	// SpoolDir, if set, keeps undelivered events on disk instead of in
+	// memory, up to SpoolMaxBytes.
+	SpoolDir      string
+	SpoolMaxBytes int64
+
+	// UseTLS encrypts the connection, verifying the collector against the
+	// system roots unless TLS names a CA bundle. It is implied by any TLS
+	// file.
+	UseTLS bool
+	TLS    tlsconfig.Config
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr:          "log-collector:9000",
+		Protocol:      ProtocolAck,
+		DialTimeout:   5 * time.Second,
+		WriteTimeout:  10 * time.Second,
+		AckTimeout:    30 * time.Second,
+		BufferSize:    10000,
+		BatchSize:     100,
+		FlushInterval: 500 * time.Millisecond,
+		MinBackoff:    500 * time.Millisecond,
+		MaxBackoff:    30 * time.Second,
+		SpoolMaxBytes: 64 << 20,
+	}
+}
+
+// RegisterFlags adds the connection flags to fs. Their defaults come from
+// c, overridden by COLLECTOR_ADDR, AGENT_PROTOCOL, AGENT_BUFFER,
+// AGENT_BATCH, AGENT_FLUSH_INTERVAL, AGENT_MAX_BACKOFF, AGENT_SPOOL_DIR,
+// AGENT_SPOOL_MAX_BYTES, AGENT_TLS, AGENT_TLS_CA_FILE, AGENT_TLS_CERT_FILE,
+// AGENT_TLS_KEY_FILE and AGENT_TLS_SERVER_NAME.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "collector", envString("COLLECTOR_ADDR", c.Addr), "collector TCP address")
+	fs.StringVar(&c.Protocol, "protocol", envString("AGENT_PROTOCOL", c.Protocol), `"ack" to have the collector acknowledge events, or "ndjson"`)
+	fs.IntVar(&c.BufferSize, "buffer", envInt("AGENT_BUFFER", c.BufferSize), "events buffered while disconnected")
+	fs.IntVar(&c.BatchSize, "batch", envInt("AGENT_BATCH", c.BatchSize), "events per write")
+	fs.DurationVar(&c.FlushInterval, "flush-interval", envDuration("AGENT_FLUSH_INTERVAL", c.FlushInterval), "longest delay before a partial batch is sent")
+	fs.DurationVar(&c.MaxBackoff, "max-backoff", envDuration("AGENT_MAX_BACKOFF", c.MaxBackoff), "longest wait between reconnect attempts")
+	fs.StringVar(&c.SpoolDir, "spool-dir", envString("AGENT_SPOOL_DIR", c.SpoolDir), "directory to spool undelivered events to")
+	fs.Int64Var(&c.SpoolMaxBytes, "spool-max-bytes", int64(envInt("AGENT_SPOOL_MAX_BYTES", int(c.SpoolMaxBytes))), "spool size cap; the oldest events are dropped beyond it")
+	fs.StringVar(&c.TLS.CAFile, "tls-ca", envString("AGENT_TLS_CA_FILE", c.TLS.CAFile), "CA bundle to verify the collector with")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", envString("AGENT_TLS_CERT_FILE", c.TLS.CertFile), "client certificate for mutual TLS")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", envString("AGENT_TLS_KEY_FILE", c.TLS.KeyFile), "client certificate key")
+	fs.StringVar(&c.TLS.ServerName, "tls-server-name", envString("AGENT_TLS_SERVER_NAME", c.TLS.ServerName), "collector name to verify, if not the host of -collector")
+	fs.BoolVar(&c.UseTLS, "tls", c.UseTLS || strings.EqualFold(os.Getenv("AGENT_TLS"), "true"), "connect with TLS; implied by -tls-ca, -tls-cert and -tls-key")
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return d
+	}
+	return def
+}
+
+// Hostname returns $HOSTNAME, the system host name, or def.
+func Hostname(def string) string {
+	if h := os.Getenv("HOSTNAME"); h != "" {
+		return h
+	}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h
+	}
+	return def
+}
+
+type Stats struct {
+	Sent       int64 `json:"sent"`
+	Dropped    int64 `json:"dropped"`
+	Reconnects int64 `json:"reconnects"`
+	Buffered   int   `json:"buffered"`
+}
+
+// queue holds undelivered events. Positions are sequence numbers, so a
+// commit after events were dropped from the front only removes what is left
+// of the batch.
+type queue interface {
+	push(e Event) (dropped int, err error)
+	peek(n int) ([]Event, uint64, error)
+	commit(end uint64) error
+	len() int
+	close() error
+}
+
+type memQueue struct {
+	size int
+	mu   sync.Mutex
+	buf  []Event
+	base uint64 // events ever removed from the front of buf
+}
+
+func (m *memQueue) push(e Event) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dropped := 0
+	if len(m.buf) >= m.size {
+		m.buf = m.buf[1:]
+		m.base++
+		dropped++
+	}
+	m.buf = append(m.buf, e)
+	return dropped, nil
+}
+
+func (m *memQueue) peek(n int) ([]Event, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n = min(len(m.buf), n)
+	return m.buf[:n:n], m.base, nil
+}
+
+func (m *memQueue) commit(end uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if end > m.base {
+		m.buf = m.buf[end-m.base:]
+		m.base = end
+	}
+	return nil
+}
+
+func (m *memQueue) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.buf)
+}
+
+func (m *memQueue) close() error { return nil }
+
+type spoolQueue struct{ *Spool }
+
+func (s spoolQueue) push(e Event) (int, error)           { return s.Append(e) }
+func (s spoolQueue) peek(n int) ([]Event, uint64, error) { return s.Peek(n) }
+func (s spoolQueue) commit(end uint64) error             { return s.Commit(end) }
+func (s spoolQueue) len() int                            { return s.Len() }
+func (s spoolQueue) close() error                        { return s.Close() }
+
+// Agent delivers events to the collector. Events are buffered in
+// memory, or in a Spool, and written in batches; a batch is only removed
+// from the buffer once it was written, so a broken connection re-sends it
+// after reconnecting. Dialing backs off exponentially with jitter.
+type Agent struct {
+	cfg  Config
+	dial func() (net.Conn, error)
+
+	q      queue
+	notify chan struct{}
+
+	sent, dropped, reconnects atomic.Int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(cfg Config) (*Agent, error) {
+	def := DefaultConfig()
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = def.BufferSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = def.FlushInterval
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = def.MinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = def.DialTimeout
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = def.WriteTimeout
+	}
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = def.AckTimeout
+	}
+	switch cfg.Protocol {
+	case "":
+		cfg.Protocol = def.Protocol
+	case ProtocolAck, ProtocolNDJSON:
+	default:
+		return nil, fmt.Errorf("agent: unknown protocol %q", cfg.Protocol)
+	}
+	a := &Agent{
+		cfg:    cfg,
+		q:      &memQueue{size: cfg.BufferSize},
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	d := &net.Dialer{Timeout: cfg.DialTimeout}
+	a.dial = func() (net.Conn, error) { return d.Dial("tcp", cfg.Addr) }
+	if cfg.UseTLS || cfg.TLS.Enabled() {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		tc, err := cfg.TLS.Client(host)
+		if err != nil {
+			return nil, err
+		}
+		a.dial = func() (net.Conn, error) { return tls.DialWithDialer(d, "tcp", cfg.Addr, tc) }
+	}
+	if cfg.SpoolDir != "" {
+		if cfg.SpoolMaxBytes <= 0 {
+			cfg.SpoolMaxBytes = def.SpoolMaxBytes
+		}
+		sp, err := OpenSpool(cfg.SpoolDir, cfg.SpoolMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		a.q = spoolQueue{sp}
+	}
+	return a, nil
+}
+
+// Send queues e without blocking.
+func (a *Agent) Send(e Event) {
+	if e.Timestamp == "" {
+		e.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	dropped, err := a.q.push(e)
+	if err != nil {
+		log.Printf("spool: %v", err)
+		dropped++
+	}
+	a.dropped.Add(int64(dropped))
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Agent) Stats() Stats {
+	return Stats{Sent: a.sent.Load(), Dropped: a.dropped.Load(), Reconnects: a.reconnects.Load(), Buffered: a.q.len()}
+}
+
+// Start connects and delivers events until Close.
+func (a *Agent) Start() {
+	go a.run()
+}
+
+func (a *Agent) run() {
+	defer close(a.done)
+	backoff := a.cfg.MinBackoff
+	for {
+		conn, err := a.dial()
+		if err != nil {
+			log.Printf("dial %s: %v", a.cfg.Addr, err)
+			// Full jitter keeps a fleet of agents from reconnecting in step.
+			wait := backoff/2 + rand.N(backoff/2+1)
+			backoff = min(backoff*2, a.cfg.MaxBackoff)
+			select {
+			case <-a.stop:
+				return
+			case <-time.After(wait):
+				continue
+			}
+		}
+		log.Printf("connected to %s", a.cfg.Addr)
+		backoff = a.cfg.MinBackoff
+		err = a.pump(conn)
+		conn.Close()
+		if err == nil {
+			return
+		}
+		a.reconnects.Add(1)
+		log.Printf("connection to %s lost: %v", a.cfg.Addr, err)
+	}
+}
+
+// pump writes batches until the connection fails (non-nil error) or the
+// agent is closed and the buffer is drained (nil).
+func (a *Agent) pump(conn net.Conn) error {
+	w := bufio.NewWriter(conn)
+	r := bufio.NewReader(conn)
+	tick := time.NewTicker(a.cfg.FlushInterval)
+	defer tick.Stop()
+	stopping := false
+	for {
+		flushAll := false
+		select {
+		case <-a.stop:
+			stopping, flushAll = true, true
+		case <-a.notify:
+		case <-tick.C:
+			flushAll = true
+		}
+		// Full batches go out right away, a partial one on the tick.
+		for {
+			if n := a.q.len(); n == 0 || (n < a.cfg.BatchSize && !flushAll) {
+				break
+			}
+			batch, start, err := a.q.peek(a.cfg.BatchSize)
+			if err != nil {
+				return err
+			}
+			if len(batch) == 0 {
+				break
+			}
+			if err := a.deliver(conn, w, r, batch, start); err != nil {
+				return err
+			}
+		}
+		if stopping {
+			return nil
+		}
+	}
+}
+
+// deliver writes batch, whose first event is at position start, and removes
+// the events from the queue once written or, with the ack protocol, once
+// acknowledged. Unacknowledged events stay queued and are sent again after
+// reconnecting, so the collector may see an event twice but never miss one.
+func (a *Agent) deliver(conn net.Conn, w *bufio.Writer, r *bufio.Reader, batch []Event, start uint64) error {
+	n := len(batch)
+	conn.SetWriteDeadline(time.Now().Add(a.cfg.WriteTimeout))
+	if a.cfg.Protocol == ProtocolNDJSON {
+		enc := json.NewEncoder(w)
+		for _, e := range batch {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		a.acked(start, n)
+		return nil
+	}
+
+	if err := frame.Write(w, frame.Frame{Type: frame.TypeWindow, Seq: uint32(n)}); err != nil {
+		return err
+	}
+	for i, e := range batch {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := frame.Write(w, frame.Frame{Type: frame.TypeData, Seq: uint32(i + 1), Payload: b}); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	done := 0
+	for done < n {
+		conn.SetReadDeadline(time.Now().Add(a.cfg.AckTimeout))
+		f, err := frame.Read(r)
+		if err != nil {
+			return err
+		}
+		if f.Type != frame.TypeAck {
+			return fmt.Errorf("unexpected frame %q", f.Type)
+		}
+		if k := min(int(f.Seq), n); k > done {
+			a.acked(start+uint64(done), k-done)
+			done = k
+		}
+	}
+	return nil
+}
+
+// acked removes n delivered events starting at position start.
+func (a *Agent) acked(start uint64, n int) {
+	if err := a.q.commit(start + uint64(n)); err != nil {
+		log.Printf("spool: %v", err)
+	}
+	a.sent.Add(int64(n))
+}
+
+// Close stops the agent, first trying for up to timeout to deliver what is
+// still buffered. It returns the number of events left undelivered; with a
+// spool they are sent after the next start.
+func (a *Agent) Close(timeout time.Duration) int {
+	close(a.stop)
+	select {
+	case <-a.done:
+	case <-time.After(timeout):
+	}
+	n := a.q.len()
+	a.q.close()
+	return n
+}
@@ -0,0 +1,388 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"motadata/internal/frame"
+	"motadata/internal/utmp"
+)
+
+// collector accepts connections and decodes events onto a channel. Framed
+// windows are acknowledged once complete.
+func collector(t *testing.T) (net.Listener, chan Event) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	out := make(chan Event, 100)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c, out, -1)
+		}
+	}()
+	return ln, out
+}
+
+// serve decodes events from c; after ackLimit framed events (if not
+// negative) it stops acknowledging.
+func serve(c net.Conn, out chan Event, ackLimit int) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	if b, err := r.Peek(1); err != nil || b[0] != frame.Version {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			var e Event
+			if json.Unmarshal(sc.Bytes(), &e) == nil {
+				out <- e
+			}
+		}
+		return
+	}
+	var window uint32
+	for {
+		f, err := frame.Read(r)
+		if err != nil {
+			return
+		}
+		if f.Type == frame.TypeWindow {
+			window = f.Seq
+			continue
+		}
+		if ackLimit == 0 {
+			continue
+		}
+		ackLimit--
+		var e Event
+		json.Unmarshal(f.Payload, &e)
+		out <- e
+		if f.Seq == window || ackLimit == 0 {
+			frame.Write(c, frame.Frame{Type: frame.TypeAck, Seq: f.Seq})
+		}
+	}
+}
+
+func receive(t *testing.T, ch chan Event, n int) []Event {
+	t.Helper()
+	var got []Event
+	for len(got) < n {
+		select {
+		case e := <-ch:
+			got = append(got, e)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d of %d events", len(got), n)
+		}
+	}
+	return got
+}
+
+func newAgent(t *testing.T, cfg Config) *Agent {
+	t.Helper()
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAgentDeliversBatches(t *testing.T) {
+	ln, ch := collector(t)
+	a := newAgent(t, Config{Addr: ln.Addr().String(), BatchSize: 3, FlushInterval: 20 * time.Millisecond})
+	a.Start()
+	for _, m := range []string{"a", "b", "c", "d"} {
+		a.Send(Event{Hostname: "h1", Message: m})
+	}
+	got := receive(t, ch, 4)
+	if got[0].Message != "a" || got[3].Message != "d" || got[0].Timestamp == "" {
+		t.Fatalf("unexpected events %+v", got)
+	}
+	if left := a.Close(time.Second); left != 0 {
+		t.Fatalf("expected empty buffer, %d left", left)
+	}
+	if st := a.Stats(); st.Sent != 4 || st.Dropped != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestAgentBuffersUntilCollectorIsUp(t *testing.T) {
+	// Reserve an address, then free it so the first dials fail.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	a := newAgent(t, Config{Addr: addr, Protocol: ProtocolNDJSON, BufferSize: 2, FlushInterval: 10 * time.Millisecond, MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+	a.Start()
+	for _, m := range []string{"old", "kept1", "kept2"} {
+		a.Send(Event{Message: m})
+	}
+	if st := a.Stats(); st.Dropped != 1 || st.Buffered != 2 {
+		t.Fatalf("expected oldest event dropped, got %+v", st)
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("address reused: %v", err)
+	}
+	defer ln.Close()
+	ch := make(chan Event, 10)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		sc := bufio.NewScanner(c)
+		for sc.Scan() {
+			var e Event
+			json.Unmarshal(sc.Bytes(), &e)
+			ch <- e
+		}
+	}()
+	got := receive(t, ch, 2)
+	if got[0].Message != "kept1" || got[1].Message != "kept2" {
+		t.Fatalf("unexpected events %+v", got)
+	}
+	a.Close(time.Second)
+}
+
+func TestAgentResendsUnacked(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ch := make(chan Event, 20)
+	go func() {
+		// The first connection acknowledges two events of the window and
+		// then goes silent; the second acknowledges everything.
+		for limit := 2; ; limit = -1 {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c, ch, limit)
+		}
+	}()
+
+	a := newAgent(t, Config{Addr: ln.Addr().String(), BatchSize: 4, AckTimeout: 100 * time.Millisecond, MinBackoff: 10 * time.Millisecond})
+	a.Start()
+	for _, m := range []string{"a", "b", "c", "d"} {
+		a.Send(Event{Message: m})
+	}
+	got := receive(t, ch, 4)
+	var msgs []string
+	for _, e := range got {
+		msgs = append(msgs, e.Message)
+	}
+	if strings.Join(msgs, "") != "abcd" {
+		t.Fatalf("expected a, b and then c, d resent, got %v", msgs)
+	}
+	if left := a.Close(time.Second); left != 0 {
+		t.Fatalf("%d events left", left)
+	}
+	if st := a.Stats(); st.Sent != 4 || st.Reconnects != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestUtmpWatcher(t *testing.T) {
+	ln, ch := collector(t)
+	a := newAgent(t, Config{Addr: ln.Addr().String(), FlushInterval: 10 * time.Millisecond})
+	a.Start()
+	defer a.Close(time.Second)
+
+	stop := make(chan struct{})
+	w := UtmpWatcher{
+		Hostname:  "web01",
+		Wtmp:      "../utmp/testdata/wtmp",
+		Btmp:      "../utmp/testdata/btmp",
+		FromStart: true,
+		Kinds:     []utmp.Kind{utmp.KindLogout, utmp.KindFailed},
+	}
+	done := make(chan error)
+	go func() { done <- w.Run(a, stop) }()
+	got := receive(t, ch, 4)
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	categories := map[string]int{}
+	var users []string
+	for _, e := range got {
+		categories[e.Category]++
+		if e.Category == "logout.audit" {
+			users = append(users, e.Username)
+		}
+		if e.Hostname != "web01" || e.Attributes["file_path"] == "" {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	}
+	if categories["logout.audit"] != 2 || categories["login.audit"] != 2 {
+		t.Fatalf("expected two logouts and two failed logins, got %v", categories)
+	}
+	if sort.Strings(users); strings.Join(users, ",") != ",alice" {
+		t.Fatalf("expected logouts of alice and an unknown user, got %q", users)
+	}
+}
+
+func TestUtmpWatcherSeedsFromUtmp(t *testing.T) {
+	ln, ch := collector(t)
+	a := newAgent(t, Config{Addr: ln.Addr().String(), FlushInterval: 10 * time.Millisecond})
+	a.Start()
+	defer a.Close(time.Second)
+
+	// carol logged in on pts/9 before the agent started.
+	dir := t.TempDir()
+	current, wtmp := filepath.Join(dir, "utmp"), filepath.Join(dir, "wtmp")
+	os.WriteFile(current, utmp.Record{Type: utmp.UserProcess, User: "carol", Line: "pts/9", PID: 9999}.Marshal(), 0o644)
+	os.WriteFile(wtmp, nil, 0o644)
+
+	stop := make(chan struct{})
+	w := UtmpWatcher{Hostname: "web01", Utmp: current, Wtmp: wtmp, Interval: 10 * time.Millisecond}
+	done := make(chan error)
+	go func() { done <- w.Run(a, stop) }()
+	time.Sleep(30 * time.Millisecond)
+	os.WriteFile(wtmp, utmp.Record{Type: utmp.DeadProcess, Line: "pts/9", PID: 9999}.Marshal(), 0o644)
+	got := receive(t, ch, 1)
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Category != "logout.audit" || got[0].Username != "carol" {
+		t.Fatalf("expected carol's logout, got %+v", got[0])
+	}
+}
+
+func TestSpoolDropsOldest(t *testing.T) {
+	s, err := OpenSpool(t.TempDir(), 32<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	dropped := 0
+	for i := 0; i < 1000; i++ {
+		n, err := s.Append(Event{Message: strconv.Itoa(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dropped += n
+	}
+	if dropped == 0 || dropped+s.Len() != 1000 {
+		t.Fatalf("dropped %d, %d left", dropped, s.Len())
+	}
+	// What is left is the newest events, in order.
+	next := dropped
+	for s.Len() > 0 {
+		batch, start, err := s.Peek(64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range batch {
+			if e.Message != strconv.Itoa(next) {
+				t.Fatalf("expected event %d, got %q", next, e.Message)
+			}
+			next++
+		}
+		s.Commit(start + uint64(len(batch)))
+	}
+	if next != 1000 {
+		t.Fatalf("drained up to %d", next)
+	}
+}
+
+func TestSpoolSteadyStateCrossesSegments(t *testing.T) {
+	// The reader keeps up with the writer, so each rotation happens with the
+	// head segment already read.
+	s, err := OpenSpool(t.TempDir(), 64<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 1000; i++ {
+		if _, err := s.Append(Event{Message: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+		batch, start, err := s.Peek(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) != 1 || batch[0].Message != strconv.Itoa(i) {
+			t.Fatalf("event %d: peeked %+v with %d queued", i, batch, s.Len())
+		}
+		if err := s.Commit(start + 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Len() != 0 || len(s.segs) != 1 {
+		t.Fatalf("expected a drained spool in one segment, got %d events in %d segments", s.Len(), len(s.segs))
+	}
+}
+
+func TestAgentSpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	a := newAgent(t, Config{Addr: "127.0.0.1:1", SpoolDir: dir, MinBackoff: time.Hour})
+	a.Start()
+	for i := 0; i < 5; i++ {
+		a.Send(Event{Message: strconv.Itoa(i)})
+	}
+	if left := a.Close(100 * time.Millisecond); left != 5 {
+		t.Fatalf("expected 5 spooled events, got %d", left)
+	}
+
+	ln, ch := collector(t)
+	a = newAgent(t, Config{Addr: ln.Addr().String(), SpoolDir: dir, FlushInterval: 10 * time.Millisecond})
+	a.Start()
+	a.Send(Event{Message: "5"})
+	got := receive(t, ch, 6)
+	for i, e := range got {
+		if e.Message != strconv.Itoa(i) {
+			t.Fatalf("out of order: %+v", got)
+		}
+	}
+	if left := a.Close(time.Second); left != 0 {
+		t.Fatalf("expected drained spool, %d left", left)
+	}
+}
+
+func TestClientFlags(t *testing.T) {
+	t.Setenv("AGENT_MODE", "utmp")
+	t.Setenv("POLL_INTERVAL", "5s")
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	hosts := HostsFlag(fs)
+	var f UtmpFlags
+	f.RegisterFlags(fs, false)
+	if err := fs.Parse([]string{"-hosts", "a,b", "-wtmp", "/tmp/wtmp"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(*hosts, "|") != "a|b" {
+		t.Fatalf("hosts = %q", *hosts)
+	}
+	if f.Mode != "utmp" || f.Poll != 5*time.Second || f.Wtmp != "/tmp/wtmp" || f.Utmp != "/var/run/utmp" || f.Btmp != "" {
+		t.Fatalf("unexpected flags: %+v", f)
+	}
+	if fs.Lookup("btmp") != nil {
+		t.Fatalf("-btmp registered without btmp")
+	}
+	f.Mode = "bogus"
+	if err := f.Run(nil, nil, nil, "login.audit", nil); err == nil {
+		t.Fatalf("expected an unknown mode to fail")
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"motadata/internal/utmp"
+)
+
+// Main registers the connection flags on flag.CommandLine, parses the
+// command line and calls run with a started agent and a channel that is
+// closed on SIGINT or SIGTERM. Buffered events are then flushed for up to
+// 5s. Flags of the binary must be registered before Main is called.
+func Main(run func(a *Agent, stop <-chan struct{}) error) {
+	cfg := DefaultConfig()
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	a, err := New(cfg)
+	if err != nil {
+		log.Fatalf("agent: %v", err)
+	}
+	a.Start()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	runErr := run(a, ctx.Done())
+	cancel()
+	if left := a.Close(5 * time.Second); left > 0 {
+		log.Printf("%d events undelivered", left)
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+}
+
+var simulatedUsers = []string{"root", "motadata", "alice", "bob"}
+
+// RunGenerator sends a synthetic event every one to two seconds until stop
+// is closed. Each event is for a random host of hosts and a random user,
+// with the message returned by format.
+func RunGenerator(a *Agent, stop <-chan struct{}, hosts []string, category string, format func(hostname, username string) string) {
+	for {
+		hostname := hosts[rand.IntN(len(hosts))]
+		username := simulatedUsers[rand.IntN(len(simulatedUsers))]
+		a.Send(Event{
+			Hostname: hostname,
+			Source:   "linux",
+			Category: category,
+			Message:  format(hostname, username),
+		})
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Duration(1000+rand.IntN(1000)) * time.Millisecond):
+		}
+	}
+}
+
+type hostList []string
+
+func (h *hostList) String() string { return strings.Join(*h, ",") }
+
+func (h *hostList) Set(s string) error {
+	*h = strings.Split(s, ",")
+	return nil
+}
+
+// HostsFlag adds -hosts, the comma-separated host names a generator picks
+// from, to fs. Its default comes from CLIENT_HOSTS.
+func HostsFlag(fs *flag.FlagSet) *[]string {
+	hosts := hostList(strings.Split(envString("CLIENT_HOSTS", "aiops9242,node-01,db-02,api-03,worker-07"), ","))
+	fs.Var(&hosts, "hosts", "comma-separated host names to pick from")
+	return (*[]string)(&hosts)
+}
+
+// UtmpFlags select between simulated events and real ones read from the
+// login accounting files.
+type UtmpFlags struct {
+	Hostname  string
+	Mode      string // "simulate" or "utmp"
+	Utmp      string
+	Wtmp      string
+	Btmp      string // empty unless registered
+	FromStart bool
+	Poll      time.Duration
+}
+
+// RegisterFlags adds the flags to fs, -btmp only if btmp is set. Their
+// defaults come from HOSTNAME, AGENT_MODE, UTMP_PATH, WTMP_PATH, BTMP_PATH,
+// READ_FROM_START and POLL_INTERVAL.
+func (f *UtmpFlags) RegisterFlags(fs *flag.FlagSet, btmp bool) {
+	files := "wtmp"
+	if btmp {
+		files = "wtmp/btmp"
+		fs.StringVar(&f.Btmp, "btmp", envString("BTMP_PATH", "/var/log/btmp"), "btmp file (utmp mode)")
+	}
+	fs.StringVar(&f.Hostname, "hostname", Hostname("aiops9242"), "host name reported in events")
+	fs.StringVar(&f.Mode, "mode", envString("AGENT_MODE", "simulate"), fmt.Sprintf(`"simulate", or "utmp" to report real records from %s`, files))
+	fs.StringVar(&f.Utmp, "utmp", envString("UTMP_PATH", "/var/run/utmp"), "utmp file naming the users of sessions open at start (utmp mode)")
+	fs.StringVar(&f.Wtmp, "wtmp", envString("WTMP_PATH", "/var/log/wtmp"), "wtmp file (utmp mode)")
+	fs.BoolVar(&f.FromStart, "from-start", strings.EqualFold(os.Getenv("READ_FROM_START"), "true"), "replay existing records (utmp mode)")
+	fs.DurationVar(&f.Poll, "poll", envDuration("POLL_INTERVAL", 2*time.Second), fmt.Sprintf("how often to check %s (utmp mode)", files))
+}
+
+// Run reports records of kinds until stop is closed or, in simulate mode,
+// generates events for Hostname with RunGenerator.
+func (f *UtmpFlags) Run(a *Agent, stop <-chan struct{}, kinds []utmp.Kind, category string, format func(hostname, username string) string) error {
+	switch f.Mode {
+	case "simulate":
+		RunGenerator(a, stop, []string{f.Hostname}, category, format)
+		return nil
+	case "utmp":
+		w := UtmpWatcher{
+			Hostname:  f.Hostname,
+			Utmp:      f.Utmp,
+			Wtmp:      f.Wtmp,
+			Btmp:      f.Btmp,
+			FromStart: f.FromStart,
+			Interval:  f.Poll,
+			Kinds:     kinds,
+		}
+		if err := w.Run(a, stop); err != nil {
+			return fmt.Errorf("utmp: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q", f.Mode)
+}
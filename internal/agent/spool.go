@@ -0,0 +1,267 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type segment struct {
+	first uint64 // sequence number of the first event
+	count int
+	size  int64
+	path  string
+}
+
+type cursor struct {
+	Seq    uint64 `json:"seq"`
+	Offset int64  `json:"offset"`
+}
+
+// Spool is an on-disk event queue. Events are appended as NDJSON to
+// numbered segment files in dir; a segment is deleted once all its events
+// were committed. When the spool grows past its size cap the oldest segment
+// is dropped. The read position is saved to dir/cursor so a restarted
+// agent resumes where it stopped.
+type Spool struct {
+	dir     string
+	max     int64
+	segSize int64
+
+	mu      sync.Mutex
+	segs    []*segment // oldest first; the last one is appended to
+	w       *os.File
+	size    int64
+	readSeq uint64  // next event to deliver, in segs[0]
+	readOff int64   // byte offset of readSeq in segs[0]
+	peeked  []int64 // offsets after each event of the last Peek
+}
+
+// OpenSpool opens or creates the spool in dir, holding at most maxBytes.
+func OpenSpool(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, max: maxBytes, segSize: max(maxBytes/8, 4096)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spool) load() error {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.ndjson"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".ndjson"), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg, err := scanSegment(name, first)
+		if err != nil {
+			return err
+		}
+		s.segs = append(s.segs, seg)
+		s.size += seg.size
+	}
+
+	var c cursor
+	if b, err := os.ReadFile(filepath.Join(s.dir, "cursor")); err == nil {
+		json.Unmarshal(b, &c)
+	}
+	// Segments read completely before the last run stopped.
+	for len(s.segs) > 0 && c.Seq >= s.segs[0].first+uint64(s.segs[0].count) {
+		if len(s.segs) == 1 {
+			break
+		}
+		s.removeFirst()
+	}
+	if len(s.segs) == 0 {
+		return s.rotate(c.Seq)
+	}
+	head := s.segs[0]
+	if c.Seq >= head.first && c.Seq <= head.first+uint64(head.count) && c.Offset <= head.size {
+		s.readSeq, s.readOff = c.Seq, c.Offset
+	} else {
+		s.readSeq = head.first
+	}
+	last := s.segs[len(s.segs)-1]
+	s.w, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+// scanSegment counts the events in a segment, cutting off a line left
+// incomplete by a crash.
+func scanSegment(path string, first uint64) (*segment, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if i := bytes.LastIndexByte(b, '\n'); i+1 < len(b) {
+		b = b[:i+1]
+		if err := os.Truncate(path, int64(len(b))); err != nil {
+			return nil, err
+		}
+	}
+	return &segment{first: first, count: bytes.Count(b, []byte{'\n'}), size: int64(len(b)), path: path}, nil
+}
+
+// rotate starts a new segment whose first event is seq.
+func (s *Spool) rotate(seq uint64) error {
+	if s.w != nil {
+		s.w.Close()
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d.ndjson", seq))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	s.w = f
+	s.segs = append(s.segs, &segment{first: seq, path: path})
+	if len(s.segs) == 1 {
+		s.readSeq, s.readOff = seq, 0
+	}
+	return nil
+}
+
+// dropRead removes head segments that were read completely. The reader
+// catches up with the writer and then finds its events in a newer segment
+// once Append rotates.
+func (s *Spool) dropRead() {
+	for len(s.segs) > 1 && s.readSeq >= s.segs[0].first+uint64(s.segs[0].count) {
+		s.removeFirst()
+	}
+}
+
+func (s *Spool) removeFirst() {
+	head := s.segs[0]
+	os.Remove(head.path)
+	s.size -= head.size
+	s.segs = s.segs[1:]
+	s.readSeq, s.readOff, s.peeked = s.segs[0].first, 0, nil
+}
+
+// Append writes e to the spool. It returns the number of undelivered
+// events dropped to stay within the size cap.
+func (s *Spool) Append(e Event) (int, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	b = append(b, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.segs[len(s.segs)-1]
+	if last.count > 0 && last.size+int64(len(b)) > s.segSize {
+		if err := s.rotate(last.first + uint64(last.count)); err != nil {
+			return 0, err
+		}
+		last = s.segs[len(s.segs)-1]
+	}
+	if _, err := s.w.Write(b); err != nil {
+		return 0, err
+	}
+	last.count++
+	last.size += int64(len(b))
+	s.size += int64(len(b))
+
+	dropped := 0
+	for s.size > s.max && len(s.segs) > 1 {
+		head := s.segs[0]
+		dropped += int(head.first + uint64(head.count) - s.readSeq)
+		s.removeFirst()
+	}
+	return dropped, nil
+}
+
+// Len returns the number of undelivered events.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lenLocked()
+}
+
+func (s *Spool) lenLocked() int {
+	last := s.segs[len(s.segs)-1]
+	return int(last.first + uint64(last.count) - s.readSeq)
+}
+
+// Peek returns up to n of the oldest undelivered events and the sequence
+// number of the first one. A batch never spans two segments.
+func (s *Spool) Peek(n int) ([]Event, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropRead()
+	head := s.segs[0]
+	n = min(n, int(head.first+uint64(head.count)-s.readSeq))
+	if n <= 0 {
+		return nil, s.readSeq, nil
+	}
+	f, err := os.Open(head.path)
+	if err != nil {
+		return nil, s.readSeq, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(s.readOff, io.SeekStart); err != nil {
+		return nil, s.readSeq, err
+	}
+	r := bufio.NewReader(f)
+	events := make([]Event, 0, n)
+	s.peeked = s.peeked[:0]
+	off := s.readOff
+	for len(events) < n {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		off += int64(len(line))
+		var e Event
+		// A line that does not decode is delivered as an empty event
+		// rather than stalling the spool.
+		json.Unmarshal(line, &e)
+		events = append(events, e)
+		s.peeked = append(s.peeked, off)
+	}
+	return events, s.readSeq, nil
+}
+
+// Commit marks the events before sequence number end as delivered. Events
+// dropped since the Peek are accounted for.
+func (s *Spool) Commit(end uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if end <= s.readSeq || int(end-s.readSeq) > len(s.peeked) {
+		return nil
+	}
+	s.readOff = s.peeked[end-s.readSeq-1]
+	s.peeked = s.peeked[end-s.readSeq:]
+	s.readSeq = end
+	s.dropRead()
+	return s.saveCursor()
+}
+
+func (s *Spool) saveCursor() error {
+	b, _ := json.Marshal(cursor{Seq: s.readSeq, Offset: s.readOff})
+	path := filepath.Join(s.dir, "cursor")
+	if err := os.WriteFile(path+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Close()
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"motadata/internal/utmp"
+)
+
+// UtmpWatcher reports login accounting records from wtmp and, if set,
+// failed logins from btmp. Unless FromStart replays wtmp, the sessions open
+// in Utmp when it starts supply the users of their later logouts.
+type UtmpWatcher struct {
+	Hostname  string
+	Utmp      string
+	Wtmp      string
+	Btmp      string
+	FromStart bool // replay existing records instead of starting at the end
+	Interval  time.Duration
+	Kinds     []utmp.Kind // kinds to report; all when empty
+}
+
+var utmpCategories = map[utmp.Kind]string{
+	utmp.KindLogin:    "login.audit",
+	utmp.KindFailed:   "login.audit",
+	utmp.KindLogout:   "logout.audit",
+	utmp.KindBoot:     "system.audit",
+	utmp.KindShutdown: "system.audit",
+}
+
+// UtmpEvent converts ev, read from path, into a client event.
+func UtmpEvent(hostname, path string, ev utmp.Event) Event {
+	e := Event{
+		Timestamp:  ev.Time.Format(time.RFC3339Nano),
+		Hostname:   hostname,
+		Source:     "linux",
+		Category:   utmpCategories[ev.Kind],
+		Message:    ev.Message(),
+		Username:   ev.User,
+		Severity:   "INFO",
+		Attributes: ev.Attributes(),
+	}
+	if ev.Kind == utmp.KindFailed {
+		e.Severity = "WARN"
+	}
+	e.Attributes["file_path"] = path
+	return e
+}
+
+// Run polls the files and sends events to a until stop is closed.
+func (w UtmpWatcher) Run(a *Agent, stop <-chan struct{}) error {
+	type source struct {
+		f      *utmp.Follower
+		failed bool
+	}
+	var sources []source
+	for path, failed := range map[string]bool{w.Wtmp: false, w.Btmp: true} {
+		if path == "" {
+			continue
+		}
+		f, err := utmp.NewFollower(path, w.FromStart)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source{f, failed})
+	}
+	report := make(map[utmp.Kind]bool)
+	for _, k := range w.Kinds {
+		report[k] = true
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	// Logins are tracked even when not reported so that logouts can be
+	// attributed to a user.
+	tracker := utmp.NewTracker()
+	if w.Utmp != "" && !w.FromStart {
+		recs, err := utmp.ReadFile(w.Utmp)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("%s: %v", w.Utmp, err)
+		}
+		tracker.Seed(recs)
+	}
+	for {
+		for _, s := range sources {
+			recs, err := s.f.Next()
+			if err != nil {
+				log.Printf("%s: %v", s.f.Path, err)
+			}
+			for _, r := range recs {
+				ev, ok := tracker.Event(r, s.failed)
+				if ok && (len(report) == 0 || report[ev.Kind]) {
+					a.Send(UtmpEvent(w.Hostname, s.f.Path, ev))
+				}
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
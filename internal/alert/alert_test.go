@@ -0,0 +1,248 @@
+package alert
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"motadata/internal/model"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	fails    int
+	bodies   []string
+	keys     []string
+	received chan struct{}
+}
+
+func newReceiver(fails int) (*receiver, *httptest.Server) {
+	rc := &receiver{fails: fails, received: make(chan struct{}, 16)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		if rc.fails > 0 {
+			rc.fails--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		rc.bodies = append(rc.bodies, string(b))
+		rc.keys = append(rc.keys, r.Header.Get("X-Dedupe-Key"))
+		rc.received <- struct{}{}
+	}))
+	return rc, srv
+}
+
+func (rc *receiver) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-rc.received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook not called")
+	}
+}
+
+func TestThresholdWindowAndCooldown(t *testing.T) {
+	rc, srv := newReceiver(1)
+	defer srv.Close()
+	rules, _ := NewRuleStore("")
+	_, err := rules.Put(Rule{
+		Name:      "blacklisted logins",
+		Query:     "is.blacklisted=true",
+		Threshold: 2,
+		Window:    Duration{time.Minute},
+		Cooldown:  Duration{10 * time.Minute},
+		Webhooks:  []Webhook{{URL: srv.URL}},
+	})
+	if err != nil {
+		t.Fatalf("put rule: %v", err)
+	}
+	n := NewNotifier(srv.Client())
+	n.Backoff = time.Millisecond
+	ev := NewEvaluator(rules, n)
+	now := time.Now()
+	ev.now = func() time.Time { return now }
+
+	bad := model.LogEntry{Username: "root", IsBlacklisted: true}
+	ev.Observe(bad)
+	ev.Observe(model.LogEntry{Username: "alice"})
+	if len(ev.History()) != 0 {
+		t.Fatalf("rule fired below threshold")
+	}
+	ev.Observe(bad)
+	rc.wait(t)
+
+	var got Notification
+	if err := json.Unmarshal([]byte(rc.bodies[0]), &got); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if got.RuleID != "1" || got.Count != 2 || len(got.Entries) != 2 || rc.keys[0] != got.DedupeKey {
+		t.Fatalf("unexpected notification: %+v (key %q)", got, rc.keys[0])
+	}
+
+	// Still inside the cooldown.
+	ev.Observe(bad)
+	ev.Observe(bad)
+	if len(ev.History()) != 1 {
+		t.Fatalf("rule fired during cooldown")
+	}
+	now = now.Add(11 * time.Minute)
+	ev.Observe(bad)
+	ev.Observe(bad)
+	rc.wait(t)
+	if len(rc.keys) != 2 || rc.keys[0] == rc.keys[1] {
+		t.Fatalf("expected a second notification with a new dedupe key, got %v", rc.keys)
+	}
+}
+
+func TestWindowsFollowEventTime(t *testing.T) {
+	rc, srv := newReceiver(0)
+	defer srv.Close()
+	rules, _ := NewRuleStore("")
+	rules.Put(Rule{Name: "root", Query: "username=root", Threshold: 2, Window: Duration{time.Minute}, Cooldown: Duration{time.Hour}, Webhooks: []Webhook{{URL: srv.URL}}})
+	ev := NewEvaluator(rules, NewNotifier(srv.Client()))
+
+	// Arriving together does not put entries an hour apart in one window.
+	base := time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)
+	ev.Observe(model.LogEntry{Username: "root", Timestamp: base})
+	ev.Observe(model.LogEntry{Username: "root", Timestamp: base.Add(time.Hour)})
+	if len(ev.History()) != 0 {
+		t.Fatalf("rule fired for entries an hour apart")
+	}
+	// A delayed entry still counts where it belongs.
+	ev.Observe(model.LogEntry{Username: "root", Timestamp: base.Add(59*time.Minute + 30*time.Second)})
+	rc.wait(t)
+	h := ev.History()
+	if len(h) != 1 || !h[0].FirstSeen.Equal(base.Add(59*time.Minute+30*time.Second)) || !h[0].LastSeen.Equal(base.Add(time.Hour)) {
+		t.Fatalf("unexpected notification %+v", h)
+	}
+	// The cooldown follows event time as well.
+	ev.Observe(model.LogEntry{Username: "root", Timestamp: base.Add(2*time.Hour + time.Second)})
+	ev.Observe(model.LogEntry{Username: "root", Timestamp: base.Add(2*time.Hour + 2*time.Second)})
+	rc.wait(t)
+	if len(ev.History()) != 2 {
+		t.Fatalf("expected a second notification after the cooldown, got %+v", ev.History())
+	}
+}
+
+func TestNotifierDoesNotRetryClientErrors(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[r.URL.Path]++
+		switch {
+		case r.URL.Path == "/bad":
+			w.WriteHeader(http.StatusBadRequest)
+		case r.URL.Path == "/busy" && calls["/busy"] == 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	n := NewNotifier(srv.Client())
+	n.Backoff = time.Millisecond
+	n.Notify(Rule{ID: "1", Webhooks: []Webhook{{URL: srv.URL + "/bad"}, {URL: srv.URL + "/busy"}}}, Notification{})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		bad, busy := calls["/bad"], calls["/busy"]
+		mu.Unlock()
+		if busy == 2 {
+			if bad != 1 {
+				t.Fatalf("expected one attempt on 400, got %d", bad)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the 429 to be retried once, got %d attempts", busy)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTenantsCountedSeparately(t *testing.T) {
+	rc, srv := newReceiver(0)
+	defer srv.Close()
+	rules, _ := NewRuleStore("")
+	rules.Put(Rule{Name: "root", Query: "username=root", Threshold: 2, Window: Duration{time.Minute}, Webhooks: []Webhook{{URL: srv.URL}}})
+	ev := NewEvaluator(rules, NewNotifier(srv.Client()))
+	ev.Observe(model.LogEntry{Username: "root", Tenant: "team-a"})
+	ev.Observe(model.LogEntry{Username: "root", Tenant: "team-b"})
+	if len(ev.History()) != 0 {
+		t.Fatalf("entries of two tenants reached the threshold together")
+	}
+	ev.Observe(model.LogEntry{Username: "root", Tenant: "team-b"})
+	rc.wait(t)
+	var got Notification
+	if err := json.Unmarshal([]byte(rc.bodies[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Tenant != "team-b" || got.Count != 2 {
+		t.Fatalf("unexpected notification %+v", got)
+	}
+	for _, e := range got.Entries {
+		if e.Tenant != "team-b" {
+			t.Fatalf("notification carries another tenant's entry: %+v", e)
+		}
+	}
+}
+
+func TestTemplatedPayload(t *testing.T) {
+	rc, srv := newReceiver(0)
+	defer srv.Close()
+	rules, _ := NewRuleStore("")
+	rules.Put(Rule{
+		Name:      "errors",
+		Query:     "level=error",
+		Threshold: 1,
+		Webhooks:  []Webhook{{URL: srv.URL, Template: `{"text":"{{.RuleName}}: {{.Count}} event(s), last user {{(index .Entries 0).Username}}"}`}},
+	})
+	if r, _ := rules.Get("1"); r.Webhooks[0].tmpl == nil {
+		t.Fatalf("expected the template to be parsed when the rule is stored")
+	}
+	ev := NewEvaluator(rules, NewNotifier(srv.Client()))
+	ev.Observe(model.LogEntry{Severity: "ERROR", Username: "bob"})
+	rc.wait(t)
+	if rc.bodies[0] != `{"text":"errors: 1 event(s), last user bob"}` {
+		t.Fatalf("unexpected body %s", rc.bodies[0])
+	}
+}
+
+func TestRuleStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	s, err := NewRuleStore(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := s.Put(Rule{Query: "level=error", Threshold: 0}); err == nil {
+		t.Fatalf("expected validation error")
+	}
+	r, err := s.Put(Rule{Name: "r", Query: "level=error", Threshold: 3, Window: Duration{5 * time.Minute}})
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	reopened, err := NewRuleStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	got, err := reopened.Get(r.ID)
+	if err != nil || got.Window.Duration != 5*time.Minute || got.Threshold != 3 {
+		t.Fatalf("unexpected rule %+v (%v)", got, err)
+	}
+	if next, _ := reopened.Put(Rule{Query: "level=warn", Threshold: 1}); next.ID == r.ID {
+		t.Fatalf("new rule reused id %s", next.ID)
+	}
+	if err := reopened.Delete(r.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := reopened.Get(r.ID); err != ErrRuleNotFound {
+		t.Fatalf("expected ErrRuleNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"motadata/internal/model"
+	"motadata/internal/storage"
+)
+
+// Notification is what webhooks receive, either as JSON or as the data for
+// a webhook template.
+type Notification struct {
+	RuleID    string           `json:"rule.id"`
+	RuleName  string           `json:"rule.name"`
+	Tenant    string           `json:"tenant,omitempty"`
+	Count     int              `json:"count"`
+	Window    string           `json:"window"`
+	FirstSeen time.Time        `json:"first.seen"`
+	LastSeen  time.Time        `json:"last.seen"`
+	FiredAt   time.Time        `json:"fired.at"`
+	DedupeKey string           `json:"dedupe.key"`
+	Entries   []model.LogEntry `json:"entries"`
+}
+
+const maxSampleEntries = 10
+
+type hit struct {
+	at    time.Time
+	entry model.LogEntry
+}
+
+// stateKey separates the windows of each tenant, so entries of different
+// tenants are never counted or reported together.
+type stateKey struct {
+	tenant, rule string
+}
+
+type ruleState struct {
+	query  string
+	filter storage.QueryFilter
+	hits   []hit     // at most Threshold, oldest first
+	fired  time.Time // event time of the last notification
+}
+
+// Evaluator matches every ingested entry against the stored rules and hands
+// fired notifications to the notifier.
+type Evaluator struct {
+	mu       sync.Mutex
+	rules    *RuleStore
+	notifier *Notifier
+	now      func() time.Time
+	state    map[stateKey]*ruleState
+	history  []Notification
+}
+
+func NewEvaluator(rules *RuleStore, notifier *Notifier) *Evaluator {
+	return &Evaluator{
+		rules:    rules,
+		notifier: notifier,
+		now:      time.Now,
+		state:    make(map[stateKey]*ruleState),
+	}
+}
+
+func (ev *Evaluator) Rules() *RuleStore {
+	return ev.rules
+}
+
+// Observe counts e against every rule it matches. Windows and cooldowns
+// follow the entries' own timestamps, so replayed or delayed batches count
+// as they happened; entries without one count at the time they arrive.
+func (ev *Evaluator) Observe(e model.LogEntry) {
+	rules := ev.rules.List()
+	now := ev.now()
+	at := e.Timestamp
+	if at.IsZero() {
+		at = now
+	}
+	var fired []firedRule
+	ev.mu.Lock()
+	live := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		live[r.ID] = true
+		st := ev.stateLocked(r, e.Tenant)
+		if st == nil || !st.filter.Matches(e) {
+			continue
+		}
+		// Late entries are inserted in order; the window ends at the
+		// newest one.
+		j := sort.Search(len(st.hits), func(j int) bool { return st.hits[j].at.After(at) })
+		st.hits = append(st.hits, hit{})
+		copy(st.hits[j+1:], st.hits[j:])
+		st.hits[j] = hit{at: at, entry: e}
+		last := st.hits[len(st.hits)-1].at
+		i := 0
+		if r.Window.Duration > 0 {
+			cutoff := last.Add(-r.Window.Duration)
+			for i < len(st.hits) && !st.hits[i].at.After(cutoff) {
+				i++
+			}
+		}
+		st.hits = st.hits[i:]
+		if over := len(st.hits) - r.Threshold; over > 0 {
+			st.hits = st.hits[over:]
+		}
+		count := len(st.hits)
+		if count < r.Threshold || (!st.fired.IsZero() && last.Sub(st.fired) < r.Cooldown.Duration) {
+			continue
+		}
+		first := st.hits[0].at
+		sample := st.hits
+		if len(sample) > maxSampleEntries {
+			sample = sample[len(sample)-maxSampleEntries:]
+		}
+		entries := make([]model.LogEntry, 0, len(sample))
+		for _, h := range sample {
+			entries = append(entries, h.entry)
+		}
+		n := Notification{
+			RuleID:    r.ID,
+			RuleName:  r.Name,
+			Tenant:    e.Tenant,
+			Count:     count,
+			Window:    r.Window.String(),
+			FirstSeen: first,
+			LastSeen:  last,
+			FiredAt:   now,
+			DedupeKey: dedupeKey(r.ID, e.Tenant, first),
+			Entries:   entries,
+		}
+		st.fired = last
+		st.hits = st.hits[:0]
+		ev.history = append(ev.history, n)
+		if len(ev.history) > 1000 {
+			ev.history = ev.history[len(ev.history)-1000:]
+		}
+		fired = append(fired, firedRule{rule: r, n: n})
+	}
+	for k := range ev.state {
+		if !live[k.rule] {
+			delete(ev.state, k)
+		}
+	}
+	ev.mu.Unlock()
+	for _, f := range fired {
+		ev.notifier.Notify(f.rule, f.n)
+	}
+}
+
+type firedRule struct {
+	rule Rule
+	n    Notification
+}
+
+func dedupeKey(rule, tenant string, first time.Time) string {
+	if tenant == "" {
+		return fmt.Sprintf("%s-%d", rule, first.UnixNano())
+	}
+	return fmt.Sprintf("%s-%s-%d", rule, tenant, first.UnixNano())
+}
+
+func (ev *Evaluator) stateLocked(r Rule, tenant string) *ruleState {
+	k := stateKey{tenant: tenant, rule: r.ID}
+	st, ok := ev.state[k]
+	if ok && st.query == r.Query {
+		return st
+	}
+	f, err := r.Filter()
+	if err != nil {
+		return nil
+	}
+	// Matching is per entry; limit and sort only apply to /logs queries.
+	f.Limit, f.SortBy = 0, ""
+	st = &ruleState{query: r.Query, filter: f}
+	ev.state[k] = st
+	return st
+}
+
+// History returns the most recent notifications, newest last.
+func (ev *Evaluator) History() []Notification {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	return append([]Notification(nil), ev.history...)
+}
@@ -0,0 +1,149 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type delivery struct {
+	hook Webhook
+	n    Notification
+}
+
+// Notifier posts notifications to webhooks from a background queue,
+// retrying failed deliveries with exponential backoff. Client errors other
+// than 408 and 429, and templates that fail to render, are not retried.
+type Notifier struct {
+	client      *http.Client
+	queue       chan delivery
+	start       sync.Once
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+func NewNotifier(client *http.Client) *Notifier {
+	n := &Notifier{
+		client:      client,
+		queue:       make(chan delivery, 256),
+		MaxAttempts: 4,
+		Backoff:     500 * time.Millisecond,
+	}
+	return n
+}
+
+func (n *Notifier) Notify(r Rule, note Notification) {
+	n.start.Do(func() { go n.run() })
+	for _, h := range r.Webhooks {
+		select {
+		case n.queue <- delivery{hook: h, n: note}:
+		default:
+			log.Printf("alert %s: notification queue full, dropping webhook %s", r.ID, h.URL)
+		}
+	}
+}
+
+func (n *Notifier) run() {
+	for d := range n.queue {
+		backoff := n.Backoff
+		var err error
+		for attempt := 1; attempt <= n.MaxAttempts; attempt++ {
+			if err = n.send(d); err == nil || permanent(err) {
+				break
+			}
+			if attempt < n.MaxAttempts {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		}
+		if err != nil {
+			log.Printf("alert %s: webhook %s failed: %v", d.n.RuleID, d.hook.URL, err)
+		}
+	}
+}
+
+// statusError is a webhook's answer outside 2xx.
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("webhook returned status %d", int(e))
+}
+
+// renderError is a template that failed; sending again renders it the
+// same way.
+type renderError struct{ error }
+
+func permanent(err error) bool {
+	if code, ok := err.(statusError); ok {
+		switch code {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return false
+		}
+		return code >= 400 && code < 500
+	}
+	_, ok := err.(renderError)
+	return ok
+}
+
+func (n *Notifier) send(d delivery) error {
+	body, err := render(d.hook, d.n)
+	if err != nil {
+		return renderError{err}
+	}
+	req, err := http.NewRequest(http.MethodPost, d.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Dedupe-Key", d.n.DedupeKey)
+	for k, v := range d.hook.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return statusError(resp.StatusCode)
+	}
+	return nil
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// render uses the template parsed when the rule was stored, if any.
+func render(h Webhook, n Notification) ([]byte, error) {
+	t := h.tmpl
+	if t == nil && h.Template != "" {
+		var err error
+		if t, err = parseTemplate(h.Template); err != nil {
+			return nil, err
+		}
+	}
+	if t == nil {
+		return json.Marshal(n)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, n); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,224 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"motadata/internal/storage"
+)
+
+// Rule fires when at least Threshold entries matching Query arrive within
+// Window, and then stays quiet for Cooldown.
+type Rule struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Query     string    `json:"query"` // /logs query string, e.g. "category=alert.detection&level=error"
+	Threshold int       `json:"threshold"`
+	Window    Duration  `json:"window"`
+	Cooldown  Duration  `json:"cooldown"`
+	Webhooks  []Webhook `json:"webhooks"`
+}
+
+type Webhook struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Template is a text/template rendered with the Notification; the
+	// notification is sent as JSON when empty.
+	Template string `json:"template,omitempty"`
+
+	tmpl *template.Template // Template, parsed when the rule is stored
+}
+
+// withTemplates returns r with the templates of its webhooks parsed, so
+// that notifications do not parse them again.
+func (r Rule) withTemplates() Rule {
+	hooks := make([]Webhook, len(r.Webhooks))
+	for i, w := range r.Webhooks {
+		w.tmpl, _ = parseTemplate(w.Template)
+		hooks[i] = w
+	}
+	r.Webhooks = hooks
+	return r
+}
+
+func (r Rule) Filter() (storage.QueryFilter, error) {
+	q, err := url.ParseQuery(r.Query)
+	if err != nil {
+		return storage.QueryFilter{}, err
+	}
+	return storage.ParseQueryFilter(q), nil
+}
+
+func (r Rule) Validate() error {
+	if r.Threshold < 1 {
+		return errors.New("threshold must be at least 1")
+	}
+	if r.Window.Duration < 0 || r.Cooldown.Duration < 0 {
+		return errors.New("window and cooldown must not be negative")
+	}
+	if _, err := r.Filter(); err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+	for _, w := range r.Webhooks {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid webhook url %q", w.URL)
+		}
+		if _, err := parseTemplate(w.Template); err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+	return nil
+}
+
+// Duration marshals as a Go duration string such as "5m".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var secs float64
+		if err := json.Unmarshal(b, &secs); err != nil {
+			return err
+		}
+		d.Duration = time.Duration(secs * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+var ErrRuleNotFound = errors.New("alert rule not found")
+
+// RuleStore keeps alert rules, persisted as a JSON array when a path is
+// configured.
+type RuleStore struct {
+	mu    sync.RWMutex
+	path  string
+	seq   int
+	rules map[string]Rule
+}
+
+func NewRuleStore(path string) (*RuleStore, error) {
+	s := &RuleStore{path: path, rules: make(map[string]Rule)}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, r := range rules {
+		s.rules[r.ID] = r.withTemplates()
+		if n, err := strconv.Atoi(r.ID); err == nil && n > s.seq {
+			s.seq = n
+		}
+	}
+	return s, nil
+}
+
+func (s *RuleStore) List() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Rule, 0, len(s.rules))
+	for _, r := range s.rules {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (s *RuleStore) Get(id string) (Rule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.rules[id]
+	if !ok {
+		return Rule{}, ErrRuleNotFound
+	}
+	return r, nil
+}
+
+// Put creates or replaces a rule and returns it with its ID assigned.
+func (s *RuleStore) Put(r Rule) (Rule, error) {
+	if err := r.Validate(); err != nil {
+		return Rule{}, err
+	}
+	r = r.withTemplates()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.ID == "" {
+		s.seq++
+		r.ID = strconv.Itoa(s.seq)
+	}
+	prev, existed := s.rules[r.ID]
+	s.rules[r.ID] = r
+	if err := s.saveLocked(); err != nil {
+		if existed {
+			s.rules[r.ID] = prev
+		} else {
+			delete(s.rules, r.ID)
+		}
+		return Rule{}, err
+	}
+	return r, nil
+}
+
+func (s *RuleStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.rules[id]
+	if !ok {
+		return ErrRuleNotFound
+	}
+	delete(s.rules, id)
+	if err := s.saveLocked(); err != nil {
+		s.rules[id] = prev
+		return err
+	}
+	return nil
+}
+
+func (s *RuleStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	rules := make([]Rule, 0, len(s.rules))
+	for _, r := range s.rules {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	b, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
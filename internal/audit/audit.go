@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record describes one audited request.
+type Record struct {
+	Seq       uint64            `json:"seq"`
+	Time      time.Time         `json:"time"`
+	Principal string            `json:"principal"`
+	Role      string            `json:"role,omitempty"`
+	Tenant    string            `json:"tenant,omitempty"`
+	ClientIP  string            `json:"client_ip,omitempty"`
+	Action    string            `json:"action"` // e.g. logs.query, logs.export, alerts.rule.delete
+	Method    string            `json:"method,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Filter    map[string]string `json:"filter,omitempty"`
+	Target    string            `json:"target,omitempty"` // the object an admin action changed
+	Results   int               `json:"results"`
+	Status    int               `json:"status"`
+
+	// Hash covers every other field and PrevHash, chaining each record to
+	// the one before it.
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+func (r Record) digest() string {
+	r.Hash = ""
+	b, _ := json.Marshal(r)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log is an append-only, hash-chained audit log. Records are kept in
+// memory and, when a path is given, appended to a JSONL file that is never
+// rewritten. Changing, removing or reordering records breaks the chain,
+// which Verify reports.
+type Log struct {
+	mu      sync.Mutex
+	file    *os.File
+	records []Record
+}
+
+var ErrBroken = errors.New("audit: hash chain broken")
+
+// Open loads the log at path, or keeps it in memory only if path is empty.
+// A log that fails verification is still opened, so the evidence is kept
+// and new records chain onto the last one.
+func Open(path string) (*Log, error) {
+	l := &Log{}
+	if path == "" {
+		return l, nil
+	}
+	if f, err := os.Open(path); err == nil {
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 0, 64<<10), 16<<20)
+		for sc.Scan() {
+			var r Record
+			if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s: record %d: %w", path, len(l.records)+1, err)
+			}
+			l.records = append(l.records, r)
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	return l, nil
+}
+
+// Append completes r with its sequence number, time and hashes, writes it
+// and returns it.
+func (l *Log) Append(r Record) (Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Seq = 1
+	r.PrevHash = ""
+	if n := len(l.records); n > 0 {
+		r.Seq = l.records[n-1].Seq + 1
+		r.PrevHash = l.records[n-1].Hash
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	r.Hash = r.digest()
+	if l.file != nil {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return r, err
+		}
+		if _, err := l.file.Write(append(b, '\n')); err != nil {
+			return r, err
+		}
+		if err := l.file.Sync(); err != nil {
+			return r, err
+		}
+	}
+	l.records = append(l.records, r)
+	return r, nil
+}
+
+// Filter selects records; empty fields match everything.
+type Filter struct {
+	Principal string
+	Action    string // exact, or a prefix ending in "."
+	Tenant    string
+	Since     time.Time
+	Until     time.Time
+	Limit     int // newest records win
+}
+
+func (f Filter) matches(r Record) bool {
+	if f.Principal != "" && r.Principal != f.Principal {
+		return false
+	}
+	if f.Action != "" && r.Action != f.Action && !(strings.HasSuffix(f.Action, ".") && strings.HasPrefix(r.Action, f.Action)) {
+		return false
+	}
+	if f.Tenant != "" && r.Tenant != f.Tenant {
+		return false
+	}
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !r.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Query returns matching records, oldest first.
+func (l *Log) Query(f Filter) []Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Record, 0)
+	for _, r := range l.records {
+		if f.matches(r) {
+			out = append(out, r)
+		}
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+	return out
+}
+
+// Verify checks the chain and returns the number of records checked. On
+// failure the error names the first record that does not match.
+func (l *Log) Verify() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prev := ""
+	for i, r := range l.records {
+		switch {
+		case r.Seq != uint64(i+1):
+			return i, fmt.Errorf("%w: record %d has sequence number %d", ErrBroken, i+1, r.Seq)
+		case r.PrevHash != prev:
+			return i, fmt.Errorf("%w: record %d does not follow the one before it", ErrBroken, r.Seq)
+		case r.digest() != r.Hash:
+			return i, fmt.Errorf("%w: record %d was modified", ErrBroken, r.Seq)
+		}
+		prev = r.Hash
+	}
+	return len(l.records), nil
+}
+
+func (l *Log) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChainSurvivesReopenAndDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"alice", "bob"} {
+		if _, err := l.Append(Record{Principal: p, Action: "logs.query", Filter: map[string]string{"username": "root"}, Results: 3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	// New records chain onto the ones already on disk.
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := l.Append(Record{Principal: "ops", Action: "alerts.rule.delete", Target: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Seq != 3 || r.PrevHash == "" {
+		t.Fatalf("expected record 3 chained to record 2, got %+v", r)
+	}
+	if n, err := l.Verify(); err != nil || n != 3 {
+		t.Fatalf("verify: %d, %v", n, err)
+	}
+	if got := l.Query(Filter{Action: "logs."}); len(got) != 2 || got[1].Principal != "bob" {
+		t.Fatalf("unexpected query result %+v", got)
+	}
+	if got := l.Query(Filter{Principal: "alice", Limit: 1}); len(got) != 1 || got[0].Seq != 1 {
+		t.Fatalf("unexpected query result %+v", got)
+	}
+	l.Close()
+
+	// Rewriting what alice searched for breaks the chain at her record.
+	b, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(b), `"username":"root"`, `"username":"guest"`, 1)), 0o600)
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if n, err := l.Verify(); !errors.Is(err, ErrBroken) || n != 0 {
+		t.Fatalf("expected the first record to fail verification, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,85 @@
+package auditd
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	userAuth  = `type=USER_AUTH msg=audit(1722256524.120:4567): pid=4242 uid=0 auid=4294967295 ses=4294967295 subj=unconfined msg='op=PAM:authentication grantors=? acct="alice" exe="/usr/sbin/sshd" hostname=203.0.113.9 addr=203.0.113.9 terminal=ssh res=failed'` + "\x1d" + `UID="root" AUID="unset"`
+	userLogin = `node=web01 type=USER_LOGIN msg=audit(1722256530.001:4570): pid=4300 uid=0 auid=1000 ses=7 msg='op=login id=1000 exe="/usr/sbin/sshd" hostname=? addr=203.0.113.9 terminal=/dev/pts/0 res=success'`
+	hexAcct   = `type=USER_ACCT msg=audit(1722256531.5:4571): pid=4301 uid=0 msg='op=PAM:accounting acct=6A6F686E20646F65 exe="/usr/bin/su" terminal=pts/1 res=success'`
+)
+
+func TestParseLine(t *testing.T) {
+	r, err := ParseLine(userAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != "USER_AUTH" || r.Serial != 4567 {
+		t.Fatalf("unexpected header %+v", r)
+	}
+	if want := time.Date(2024, 7, 29, 12, 35, 24, 120000000, time.UTC); !r.Time.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, r.Time)
+	}
+	for k, want := range map[string]string{
+		"pid": "4242", "op": "PAM:authentication", "acct": "alice", "exe": "/usr/sbin/sshd",
+		"addr": "203.0.113.9", "terminal": "ssh", "res": "failed", "UID": "root",
+	} {
+		if r.Fields[k] != want {
+			t.Fatalf("field %s: expected %q, got %q", k, want, r.Fields[k])
+		}
+	}
+
+	r, err = ParseLine("Jul 29 12:35:30 web01 audit: " + userLogin)
+	if err != nil || r.Node != "web01" || r.Fields["id"] != "1000" || r.Fields["terminal"] != "/dev/pts/0" {
+		t.Fatalf("unexpected record %+v, %v", r, err)
+	}
+	r, err = ParseLine(hexAcct)
+	if err != nil || r.Fields["acct"] != "john doe" || r.Time.Nanosecond() != 500000000 {
+		t.Fatalf("expected hex-decoded acct, got %+v, %v", r, err)
+	}
+	if _, err := ParseLine("Jul 29 sshd[1]: Accepted password"); err != ErrNotAudit {
+		t.Fatalf("expected ErrNotAudit, got %v", err)
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	var got []Event
+	a := NewAggregator(time.Second, func(ev Event) { got = append(got, ev) })
+	now := time.Unix(1000, 0)
+	a.now = func() time.Time { return now }
+
+	for _, l := range []string{
+		`type=SYSCALL msg=audit(1722256524.000:10): arch=c000003e syscall=59 success=yes exit=0 comm="passwd"`,
+		userAuth,
+		`type=EXECVE msg=audit(1722256524.000:10): argc=1 a0="passwd"`,
+		`type=EOE msg=audit(1722256524.000:10):`,
+	} {
+		r, err := ParseLine(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Add(r)
+	}
+	if len(got) != 1 || got[0].Serial != 10 || len(got[0].Records) != 2 {
+		t.Fatalf("expected EOE to complete serial 10, got %+v", got)
+	}
+
+	a.Flush()
+	if len(got) != 1 {
+		t.Fatal("expected pending event to wait for the timeout")
+	}
+	now = now.Add(2 * time.Second)
+	a.Flush()
+	if len(got) != 2 {
+		t.Fatalf("expected timed-out event, got %d", len(got))
+	}
+	ev := got[1]
+	if ev.Username() != "alice" || ev.Outcome() != "failure" || !ev.IsLogin() || ev.Field("addr") != "203.0.113.9" {
+		t.Fatalf("unexpected event mapping %+v", ev)
+	}
+	if ev.Field("hostname") != "203.0.113.9" || ev.Types()[0] != "USER_AUTH" {
+		t.Fatalf("unexpected event fields %+v", ev)
+	}
+}
@@ -0,0 +1,167 @@
+package auditd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is the set of records auditd wrote for one serial number.
+type Event struct {
+	Node    string
+	Serial  uint64
+	Time    time.Time
+	Records []Record
+}
+
+// Types lists the record types in arrival order.
+func (ev Event) Types() []string {
+	out := make([]string, 0, len(ev.Records))
+	for _, r := range ev.Records {
+		out = append(out, r.Type)
+	}
+	return out
+}
+
+// Field returns the first non-empty, non-"?" value of name across records.
+func (ev Event) Field(name string) string {
+	for _, r := range ev.Records {
+		if v := r.Fields[name]; v != "" && v != "?" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Username is the account the event is about.
+func (ev Event) Username() string {
+	if u := ev.Field("acct"); u != "" {
+		return u
+	}
+	if u := ev.Field("AUID"); u != "unset" {
+		return u
+	}
+	return ""
+}
+
+// Outcome maps res=success/failed to "success" or "failure".
+func (ev Event) Outcome() string {
+	switch strings.ToLower(ev.Field("res")) {
+	case "success", "yes", "1":
+		return "success"
+	case "failed", "no", "0":
+		return "failure"
+	}
+	return ""
+}
+
+// IsLogin reports events of the PAM and login record types.
+func (ev Event) IsLogin() bool {
+	for _, r := range ev.Records {
+		switch r.Type {
+		case "USER_LOGIN", "USER_LOGOUT", "USER_AUTH", "USER_ACCT", "USER_START", "USER_END",
+			"CRED_ACQ", "CRED_DISP", "CRED_REFR", "USER_ERR", "ANOM_LOGIN_FAILURES":
+			return true
+		}
+	}
+	return false
+}
+
+// Raw joins the original lines.
+func (ev Event) Raw() string {
+	lines := make([]string, 0, len(ev.Records))
+	for _, r := range ev.Records {
+		lines = append(lines, r.Raw)
+	}
+	return strings.Join(lines, "\n")
+}
+
+type key struct {
+	node   string
+	serial uint64
+}
+
+type pending struct {
+	ev      Event
+	arrived time.Time
+}
+
+// Aggregator groups records by (node, serial). An event is emitted when
+// its EOE record arrives or, since most login events have no EOE, once no
+// record for it was seen for the timeout.
+type Aggregator struct {
+	timeout time.Duration
+	emit    func(Event)
+	now     func() time.Time
+
+	mu      sync.Mutex
+	pending map[key]*pending
+}
+
+func NewAggregator(timeout time.Duration, emit func(Event)) *Aggregator {
+	return &Aggregator{timeout: timeout, emit: emit, now: time.Now, pending: make(map[key]*pending)}
+}
+
+func (a *Aggregator) Add(r Record) {
+	k := key{r.Node, r.Serial}
+	a.mu.Lock()
+	p, ok := a.pending[k]
+	if !ok {
+		p = &pending{ev: Event{Node: r.Node, Serial: r.Serial, Time: r.Time}}
+		a.pending[k] = p
+	}
+	p.arrived = a.now()
+	if r.Type == "EOE" {
+		delete(a.pending, k)
+		a.mu.Unlock()
+		if len(p.ev.Records) > 0 {
+			a.emit(p.ev)
+		}
+		return
+	}
+	p.ev.Records = append(p.ev.Records, r)
+	a.mu.Unlock()
+}
+
+// Flush emits events idle for longer than the timeout, oldest serial first.
+func (a *Aggregator) Flush() {
+	a.flush(false)
+}
+
+// FlushAll emits every pending event.
+func (a *Aggregator) FlushAll() {
+	a.flush(true)
+}
+
+func (a *Aggregator) flush(all bool) {
+	cutoff := a.now().Add(-a.timeout)
+	var out []Event
+	a.mu.Lock()
+	for k, p := range a.pending {
+		if all || p.arrived.Before(cutoff) {
+			out = append(out, p.ev)
+			delete(a.pending, k)
+		}
+	}
+	a.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Serial < out[j].Serial })
+	for _, ev := range out {
+		a.emit(ev)
+	}
+}
+
+// Run flushes idle events until stop is closed.
+func (a *Aggregator) Run(stop <-chan struct{}) {
+	t := time.NewTicker(a.timeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			a.FlushAll()
+			return
+		case <-t.C:
+			a.Flush()
+		}
+	}
+}
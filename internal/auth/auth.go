@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type Role string
+
+const (
+	RoleIngest Role = "ingest" // collectors
+	RoleRead   Role = "read"   // analysts
+	RoleAdmin  Role = "admin"  // everything, including rule changes
+)
+
+// Allows reports whether r may act with role want.
+func (r Role) Allows(want Role) bool {
+	return r == RoleAdmin || r == want
+}
+
+// Key is an entry of the keys file. Only the SHA-256 of the key is stored;
+// keys are random, so a plain hash is enough to keep them from being
+// recovered from the file.
+type Key struct {
+	Name   string `json:"name"`
+	Role   Role   `json:"role"`
+	Hash   string `json:"hash"`             // "sha256:<hex>"
+	Tenant string `json:"tenant,omitempty"` // binds the key to one tenant
+}
+
+type Principal struct {
+	Name   string `json:"name"`
+	Role   Role   `json:"role"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Anonymous is the principal of every request when authentication is off.
+var Anonymous = Principal{Name: "anonymous", Role: RoleAdmin}
+
+// HashKey returns the form a key is stored in.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// GenerateKey returns a new random key.
+func GenerateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Authenticator maps API keys to principals. A nil *Authenticator lets
+// every request through as Anonymous.
+type Authenticator struct {
+	keys map[string]Principal // by hash
+}
+
+func New(keys []Key) (*Authenticator, error) {
+	a := &Authenticator{keys: make(map[string]Principal)}
+	for _, k := range keys {
+		switch k.Role {
+		case RoleIngest, RoleRead, RoleAdmin:
+		default:
+			return nil, fmt.Errorf("key %q: unknown role %q", k.Name, k.Role)
+		}
+		if !strings.HasPrefix(k.Hash, "sha256:") {
+			return nil, fmt.Errorf("key %q: hash must start with sha256:", k.Name)
+		}
+		a.keys[strings.ToLower(k.Hash)] = Principal{Name: k.Name, Role: k.Role, Tenant: k.Tenant}
+	}
+	return a, nil
+}
+
+// LoadFile reads a JSON array of keys.
+func LoadFile(path string) (*Authenticator, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return New(keys)
+}
+
+// Authenticate looks up the key sent as "Authorization: Bearer <key>" or
+// "X-API-Key: <key>".
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, bool) {
+	if a == nil {
+		return Anonymous, true
+	}
+	key := r.Header.Get("X-API-Key")
+	if h := r.Header.Get("Authorization"); key == "" && len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		key = strings.TrimSpace(h[7:])
+	}
+	if key == "" {
+		return Principal{}, false
+	}
+	p, ok := a.keys[HashKey(key)]
+	return p, ok
+}
+
+type ctxKey struct{}
+
+// FromContext returns the principal Middleware attached to a request.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(Principal)
+	return p, ok
+}
+
+// Middleware attaches the request's principal, if its key is valid, and
+// logs every request with the principal that made it. It does not reject
+// anything; routes do that with Require.
+func (a *Authenticator) Middleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			name := "-"
+			if p, ok := a.Authenticate(r); ok {
+				r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, p))
+				name = p.Name
+			}
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			log.Printf("%s %s %d principal=%s ip=%s %s", r.Method, r.URL.Path, sw.status, name, ClientIP(r), time.Since(start).Round(time.Microsecond))
+		})
+	}
+}
+
+// Require wraps h so that it only runs for principals allowed role:
+// 401 without a valid key, 403 with one of another role.
+func Require(role Role, h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := FromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !p.Role.Allows(role) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	})
+}
+
+// ClientIP returns the host part of the request's remote address.
+func ClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequireRoles(t *testing.T) {
+	a, err := New([]Key{
+		{Name: "collector-1", Role: RoleIngest, Hash: HashKey("ingest-key")},
+		{Name: "analyst", Role: RoleRead, Hash: HashKey("read-key")},
+		{Name: "ops", Role: RoleAdmin, Hash: HashKey("admin-key")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen string
+	r := mux.NewRouter()
+	r.Use(a.Middleware())
+	r.Handle("/logs", Require(RoleRead, func(w http.ResponseWriter, r *http.Request) {
+		p, _ := FromContext(r.Context())
+		seen = p.Name
+	}))
+
+	cases := []struct {
+		header, value string
+		want          int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"Authorization", "Bearer wrong", http.StatusUnauthorized},
+		{"Authorization", "Bearer ingest-key", http.StatusForbidden},
+		{"Authorization", "bearer read-key", http.StatusOK},
+		{"X-API-Key", "admin-key", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/logs", nil)
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Fatalf("%s %q: expected %d, got %d", c.header, c.value, c.want, w.Code)
+		}
+	}
+	if seen != "ops" {
+		t.Fatalf("expected handler to see the admin principal, got %q", seen)
+	}
+}
+
+func TestDisabledAndInvalidKeys(t *testing.T) {
+	var a *Authenticator
+	if p, ok := a.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); !ok || p != Anonymous {
+		t.Fatalf("expected anonymous access without keys, got %+v", p)
+	}
+	if _, err := New([]Key{{Name: "x", Role: "root", Hash: HashKey("k")}}); err == nil {
+		t.Fatal("expected unknown role error")
+	}
+	if _, err := New([]Key{{Name: "x", Role: RoleRead, Hash: "plaintext"}}); err == nil {
+		t.Fatal("expected hash format error")
+	}
+}
@@ -0,0 +1,116 @@
+package dedup
+
+import (
+	"sync"
+	"time"
+
+	"motadata/internal/model"
+)
+
+type key struct {
+	tenant, host, service, message string
+}
+
+type group struct {
+	entry   model.LogEntry
+	arrived time.Time // starts the window
+	first   time.Time // event timestamps
+	last    time.Time
+	count   int
+}
+
+// Deduper collapses identical (hostname, service, message) entries seen
+// within the window into one entry carrying the repeat count and the
+// first/last-seen times. Entries are held until their window closes.
+type Deduper struct {
+	window     time.Duration
+	maxPending int
+	emit       func(model.LogEntry)
+	now        func() time.Time
+
+	mu      sync.Mutex
+	pending map[key]*group
+}
+
+func New(window time.Duration, emit func(model.LogEntry)) *Deduper {
+	return &Deduper{
+		window:     window,
+		maxPending: 10000,
+		emit:       emit,
+		now:        time.Now,
+		pending:    make(map[key]*group),
+	}
+}
+
+func (d *Deduper) Add(e model.LogEntry) {
+	k := key{e.Tenant, e.Hostname, e.Service, e.RawMessage}
+	now := d.now()
+	d.mu.Lock()
+	if g, ok := d.pending[k]; ok {
+		g.count++
+		if e.Timestamp.Before(g.first) {
+			g.first = e.Timestamp
+		}
+		if e.Timestamp.After(g.last) {
+			g.last = e.Timestamp
+		}
+		d.mu.Unlock()
+		return
+	}
+	if len(d.pending) >= d.maxPending {
+		d.mu.Unlock()
+		d.emit(e)
+		return
+	}
+	d.pending[k] = &group{entry: e, arrived: now, first: e.Timestamp, last: e.Timestamp, count: 1}
+	d.mu.Unlock()
+}
+
+// Flush emits every group whose window has closed.
+func (d *Deduper) Flush() {
+	d.flush(false)
+}
+
+// FlushAll emits every pending group, e.g. on shutdown.
+func (d *Deduper) FlushAll() {
+	d.flush(true)
+}
+
+func (d *Deduper) flush(all bool) {
+	cutoff := d.now().Add(-d.window)
+	var out []model.LogEntry
+	d.mu.Lock()
+	for k, g := range d.pending {
+		if !all && g.arrived.After(cutoff) {
+			continue
+		}
+		delete(d.pending, k)
+		e := g.entry
+		if g.count > 1 {
+			first, last := g.first, g.last
+			e.RepeatCount = g.count
+			e.FirstSeen = &first
+			e.LastSeen = &last
+		}
+		out = append(out, e)
+	}
+	d.mu.Unlock()
+	for _, e := range out {
+		d.emit(e)
+	}
+}
+
+// Run flushes closed windows until stop is closed, then flushes the rest.
+func (d *Deduper) Run(stop <-chan struct{}) {
+	t := time.NewTicker(max(d.window/4, 10*time.Millisecond))
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			d.Flush()
+		case <-stop:
+			d.FlushAll()
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"motadata/internal/model"
+)
+
+func TestCollapseWithinWindow(t *testing.T) {
+	var out []model.LogEntry
+	d := New(time.Minute, func(e model.LogEntry) { out = append(out, e) })
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	base := time.Date(2025, 7, 29, 12, 0, 0, 0, time.UTC)
+	msg := model.LogEntry{Hostname: "h1", Service: "linux_login_audit", RawMessage: "Failed password for bob"}
+	for i := 0; i < 3; i++ {
+		e := msg
+		e.Timestamp = base.Add(time.Duration(i) * time.Second)
+		d.Add(e)
+	}
+	other := msg
+	other.Hostname = "h2"
+	d.Add(other)
+
+	d.Flush()
+	if len(out) != 0 {
+		t.Fatalf("entries emitted before the window closed: %+v", out)
+	}
+	now = now.Add(time.Minute + time.Second)
+	d.Flush()
+	if len(out) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", out)
+	}
+	var repeated, single model.LogEntry
+	for _, e := range out {
+		if e.Hostname == "h1" {
+			repeated = e
+		} else {
+			single = e
+		}
+	}
+	if repeated.RepeatCount != 3 || !repeated.FirstSeen.Equal(base) || !repeated.LastSeen.Equal(base.Add(2*time.Second)) {
+		t.Fatalf("unexpected collapsed entry: %+v", repeated)
+	}
+	if single.RepeatCount != 0 || single.FirstSeen != nil {
+		t.Fatalf("single entries must be passed through unchanged: %+v", single)
+	}
+}
+
+func TestFlushAllAndPendingCap(t *testing.T) {
+	var out []model.LogEntry
+	d := New(time.Hour, func(e model.LogEntry) { out = append(out, e) })
+	d.maxPending = 1
+	d.Add(model.LogEntry{RawMessage: "a"})
+	d.Add(model.LogEntry{RawMessage: "b"})
+	if len(out) != 1 || out[0].RawMessage != "b" {
+		t.Fatalf("expected overflow entry to pass through, got %+v", out)
+	}
+	d.FlushAll()
+	if len(out) != 2 {
+		t.Fatalf("expected pending entry on FlushAll, got %+v", out)
+	}
+}
@@ -0,0 +1,128 @@
+package detect
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type BlacklistEntry struct {
+	Tenant    string    `json:"tenant,omitempty"`
+	IP        string    `json:"ip"`
+	Rule      string    `json:"rule"`
+	AddedAt   time.Time `json:"added.at"`
+	ExpiresAt time.Time `json:"expires.at,omitempty"`
+	Hits      int       `json:"hits"`
+}
+
+type blacklistKey struct{ tenant, ip string }
+
+// Blacklist holds source IPs added automatically from detections, per
+// tenant. Entries expire after the TTL; a zero TTL keeps them until removed.
+type Blacklist struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[blacklistKey]*BlacklistEntry
+}
+
+func NewBlacklist(ttl time.Duration) *Blacklist {
+	return &Blacklist{ttl: ttl, now: time.Now, entries: make(map[blacklistKey]*BlacklistEntry)}
+}
+
+func (b *Blacklist) SetTTL(ttl time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ttl = ttl
+}
+
+// Add blacklists ip for tenant, or extends the expiry of an existing entry.
+func (b *Blacklist) Add(tenant, ip, rule string) {
+	if ip == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	k := blacklistKey{tenant, ip}
+	e, ok := b.entries[k]
+	if !ok || b.expiredLocked(e, now) {
+		e = &BlacklistEntry{Tenant: tenant, IP: ip, Rule: rule, AddedAt: now}
+		b.entries[k] = e
+	}
+	if b.ttl > 0 {
+		e.ExpiresAt = now.Add(b.ttl)
+	}
+}
+
+func (b *Blacklist) Contains(tenant, ip string) bool {
+	if ip == "" {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	k := blacklistKey{tenant, ip}
+	e, ok := b.entries[k]
+	if !ok {
+		return false
+	}
+	if b.expiredLocked(e, b.now()) {
+		delete(b.entries, k)
+		return false
+	}
+	e.Hits++
+	return true
+}
+
+func (b *Blacklist) List() []BlacklistEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	out := make([]BlacklistEntry, 0, len(b.entries))
+	for k, e := range b.entries {
+		if b.expiredLocked(e, now) {
+			delete(b.entries, k)
+			continue
+		}
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AddedAt.Before(out[j].AddedAt) })
+	return out
+}
+
+func (b *Blacklist) Remove(tenant, ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	k := blacklistKey{tenant, ip}
+	_, ok := b.entries[k]
+	delete(b.entries, k)
+	return ok
+}
+
+// RemoveIP removes ip for every tenant and returns how many entries there
+// were.
+func (b *Blacklist) RemoveIP(ip string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for k := range b.entries {
+		if k.ip == ip {
+			delete(b.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+// Clear removes every entry and returns how many there were.
+func (b *Blacklist) Clear() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(b.entries)
+	b.entries = make(map[blacklistKey]*BlacklistEntry)
+	return n
+}
+
+func (b *Blacklist) expiredLocked(e *BlacklistEntry, now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
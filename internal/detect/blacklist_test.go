@@ -0,0 +1,42 @@
+package detect
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlacklistTTL(t *testing.T) {
+	b := NewBlacklist(time.Minute)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	b.Add("", "10.9.9.9", RuleBruteForceIP)
+	b.Add("", "", RuleBruteForceIP)
+	if !b.Contains("", "10.9.9.9") || b.Contains("", "10.9.9.8") || len(b.List()) != 1 {
+		t.Fatalf("unexpected blacklist state: %+v", b.List())
+	}
+	now = now.Add(50 * time.Second)
+	b.Add("", "10.9.9.9", RuleBruteForceIP) // re-detection extends the expiry
+	now = now.Add(50 * time.Second)
+	if !b.Contains("", "10.9.9.9") {
+		t.Fatalf("expected expiry to be extended")
+	}
+	now = now.Add(time.Minute)
+	if b.Contains("", "10.9.9.9") || len(b.List()) != 0 {
+		t.Fatalf("expected entry to expire")
+	}
+	b.Add("", "10.1.1.1", RuleBruteForceIP)
+	b.Add("", "10.1.1.2", RuleBruteForceIP)
+	if !b.Remove("", "10.1.1.1") || b.Remove("", "10.1.1.1") || b.Clear() != 1 {
+		t.Fatalf("unexpected remove/clear behaviour")
+	}
+
+	// Tenants are blacklisted separately.
+	b.Add("team-a", "10.2.2.2", RuleBruteForceIP)
+	if !b.Contains("team-a", "10.2.2.2") || b.Contains("team-b", "10.2.2.2") || b.Contains("", "10.2.2.2") {
+		t.Fatalf("expected the entry to apply to team-a only: %+v", b.List())
+	}
+	b.Add("team-b", "10.2.2.2", RuleBruteForceIP)
+	if b.RemoveIP("10.2.2.2") != 2 || len(b.List()) != 0 {
+		t.Fatalf("expected the IP to be removed for both tenants")
+	}
+}
@@ -0,0 +1,261 @@
+package detect
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"motadata/internal/model"
+	"motadata/internal/redact"
+)
+
+const (
+	CategoryAlert = "alert.detection"
+	SourceType    = "detection"
+
+	RuleBruteForceIP   = "brute_force_ip"
+	RuleBruteForceUser = "brute_force_user"
+	RuleNewHostForUser = "new_host_for_user"
+)
+
+type Config struct {
+	// FailureThreshold failed logins from one source IP, or against one
+	// user, within FailureWindow raise an alert. Zero disables the rules.
+	FailureThreshold int
+	FailureWindow    time.Duration
+	NewHostForUser   bool
+}
+
+var (
+	reFailure = regexp.MustCompile(`(?i)failed password|authentication failure|invalid user`)
+	reSuccess = regexp.MustCompile(`(?i)accepted \w+ for|session opened|new session`)
+	reFromIP  = regexp.MustCompile(`from ([0-9A-Fa-f.:]+[0-9A-Fa-f])`)
+
+	// sshd logs "Invalid user bob from ..." when a connection names an
+	// unknown user and "Failed password for invalid user bob ..." for each
+	// rejected attempt of that connection.
+	reInvalidNotice = regexp.MustCompile(`(?i)(?:^|[:\]]\s*)invalid user\b`)
+	reFailedInvalid = regexp.MustCompile(`(?i)failed \w+ for invalid user\b`)
+)
+
+// IsFailedLogin matches failure wording in the message, or login events
+// whose parser recorded a failed outcome (e.g. auditd USER_AUTH res=failed).
+func IsFailedLogin(e model.LogEntry) bool {
+	if e.Attr("outcome") == "failure" && e.EventCategory == "login.audit" {
+		return true
+	}
+	return reFailure.MatchString(e.RawMessage)
+}
+
+func isLogin(e model.LogEntry) bool {
+	if s := e.Attr("session_state"); s != "" {
+		return strings.EqualFold(s, "opened")
+	}
+	return reSuccess.MatchString(e.RawMessage)
+}
+
+// SourceIP returns the client address of an auth event, preferring the
+// parsed src_ip attribute. Masked addresses are ignored so that they do not
+// collapse into a single source.
+func SourceIP(e model.LogEntry) string {
+	if ip := e.Attr("src_ip"); ip != "" {
+		if ip == redact.Mask {
+			return ""
+		}
+		return ip
+	}
+	if m := reFromIP.FindStringSubmatch(e.RawMessage); len(m) == 2 {
+		return m[1]
+	}
+	return ""
+}
+
+// window counts events per key inside a sliding time window.
+type window struct {
+	size   time.Duration
+	events map[string][]time.Time
+}
+
+func newWindow(size time.Duration) *window {
+	return &window{size: size, events: make(map[string][]time.Time)}
+}
+
+func (w *window) add(key string, ts time.Time) int {
+	cutoff := ts.Add(-w.size)
+	kept := w.events[key][:0]
+	for _, t := range w.events[key] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, ts)
+	w.events[key] = kept
+	return len(kept)
+}
+
+func (w *window) reset(key string) {
+	delete(w.events, key)
+}
+
+func (w *window) prune(now time.Time) {
+	cutoff := now.Add(-w.size)
+	for k, ts := range w.events {
+		if len(ts) == 0 || !ts[len(ts)-1].After(cutoff) {
+			delete(w.events, k)
+		}
+	}
+}
+
+// baseline holds the hosts a user has logged in on.
+type baseline struct {
+	hosts map[string]struct{}
+	last  time.Time
+}
+
+// Engine evaluates detection rules over the entry stream and returns alert
+// entries for the caller to forward alongside the originals. State is kept
+// per tenant, so one tenant's events never count toward another's alerts.
+type Engine struct {
+	mu        sync.Mutex
+	cfg       Config
+	byIP      *window
+	byUser    *window
+	userHosts map[string]*baseline // by tenantKey of the user
+	maxUsers  int
+	notices   map[string]time.Time // by noticeKey, invalid user notices not yet followed by a failure
+	seen      int
+}
+
+func NewEngine(cfg Config) *Engine {
+	return &Engine{
+		cfg:       cfg,
+		byIP:      newWindow(cfg.FailureWindow),
+		byUser:    newWindow(cfg.FailureWindow),
+		userHosts: make(map[string]*baseline),
+		maxUsers:  10000,
+		notices:   make(map[string]time.Time),
+	}
+}
+
+// tenantKey scopes a window or baseline key to the tenant of the entry.
+func tenantKey(tenant, key string) string {
+	return tenant + "\x00" + key
+}
+
+func noticeKey(e model.LogEntry) string {
+	return tenantKey(e.Tenant, SourceIP(e)+"\x00"+strings.ToLower(e.Username))
+}
+
+// followsNotice reports whether e is the first failure of a connection whose
+// invalid user notice was already counted, so one attempt counts once.
+func (d *Engine) followsNotice(e model.LogEntry) bool {
+	if reInvalidNotice.MatchString(e.RawMessage) {
+		d.notices[noticeKey(e)] = e.Timestamp
+		return false
+	}
+	if !reFailedInvalid.MatchString(e.RawMessage) {
+		return false
+	}
+	key := noticeKey(e)
+	ts, ok := d.notices[key]
+	if !ok {
+		return false
+	}
+	delete(d.notices, key)
+	return e.Timestamp.Sub(ts) < d.cfg.FailureWindow
+}
+
+func (d *Engine) Observe(e model.LogEntry) []model.LogEntry {
+	if e.EventCategory == CategoryAlert {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seen++
+	if d.seen%1024 == 0 {
+		d.byIP.prune(e.Timestamp)
+		d.byUser.prune(e.Timestamp)
+		for k, ts := range d.notices {
+			if e.Timestamp.Sub(ts) >= d.cfg.FailureWindow {
+				delete(d.notices, k)
+			}
+		}
+	}
+	var alerts []model.LogEntry
+	if d.cfg.FailureThreshold > 0 && IsFailedLogin(e) && !d.followsNotice(e) {
+		if ip := SourceIP(e); ip != "" {
+			if n := d.byIP.add(tenantKey(e.Tenant, ip), e.Timestamp); n >= d.cfg.FailureThreshold {
+				d.byIP.reset(tenantKey(e.Tenant, ip))
+				a := d.alert(e, RuleBruteForceIP, "ERROR", fmt.Sprintf("%d failed logins from %s within %s", n, ip, d.cfg.FailureWindow))
+				a.SetAttr("count", strconv.Itoa(n))
+				alerts = append(alerts, a)
+			}
+		}
+		if user := strings.ToLower(e.Username); user != "" {
+			if n := d.byUser.add(tenantKey(e.Tenant, user), e.Timestamp); n >= d.cfg.FailureThreshold {
+				d.byUser.reset(tenantKey(e.Tenant, user))
+				a := d.alert(e, RuleBruteForceUser, "ERROR", fmt.Sprintf("%d failed logins for user %s within %s", n, e.Username, d.cfg.FailureWindow))
+				a.SetAttr("count", strconv.Itoa(n))
+				alerts = append(alerts, a)
+			}
+		}
+	}
+	if d.cfg.NewHostForUser && e.Username != "" && e.Hostname != "" && isLogin(e) {
+		user, host := strings.ToLower(e.Username), strings.ToLower(e.Hostname)
+		b, known := d.userHosts[tenantKey(e.Tenant, user)]
+		if !known {
+			if len(d.userHosts) >= d.maxUsers {
+				d.evictOldestLocked()
+			}
+			b = &baseline{hosts: make(map[string]struct{})}
+			d.userHosts[tenantKey(e.Tenant, user)] = b
+		}
+		if e.Timestamp.After(b.last) {
+			b.last = e.Timestamp
+		}
+		if _, ok := b.hosts[host]; !ok {
+			b.hosts[host] = struct{}{}
+			// The first login of a user only establishes a baseline.
+			if known {
+				alerts = append(alerts, d.alert(e, RuleNewHostForUser, "WARN", fmt.Sprintf("user %s logged in on new host %s", e.Username, e.Hostname)))
+			}
+		}
+	}
+	return alerts
+}
+
+// evictOldestLocked forgets the baseline of the user whose last login is the
+// oldest; their next login starts a new baseline.
+func (d *Engine) evictOldestLocked() {
+	var oldest string
+	var last time.Time
+	for k, b := range d.userHosts {
+		if oldest == "" || b.last.Before(last) {
+			oldest, last = k, b.last
+		}
+	}
+	delete(d.userHosts, oldest)
+}
+
+func (d *Engine) alert(e model.LogEntry, rule, severity, msg string) model.LogEntry {
+	a := model.LogEntry{
+		Timestamp:       e.Timestamp,
+		EventCategory:   CategoryAlert,
+		EventSourceType: SourceType,
+		Username:        e.Username,
+		Hostname:        e.Hostname,
+		Severity:        severity,
+		Service:         SourceType + "_" + rule,
+		RawMessage:      msg,
+		IsBlacklisted:   e.IsBlacklisted,
+		Tenant:          e.Tenant,
+	}
+	a.SetAttr("rule", rule)
+	if ip := SourceIP(e); ip != "" {
+		a.SetAttr("src_ip", ip)
+	}
+	return a
+}
@@ -0,0 +1,136 @@
+package detect
+
+import (
+	"testing"
+	"time"
+
+	"motadata/internal/model"
+)
+
+func failed(ts time.Time, user, ip string) model.LogEntry {
+	return model.LogEntry{
+		Timestamp:  ts,
+		Hostname:   "bastion",
+		Username:   user,
+		RawMessage: "sshd[1]: Failed password for invalid user " + user + " from " + ip + " port 22 ssh2",
+	}
+}
+
+func TestBruteForceByIP(t *testing.T) {
+	d := NewEngine(Config{FailureThreshold: 3, FailureWindow: time.Minute})
+	base := time.Now().UTC()
+	users := []string{"a", "b", "c", "d"}
+	var alerts []model.LogEntry
+	for i, u := range users[:2] {
+		alerts = append(alerts, d.Observe(failed(base.Add(time.Duration(i)*time.Second), u, "10.0.0.13"))...)
+	}
+	// Falls outside the window of the first two failures.
+	alerts = append(alerts, d.Observe(failed(base.Add(2*time.Minute), "c", "10.0.0.13"))...)
+	if len(alerts) != 0 {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+	alerts = append(alerts, d.Observe(failed(base.Add(2*time.Minute+time.Second), "d", "10.0.0.13"))...)
+	alerts = append(alerts, d.Observe(failed(base.Add(2*time.Minute+2*time.Second), "e", "10.0.0.13"))...)
+	if len(alerts) != 1 {
+		t.Fatalf("expected one alert, got %+v", alerts)
+	}
+	a := alerts[0]
+	if a.EventCategory != CategoryAlert || a.Attr("rule") != RuleBruteForceIP || a.Attr("src_ip") != "10.0.0.13" || a.Attr("count") != "3" {
+		t.Fatalf("unexpected alert: %+v", a)
+	}
+	if got := d.Observe(a); got != nil {
+		t.Fatalf("alerts must not be re-evaluated, got %+v", got)
+	}
+}
+
+func TestBruteForceByUser(t *testing.T) {
+	d := NewEngine(Config{FailureThreshold: 2, FailureWindow: time.Minute})
+	base := time.Now().UTC()
+	d.Observe(failed(base, "root", "10.0.0.1"))
+	alerts := d.Observe(failed(base.Add(time.Second), "root", "10.0.0.2"))
+	if len(alerts) != 1 || alerts[0].Attr("rule") != RuleBruteForceUser || alerts[0].Username != "root" {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+}
+
+func TestTenantsDetectedSeparately(t *testing.T) {
+	d := NewEngine(Config{FailureThreshold: 2, FailureWindow: time.Minute, NewHostForUser: true})
+	base := time.Now().UTC()
+	for i, tenant := range []string{"team-a", "team-b"} {
+		e := failed(base.Add(time.Duration(i)*time.Second), "admin", "10.0.0.13")
+		e.Tenant = tenant
+		if a := d.Observe(e); len(a) != 0 {
+			t.Fatalf("failures in two tenants must not add up, got %+v", a)
+		}
+	}
+	for _, tenant := range []string{"team-a", "team-b"} {
+		e := model.LogEntry{Timestamp: base, Hostname: tenant + "-host", Username: "alice", Tenant: tenant, RawMessage: "session opened for user alice"}
+		if a := d.Observe(e); len(a) != 0 {
+			t.Fatalf("a host seen in another tenant must not be compared, got %+v", a)
+		}
+	}
+	e := failed(base.Add(2*time.Second), "admin", "10.0.0.13")
+	e.Tenant = "team-a"
+	alerts := d.Observe(e)
+	if len(alerts) != 2 || alerts[0].Tenant != "team-a" || alerts[0].Attr("count") != "2" {
+		t.Fatalf("expected team-a alerts for its own two failures, got %+v", alerts)
+	}
+}
+
+func TestNewHostForUser(t *testing.T) {
+	d := NewEngine(Config{NewHostForUser: true})
+	login := func(host string) []model.LogEntry {
+		return d.Observe(model.LogEntry{Timestamp: time.Now(), Hostname: host, Username: "alice", RawMessage: "session opened for user alice"})
+	}
+	if a := login("h1"); len(a) != 0 {
+		t.Fatalf("first login must only set the baseline, got %+v", a)
+	}
+	if a := login("h1"); len(a) != 0 {
+		t.Fatalf("known host must not alert, got %+v", a)
+	}
+	a := login("h2")
+	if len(a) != 1 || a[0].Attr("rule") != RuleNewHostForUser || a[0].Hostname != "h2" {
+		t.Fatalf("expected new host alert, got %+v", a)
+	}
+}
+
+func TestInvalidUserCountedOnce(t *testing.T) {
+	d := NewEngine(Config{FailureThreshold: 3, FailureWindow: time.Minute})
+	base := time.Now().UTC()
+	var alerts []model.LogEntry
+	for i := 0; i < 2; i++ {
+		ts := base.Add(time.Duration(i) * time.Second)
+		alerts = append(alerts, d.Observe(model.LogEntry{Timestamp: ts, Username: "bob", RawMessage: "sshd[1]: Invalid user bob from 10.0.0.13 port 22"})...)
+		alerts = append(alerts, d.Observe(failed(ts, "bob", "10.0.0.13"))...)
+	}
+	if len(alerts) != 0 {
+		t.Fatalf("two attempts must count as two failures, got %+v", alerts)
+	}
+	// A second password on the same connection is another attempt.
+	alerts = d.Observe(failed(base.Add(2*time.Second), "bob", "10.0.0.13"))
+	if len(alerts) != 2 || alerts[0].Attr("count") != "3" {
+		t.Fatalf("expected alerts for the third attempt, got %+v", alerts)
+	}
+}
+
+func TestBaselinesBounded(t *testing.T) {
+	d := NewEngine(Config{NewHostForUser: true})
+	d.maxUsers = 2
+	base := time.Now().UTC()
+	login := func(ts time.Time, user, host string) []model.LogEntry {
+		return d.Observe(model.LogEntry{Timestamp: ts, Hostname: host, Username: user, RawMessage: "session opened for user " + user})
+	}
+	login(base, "alice", "h1")
+	login(base.Add(time.Second), "bob", "h1")
+	login(base.Add(2*time.Second), "alice", "h1")
+	login(base.Add(3*time.Second), "carol", "h1")
+	if len(d.userHosts) != 2 {
+		t.Fatalf("expected 2 baselines, got %d", len(d.userHosts))
+	}
+	if a := login(base.Add(4*time.Second), "alice", "h2"); len(a) != 1 {
+		t.Fatalf("recently seen user must keep the baseline, got %+v", a)
+	}
+	if a := login(base.Add(5*time.Second), "bob", "h2"); len(a) != 0 {
+		t.Fatalf("evicted user must start a new baseline, got %+v", a)
+	}
+}
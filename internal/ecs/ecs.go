@@ -0,0 +1,202 @@
+package ecs
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"motadata/internal/model"
+)
+
+const Version = "8.11.0"
+
+// Document is an Elastic Common Schema event with nested field objects.
+type Document map[string]any
+
+// attrFields maps attribute names produced by the collector to ECS fields.
+// Other attributes are carried in "labels".
+var attrFields = map[string]string{
+	"src_ip":     "source.ip",
+	"src_port":   "source.port",
+	"pid":        "process.pid",
+	"program":    "process.name",
+	"session_id": "session.id",
+	"outcome":    "event.outcome",
+}
+
+var numericFields = map[string]bool{
+	"source.port": true,
+	"process.pid": true,
+}
+
+const blacklistedTag = "blacklisted"
+
+func FromEntry(e model.LogEntry) Document {
+	d := Document{}
+	d.Set("@timestamp", e.Timestamp.UTC().Format(time.RFC3339Nano))
+	d.Set("ecs.version", Version)
+	d.Set("message", e.RawMessage)
+	setIf(d, "event.category", e.EventCategory)
+	setIf(d, "event.module", e.EventSourceType)
+	setIf(d, "service.name", e.Service)
+	setIf(d, "user.name", e.Username)
+	setIf(d, "host.hostname", e.Hostname)
+	setIf(d, "log.level", strings.ToLower(e.Severity))
+	if e.IsBlacklisted {
+		d.Set("tags", []string{blacklistedTag})
+	}
+	labels := map[string]any{}
+	for k, v := range e.Attributes {
+		field, ok := attrFields[k]
+		if !ok {
+			labels[k] = v
+			continue
+		}
+		if numericFields[field] {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				d.Set(field, n)
+				continue
+			}
+		}
+		d.Set(field, v)
+	}
+	if len(labels) > 0 {
+		d["labels"] = labels
+	}
+	return d
+}
+
+func ToEntry(d Document) model.LogEntry {
+	e := model.LogEntry{
+		EventCategory:   d.String("event.category"),
+		EventSourceType: d.String("event.module"),
+		Service:         d.String("service.name"),
+		Username:        d.String("user.name"),
+		Hostname:        d.String("host.hostname"),
+		Severity:        strings.ToUpper(d.String("log.level")),
+		RawMessage:      d.String("message"),
+	}
+	if e.RawMessage == "" {
+		e.RawMessage = d.String("event.original")
+	}
+	if e.Hostname == "" {
+		e.Hostname = d.String("host.name")
+	}
+	if ts := d.String("@timestamp"); ts != "" {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			e.Timestamp = t
+		}
+	}
+	if tags, ok := d.Get("tags").([]any); ok {
+		for _, t := range tags {
+			if s, _ := t.(string); s == blacklistedTag {
+				e.IsBlacklisted = true
+			}
+		}
+	}
+	for attr, field := range attrFields {
+		if v := d.String(field); v != "" {
+			e.SetAttr(attr, v)
+		}
+	}
+	if labels, ok := d.Get("labels").(map[string]any); ok {
+		for k, v := range labels {
+			if s := stringOf(v); s != "" {
+				e.SetAttr(k, s)
+			}
+		}
+	}
+	return e
+}
+
+// Parse decodes raw JSON and reports whether it looks like an ECS document
+// rather than the collector's flat client payload.
+func Parse(b []byte) (Document, bool) {
+	var d Document
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, false
+	}
+	if _, ok := d["@timestamp"]; ok {
+		return d, true
+	}
+	for _, k := range []string{"ecs", "event", "host", "user", "log"} {
+		if _, ok := d[k].(map[string]any); ok {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// Set stores v at a dotted path, creating intermediate objects.
+func (d Document) Set(path string, v any) {
+	m := map[string]any(d)
+	parts := strings.Split(path, ".")
+	if path == "@timestamp" {
+		parts = []string{path}
+	}
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := m[p].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			m[p] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = v
+}
+
+// Get resolves a dotted path through nested objects, also accepting
+// documents that use flat dotted keys.
+func (d Document) Get(path string) any {
+	if v, ok := d[path]; ok {
+		return v
+	}
+	m := map[string]any(d)
+	parts := strings.Split(path, ".")
+	for i, p := range parts {
+		v, ok := m[p]
+		if !ok {
+			if rest := strings.Join(parts[i:], "."); rest != p {
+				return m[rest]
+			}
+			return nil
+		}
+		if i == len(parts)-1 {
+			return v
+		}
+		if m, ok = v.(map[string]any); !ok {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d Document) String(path string) string {
+	return stringOf(d.Get(path))
+}
+
+func stringOf(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case bool:
+		return strconv.FormatBool(t)
+	case []any:
+		// ECS allows arrays for fields like event.category.
+		if len(t) > 0 {
+			return stringOf(t[0])
+		}
+	}
+	return ""
+}
+
+func setIf(d Document, path, v string) {
+	if v != "" {
+		d.Set(path, v)
+	}
+}
@@ -0,0 +1,65 @@
+package ecs
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"motadata/internal/model"
+)
+
+func TestRoundTrip(t *testing.T) {
+	in := model.LogEntry{
+		Timestamp:       time.Date(2025, 7, 29, 12, 35, 24, 0, time.UTC),
+		EventCategory:   "login.audit",
+		EventSourceType: "linux",
+		Username:        "alice",
+		Hostname:        "aiops9242",
+		Severity:        "WARN",
+		Service:         "linux_login_audit",
+		RawMessage:      "Failed password for alice",
+		IsBlacklisted:   true,
+		Attributes:      model.Attributes{"src_ip": "10.0.0.13", "pid": "1234", "auth_method": "password"},
+	}
+	b, err := json.Marshal(FromEntry(in))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var raw map[string]any
+	_ = json.Unmarshal(b, &raw)
+	if raw["user"].(map[string]any)["name"] != "alice" || raw["source"].(map[string]any)["ip"] != "10.0.0.13" {
+		t.Fatalf("unexpected ECS document: %s", b)
+	}
+	if raw["log"].(map[string]any)["level"] != "warn" || raw["process"].(map[string]any)["pid"] != float64(1234) {
+		t.Fatalf("unexpected ECS document: %s", b)
+	}
+
+	d, ok := Parse(b)
+	if !ok {
+		t.Fatalf("expected document to be detected as ECS")
+	}
+	out := ToEntry(d)
+	if !out.Timestamp.Equal(in.Timestamp) || out.Username != in.Username || out.Hostname != in.Hostname ||
+		out.Severity != in.Severity || out.EventCategory != in.EventCategory || !out.IsBlacklisted {
+		t.Fatalf("round trip mismatch: %+v", out)
+	}
+	for k, v := range in.Attributes {
+		if out.Attr(k) != v {
+			t.Fatalf("attribute %s = %q, want %q", k, out.Attr(k), v)
+		}
+	}
+}
+
+func TestParseFlatDottedKeys(t *testing.T) {
+	d, ok := Parse([]byte(`{"@timestamp":"2025-07-29T12:35:24Z","user.name":"bob","event":{"category":["authentication"]},"host":{"name":"h1"}}`))
+	if !ok {
+		t.Fatalf("expected ECS document")
+	}
+	e := ToEntry(d)
+	if e.Username != "bob" || e.EventCategory != "authentication" || e.Hostname != "h1" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if _, ok := Parse([]byte(`{"timestamp":"2025-07-29T12:35:24Z","event.category":"login.audit","message":"m"}`)); ok {
+		t.Fatalf("flat client payload must not be treated as ECS")
+	}
+}
@@ -0,0 +1,200 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"motadata/internal/detect"
+	"motadata/internal/model"
+)
+
+type Action string
+
+const (
+	ActionDrop      Action = "drop"
+	ActionSample    Action = "sample"
+	ActionRateLimit Action = "rate_limit"
+)
+
+// Reasons reported for discarded entries.
+const (
+	ReasonDropped     = "dropped"
+	ReasonSampled     = "sampled"
+	ReasonRateLimited = "rate_limited"
+)
+
+// Match selects entries; empty fields match anything. Hostname and Service
+// accept shell-style globs such as "node-*".
+type Match struct {
+	Hostname string `json:"hostname,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Category string `json:"category,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Contains string `json:"contains,omitempty"`
+}
+
+type Rule struct {
+	Name    string  `json:"name"`
+	Match   Match   `json:"match"`
+	Action  Action  `json:"action"`
+	Percent float64 `json:"percent,omitempty"` // sample: share of entries kept, 0-100
+	Rate    float64 `json:"rate,omitempty"`    // rate_limit: entries per second per key
+	Burst   int     `json:"burst,omitempty"`   // rate_limit: bucket size, defaults to Rate
+	By      string  `json:"by,omitempty"`      // rate_limit key: "hostname" (default) or "service"
+}
+
+type Config struct {
+	Rules []Rule `json:"rules"`
+	// DropSecurityEvents lets rules discard blacklisted entries, failed
+	// logins, errors, alerts and Sigma matches, which are kept otherwise.
+	DropSecurityEvents bool `json:"dropSecurityEvents,omitempty"`
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Engine applies the first matching rule to each entry.
+type Engine struct {
+	cfg  Config
+	now  func() time.Time
+	rand func() float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func New(cfg Config) (*Engine, error) {
+	for i, r := range cfg.Rules {
+		switch r.Action {
+		case ActionDrop:
+		case ActionSample:
+			if r.Percent < 0 || r.Percent > 100 {
+				return nil, fmt.Errorf("filter rule %q: percent must be between 0 and 100", r.Name)
+			}
+		case ActionRateLimit:
+			if r.Rate <= 0 {
+				return nil, fmt.Errorf("filter rule %q: rate must be positive", r.Name)
+			}
+			if r.By != "" && r.By != "hostname" && r.By != "service" {
+				return nil, fmt.Errorf("filter rule %q: unknown key %q", r.Name, r.By)
+			}
+			if r.Burst <= 0 {
+				cfg.Rules[i].Burst = max(1, int(r.Rate))
+			}
+		default:
+			return nil, fmt.Errorf("filter rule %q: unknown action %q", r.Name, r.Action)
+		}
+		for _, g := range []string{r.Match.Hostname, r.Match.Service} {
+			if _, err := path.Match(g, ""); err != nil {
+				return nil, fmt.Errorf("filter rule %q: %w", r.Name, err)
+			}
+		}
+	}
+	return &Engine{cfg: cfg, now: time.Now, rand: rand.Float64, buckets: make(map[string]*bucket)}, nil
+}
+
+func LoadConfig(file string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// IsSecurityEvent reports entries that rules must not discard unless
+// DropSecurityEvents is set.
+func IsSecurityEvent(e model.LogEntry) bool {
+	return e.IsBlacklisted ||
+		strings.HasPrefix(e.EventCategory, "alert.") ||
+		strings.EqualFold(e.Severity, "ERROR") ||
+		e.Attr("sigma.rule_id") != "" ||
+		detect.IsFailedLogin(e)
+}
+
+// Evaluate reports whether e should be forwarded and, if not, why. A nil
+// engine keeps everything.
+func (en *Engine) Evaluate(e model.LogEntry) (bool, string) {
+	if en == nil || len(en.cfg.Rules) == 0 {
+		return true, ""
+	}
+	if !en.cfg.DropSecurityEvents && IsSecurityEvent(e) {
+		return true, ""
+	}
+	for i, r := range en.cfg.Rules {
+		if !r.Match.matches(e) {
+			continue
+		}
+		switch r.Action {
+		case ActionDrop:
+			return false, ReasonDropped
+		case ActionSample:
+			if en.rand()*100 >= r.Percent {
+				return false, ReasonSampled
+			}
+		case ActionRateLimit:
+			key := e.Hostname
+			if r.By == "service" {
+				key = e.Service
+			}
+			if !en.take(fmt.Sprintf("%d/%s", i, strings.ToLower(key)), r) {
+				return false, ReasonRateLimited
+			}
+		}
+		return true, ""
+	}
+	return true, ""
+}
+
+func (en *Engine) take(key string, r Rule) bool {
+	en.mu.Lock()
+	defer en.mu.Unlock()
+	now := en.now()
+	b, ok := en.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(r.Burst), last: now}
+		en.buckets[key] = b
+	}
+	b.tokens = min(float64(r.Burst), b.tokens+now.Sub(b.last).Seconds()*r.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (m Match) matches(e model.LogEntry) bool {
+	if m.Hostname != "" && !glob(m.Hostname, e.Hostname) {
+		return false
+	}
+	if m.Service != "" && !glob(m.Service, e.Service) {
+		return false
+	}
+	if m.Category != "" && !strings.EqualFold(m.Category, e.EventCategory) {
+		return false
+	}
+	if m.Severity != "" && !strings.EqualFold(m.Severity, e.Severity) {
+		return false
+	}
+	if m.Contains != "" && !strings.Contains(e.RawMessage, m.Contains) {
+		return false
+	}
+	return true
+}
+
+func glob(pattern, s string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(s))
+	return ok
+}
@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"motadata/internal/model"
+)
+
+func TestDropAndSample(t *testing.T) {
+	en, err := New(Config{Rules: []Rule{
+		{Name: "noisy", Match: Match{Hostname: "worker-*", Severity: "info"}, Action: ActionDrop},
+		{Name: "half", Match: Match{Service: "linux_logout_audit"}, Action: ActionSample, Percent: 50},
+	}})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	rolls := []float64{0.2, 0.7}
+	en.rand = func() float64 { r := rolls[0]; rolls = rolls[1:]; return r }
+
+	if keep, reason := en.Evaluate(model.LogEntry{Hostname: "Worker-07", Severity: "INFO"}); keep || reason != ReasonDropped {
+		t.Fatalf("expected drop, got %v %q", keep, reason)
+	}
+	if keep, _ := en.Evaluate(model.LogEntry{Hostname: "api-03", Severity: "INFO"}); !keep {
+		t.Fatalf("unmatched entry must be kept")
+	}
+	logout := model.LogEntry{Hostname: "api-03", Service: "linux_logout_audit"}
+	if keep, _ := en.Evaluate(logout); !keep {
+		t.Fatalf("expected sampled entry to be kept")
+	}
+	if keep, reason := en.Evaluate(logout); keep || reason != ReasonSampled {
+		t.Fatalf("expected entry to be sampled out, got %v %q", keep, reason)
+	}
+}
+
+func TestRateLimitPerHost(t *testing.T) {
+	en, err := New(Config{Rules: []Rule{{Name: "per-host", Action: ActionRateLimit, Rate: 1, Burst: 2}}})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	now := time.Now()
+	en.now = func() time.Time { return now }
+	kept := 0
+	for i := 0; i < 5; i++ {
+		if ok, _ := en.Evaluate(model.LogEntry{Hostname: "h1"}); ok {
+			kept++
+		}
+	}
+	if kept != 2 {
+		t.Fatalf("expected burst of 2, kept %d", kept)
+	}
+	if ok, _ := en.Evaluate(model.LogEntry{Hostname: "h2"}); !ok {
+		t.Fatalf("other hosts have their own bucket")
+	}
+	now = now.Add(time.Second)
+	if ok, _ := en.Evaluate(model.LogEntry{Hostname: "h1"}); !ok {
+		t.Fatalf("expected a token after refill")
+	}
+	if ok, reason := en.Evaluate(model.LogEntry{Hostname: "h1"}); ok || reason != ReasonRateLimited {
+		t.Fatalf("expected rate limit, got %v %q", ok, reason)
+	}
+}
+
+func TestSecurityEventsKept(t *testing.T) {
+	en, _ := New(Config{Rules: []Rule{{Name: "all", Action: ActionDrop}}})
+	for _, e := range []model.LogEntry{
+		{IsBlacklisted: true},
+		{EventCategory: "alert.detection"},
+		{Severity: "ERROR"},
+		{RawMessage: "sshd[1]: Failed password for bob from 10.0.0.1 port 22"},
+		{Attributes: model.Attributes{"sigma.rule_id": "x"}},
+	} {
+		if ok, _ := en.Evaluate(e); !ok {
+			t.Fatalf("security event dropped: %+v", e)
+		}
+	}
+	en, _ = New(Config{Rules: []Rule{{Name: "all", Action: ActionDrop}}, DropSecurityEvents: true})
+	if ok, _ := en.Evaluate(model.LogEntry{IsBlacklisted: true}); ok {
+		t.Fatalf("expected drop when security events are not protected")
+	}
+	if _, err := New(Config{Rules: []Rule{{Action: "explode"}}}); err == nil {
+		t.Fatalf("expected error for unknown action")
+	}
+}
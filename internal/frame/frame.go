@@ -0,0 +1,95 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version starts every frame. It is a control character, so a connection
+// whose first byte is Version cannot be a newline-delimited text stream.
+const Version byte = 0x02
+
+const (
+	TypeWindow byte = 'W' // Seq is the number of data frames that follow
+	TypeData   byte = 'J' // Seq numbers the event within its window, from 1
+	TypeAck    byte = 'A' // Seq is the last data frame the receiver accepted
+)
+
+// MaxPayload bounds data frame lengths so a corrupt stream cannot make the
+// reader allocate without limit.
+const MaxPayload = 16 << 20
+
+var ErrVersion = errors.New("frame: unsupported version")
+
+// Frame is one unit of the acknowledged protocol:
+//
+//	version(1) type(1) seq(4)                  window and ack frames
+//	version(1) type(1) seq(4) len(4) payload   data frames
+//
+// Integers are big-endian. A sender writes a window frame and that many
+// data frames; the receiver acks the highest sequence number it accepted,
+// at the latest when the window is complete.
+type Frame struct {
+	Type    byte
+	Seq     uint32
+	Payload []byte
+}
+
+func Write(w io.Writer, f Frame) error {
+	hdr := make([]byte, 6, 10)
+	hdr[0], hdr[1] = Version, f.Type
+	binary.BigEndian.PutUint32(hdr[2:], f.Seq)
+	if f.Type == TypeData {
+		hdr = binary.BigEndian.AppendUint32(hdr, uint32(len(f.Payload)))
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	if f.Type == TypeData {
+		_, err := w.Write(f.Payload)
+		return err
+	}
+	return nil
+}
+
+// Read returns the next frame, or io.EOF when the stream ends between
+// frames.
+func Read(r io.Reader) (Frame, error) {
+	var hdr [6]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Frame{}, err
+	}
+	if hdr[0] != Version {
+		return Frame{}, ErrVersion
+	}
+	f := Frame{Type: hdr[1], Seq: binary.BigEndian.Uint32(hdr[2:])}
+	switch f.Type {
+	case TypeWindow, TypeAck:
+		return f, nil
+	case TypeData:
+	default:
+		return f, fmt.Errorf("frame: unknown type %q", f.Type)
+	}
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return f, unexpected(err)
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size > MaxPayload {
+		return f, fmt.Errorf("frame: payload of %d bytes exceeds %d", size, MaxPayload)
+	}
+	f.Payload = make([]byte, size)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return f, unexpected(err)
+	}
+	return f, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
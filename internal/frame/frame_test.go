@@ -0,0 +1,49 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	in := []Frame{
+		{Type: TypeWindow, Seq: 2},
+		{Type: TypeData, Seq: 1, Payload: []byte(`{"message":"a"}`)},
+		{Type: TypeData, Seq: 2, Payload: []byte{}},
+		{Type: TypeAck, Seq: 2},
+	}
+	for _, f := range in {
+		if err := Write(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range in {
+		got, err := Read(&buf)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if got.Type != want.Type || got.Seq != want.Seq || !bytes.Equal(got.Payload, want.Payload) {
+			t.Fatalf("frame %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	if _, err := Read(&buf); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	if _, err := Read(bytes.NewReader([]byte(`{"message":"x"}`))); !errors.Is(err, ErrVersion) {
+		t.Fatalf("expected version error, got %v", err)
+	}
+	truncated := []byte{Version, TypeData, 0, 0, 0, 1, 0, 0, 0, 9, 'x'}
+	if _, err := Read(bytes.NewReader(truncated)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+	huge := []byte{Version, TypeData, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff}
+	if _, err := Read(bytes.NewReader(huge)); err == nil {
+		t.Fatal("expected size error")
+	}
+}
@@ -0,0 +1,241 @@
+package grok
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"motadata/internal/model"
+)
+
+var reRef = regexp.MustCompile(`%\{(\w+)(?::([\w.@\[\]-]+))?(?::(\w+))?\}`)
+
+const maxDepth = 32
+
+// Grok holds named pattern definitions and compiles composite expressions
+// that reference them as %{NAME}, %{NAME:field} or %{NAME:field:type}, where
+// type is int or float.
+type Grok struct {
+	defs map[string]string
+}
+
+func New() *Grok {
+	g := &Grok{defs: make(map[string]string, len(basePatterns))}
+	for name, expr := range basePatterns {
+		g.defs[name] = expr
+	}
+	return g
+}
+
+func (g *Grok) AddPattern(name, expr string) {
+	g.defs[name] = expr
+}
+
+// AddPatterns reads definitions in the usual grok file format: one
+// "NAME expression" per line, blank lines and # comments ignored.
+func (g *Grok) AddPatterns(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, expr, ok := strings.Cut(line, " ")
+		if !ok {
+			return fmt.Errorf("grok: line %d: missing expression for %q", n, name)
+		}
+		g.AddPattern(name, strings.TrimSpace(expr))
+	}
+	return sc.Err()
+}
+
+type field struct {
+	name  string
+	typ   string // "", "int" or "float"
+	group int    // submatch index
+}
+
+type Pattern struct {
+	re     *regexp.Regexp
+	fields []field
+}
+
+func (g *Grok) Compile(expr string) (*Pattern, error) {
+	p := &Pattern{}
+	src, err := g.expand(expr, p, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(src)
+	if err != nil {
+		return nil, fmt.Errorf("grok: %w", err)
+	}
+	p.re = re
+	// Definitions may contain their own groups, so captures are looked up
+	// by name rather than position.
+	for i := range p.fields {
+		p.fields[i].group = re.SubexpIndex(groupName(i))
+	}
+	return p, nil
+}
+
+// CompileNamed compiles a previously defined pattern by name.
+func (g *Grok) CompileNamed(name string) (*Pattern, error) {
+	expr, ok := g.defs[name]
+	if !ok {
+		return nil, fmt.Errorf("grok: unknown pattern %q", name)
+	}
+	return g.Compile(expr)
+}
+
+func (g *Grok) expand(expr string, p *Pattern, depth int) (string, error) {
+	if depth > maxDepth {
+		return "", fmt.Errorf("grok: pattern nesting too deep (recursive definition?)")
+	}
+	var firstErr error
+	out := reRef.ReplaceAllStringFunc(expr, func(ref string) string {
+		if firstErr != nil {
+			return ""
+		}
+		m := reRef.FindStringSubmatch(ref)
+		def, ok := g.defs[m[1]]
+		if !ok {
+			firstErr = fmt.Errorf("grok: unknown pattern %q", m[1])
+			return ""
+		}
+		inner, err := g.expand(def, p, depth+1)
+		if err != nil {
+			firstErr = err
+			return ""
+		}
+		if m[2] == "" {
+			return "(?:" + inner + ")"
+		}
+		if m[3] != "" && m[3] != "int" && m[3] != "float" {
+			firstErr = fmt.Errorf("grok: unknown type %q for field %s", m[3], m[2])
+			return ""
+		}
+		// Grok field names may contain dots, which Go does not allow in
+		// group names, so captures are numbered and mapped back on match.
+		group := groupName(len(p.fields))
+		p.fields = append(p.fields, field{name: m[2], typ: m[3]})
+		return "(?P<" + group + ">" + inner + ")"
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return out, nil
+}
+
+func groupName(i int) string {
+	return fmt.Sprintf("grok_%d", i)
+}
+
+// Match returns the named captures of s. Captures that did not participate
+// in the match, and typed captures that do not convert, are omitted; typed
+// ones are normalized, e.g. "007" as int is "7".
+func (p *Pattern) Match(s string) (map[string]string, bool) {
+	idx := p.re.FindStringSubmatchIndex(s)
+	if idx == nil {
+		return nil, false
+	}
+	fields := make(map[string]string, len(p.fields))
+	for _, f := range p.fields {
+		start, end := idx[2*f.group], idx[2*f.group+1]
+		if start < 0 {
+			continue
+		}
+		v := s[start:end]
+		switch f.typ {
+		case "int":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				continue
+			}
+			v = strconv.FormatInt(n, 10)
+		case "float":
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			v = strconv.FormatFloat(x, 'f', -1, 64)
+		}
+		fields[f.name] = v
+	}
+	return fields, true
+}
+
+func (p *Pattern) String() string {
+	return p.re.String()
+}
+
+type namedPattern struct {
+	name string
+	*Pattern
+}
+
+// Set is an ordered list of compiled patterns; the first match wins.
+type Set struct {
+	patterns []namedPattern
+}
+
+func (s *Set) Add(name string, p *Pattern) {
+	s.patterns = append(s.patterns, namedPattern{name: name, Pattern: p})
+}
+
+func (s *Set) Len() int {
+	return len(s.patterns)
+}
+
+func (s *Set) Match(msg string) (string, map[string]string, bool) {
+	for _, p := range s.patterns {
+		if fields, ok := p.Match(msg); ok {
+			return p.name, fields, true
+		}
+	}
+	return "", nil, false
+}
+
+// CompileSet compiles the named definitions, in order, into a Set.
+func (g *Grok) CompileSet(names ...string) (*Set, error) {
+	s := &Set{}
+	for _, name := range names {
+		p, err := g.CompileNamed(name)
+		if err != nil {
+			return nil, err
+		}
+		s.Add(name, p)
+	}
+	return s, nil
+}
+
+// Apply copies captures with a well-known name onto entry and returns the
+// remaining ones.
+func Apply(entry *model.LogEntry, fields map[string]string) map[string]string {
+	rest := make(map[string]string)
+	for k, v := range fields {
+		if v == "" {
+			continue
+		}
+		switch k {
+		case "username":
+			entry.Username = v
+		case "hostname":
+			if entry.Hostname == "" {
+				entry.Hostname = v
+			}
+		case "service":
+			entry.Service = v
+		case "severity":
+			entry.Severity = strings.ToUpper(v)
+		default:
+			rest[k] = v
+		}
+	}
+	return rest
+}
@@ -0,0 +1,116 @@
+package grok
+
+import (
+	"strings"
+	"testing"
+
+	"motadata/internal/model"
+)
+
+func TestCompileAndMatch(t *testing.T) {
+	g := New()
+	p, err := g.Compile(`%{IP:client.ip} %{WORD:method} %{INT:bytes:int}`)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	fields, ok := p.Match("10.1.2.3 GET 512")
+	if !ok {
+		t.Fatalf("expected match")
+	}
+	if fields["client.ip"] != "10.1.2.3" || fields["method"] != "GET" || fields["bytes"] != "512" {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+	// Unnamed groups in a definition do not shift the fields.
+	g.AddPattern("VERB", `(foo|bar)`)
+	p, err = g.Compile(`%{VERB} (x)?%{WORD:w} %{NUMBER:n:int} %{NUMBER:f:float}`)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if fields, ok := p.Match("foo hello 007 1.50"); !ok || fields["w"] != "hello" || fields["n"] != "7" || fields["f"] != "1.5" {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+	if fields, _ := p.Match("bar hi 1.5 2"); fields["w"] != "hi" || fields["n"] != "" {
+		t.Fatalf("expected a non-integer to be omitted, got %v", fields)
+	}
+	if _, err := g.Compile(`%{INT:n:long}`); err == nil {
+		t.Fatalf("expected error for unknown type")
+	}
+	if _, err := g.Compile(`%{NOPE}`); err == nil {
+		t.Fatalf("expected error for unknown pattern")
+	}
+	g.AddPattern("LOOP", `%{LOOP}`)
+	if _, err := g.CompileNamed("LOOP"); err == nil {
+		t.Fatalf("expected error for recursive pattern")
+	}
+}
+
+func TestAddPatterns(t *testing.T) {
+	g := New()
+	defs := "# custom\nAPP_ID app-%{POSINT:app_id}\n\nAPP_LINE %{APP_ID} says %{GREEDYDATA:text}\n"
+	if err := g.AddPatterns(strings.NewReader(defs)); err != nil {
+		t.Fatalf("add patterns: %v", err)
+	}
+	p, err := g.CompileNamed("APP_LINE")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	fields, ok := p.Match("app-42 says hello world")
+	if !ok || fields["app_id"] != "42" || fields["text"] != "hello world" {
+		t.Fatalf("unexpected match %v %v", ok, fields)
+	}
+}
+
+func TestLinuxAuthSet(t *testing.T) {
+	set, err := New().CompileSet(LinuxAuth...)
+	if err != nil {
+		t.Fatalf("compile set: %v", err)
+	}
+	cases := []struct {
+		msg     string
+		pattern string
+		want    map[string]string
+	}{
+		{
+			msg:     "Oct 18 10:01:02 bastion sshd[1234]: Failed password for invalid user bob from 10.0.0.13 port 50022 ssh2",
+			pattern: "SSHD_FAILED",
+			want:    map[string]string{"hostname": "bastion", "program": "sshd", "pid": "1234", "username": "bob", "src_ip": "10.0.0.13", "src_port": "50022"},
+		},
+		{
+			msg:     "<86> aiops9242 sudo: pam_unix(sudo:session): session opened for user root(uid=0) by motadata(uid=1000)",
+			pattern: "PAM_SESSION",
+			want:    map[string]string{"syslog_pri": "86", "hostname": "aiops9242", "program": "sudo", "session_state": "opened", "username": "root", "by_user": "motadata"},
+		},
+		{
+			msg:     "<86> node-01 systemd: session closed for user alice",
+			pattern: "SYSTEMD_SESSION",
+			want:    map[string]string{"session_state": "closed", "username": "alice"},
+		},
+		{
+			msg:     "Oct 18 10:01:02 bastion sshd[99]: Accepted publickey for alice from 2001:db8::1 port 22 ssh2",
+			pattern: "SSHD_ACCEPTED",
+			want:    map[string]string{"auth_method": "publickey", "src_ip": "2001:db8::1"},
+		},
+	}
+	for _, c := range cases {
+		name, fields, ok := set.Match(c.msg)
+		if !ok || name != c.pattern {
+			t.Fatalf("%q: matched %q (ok=%v), want %q", c.msg, name, ok, c.pattern)
+		}
+		for k, v := range c.want {
+			if fields[k] != v {
+				t.Fatalf("%q: field %s = %q, want %q", c.msg, k, fields[k], v)
+			}
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	e := model.LogEntry{Hostname: "from-client"}
+	rest := Apply(&e, map[string]string{"username": "bob", "hostname": "h2", "severity": "warn", "src_ip": "1.2.3.4"})
+	if e.Username != "bob" || e.Hostname != "from-client" || e.Severity != "WARN" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if len(rest) != 1 || rest["src_ip"] != "1.2.3.4" {
+		t.Fatalf("unexpected rest: %v", rest)
+	}
+}
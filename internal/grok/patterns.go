@@ -0,0 +1,76 @@
+package grok
+
+// Base definitions follow the upstream grok-patterns names, rewritten for
+// RE2 (no look-around or possessive quantifiers).
+var basePatterns = map[string]string{
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"INT":          `[+-]?[0-9]+`,
+	"BASE10NUM":    `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":       `%{BASE10NUM}`,
+	"BASE16NUM":    `(?:0[xX])?[0-9A-Fa-f]+`,
+	"POSINT":       `[1-9][0-9]*`,
+	"NONNEGINT":    `[0-9]+`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+
+	"IPV4":     `(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])`,
+	"IPV6":     `(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}:){1,7}:|(?:[0-9A-Fa-f]{1,4}:){1,6}(?::[0-9A-Fa-f]{1,4}){1,6}|::(?:[0-9A-Fa-f]{1,4}:){0,6}[0-9A-Fa-f]{0,4}`,
+	"IP":       `%{IPV6}|%{IPV4}`,
+	"HOSTNAME": `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST": `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT": `%{IPORHOST}:%{POSINT}`,
+
+	"MONTH":             `\b(?:Jan(?:uary)?|Feb(?:ruary)?|Mar(?:ch)?|Apr(?:il)?|May|Jun(?:e)?|Jul(?:y)?|Aug(?:ust)?|Sep(?:tember)?|Oct(?:ober)?|Nov(?:ember)?|Dec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `(?:0[1-9])|(?:[12][0-9])|(?:3[01])|[1-9]`,
+	"YEAR":              `[0-9]{4}`,
+	"HOUR":              `2[0123]|[01]?[0-9]`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"LOGLEVEL":          `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|EMERG(?:ENCY)?|[Ee]merg(?:ency)?`,
+
+	"PROG":           `[\x21-\x5a\x5c\x5e-\x7e]+?`,
+	"SYSLOGPROG":     `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"SYSLOGHOST":     `%{IPORHOST}`,
+	"SYSLOGFACILITY": `<%{NONNEGINT:facility}.%{NONNEGINT:priority}>`,
+	"SYSLOGBASE":     `%{SYSLOGTIMESTAMP:timestamp} (?:%{SYSLOGFACILITY} )?%{SYSLOGHOST:logsource} %{SYSLOGPROG}:`,
+
+	// Linux authentication logs, both the "<pri> host prog: msg" shape sent
+	// by the simulated clients and the "Mon dd hh:mm:ss host prog[pid]: msg"
+	// shape found in /var/log/auth.log.
+	"LINUXAUTH_PREFIX":       `^(?:<%{NONNEGINT:syslog_pri}>\s*)?(?:%{SYSLOGTIMESTAMP:syslog_timestamp}\s+)?%{SYSLOGHOST:hostname}\s+%{SYSLOGPROG}:\s+`,
+	"SSHD_FAILED":            `%{LINUXAUTH_PREFIX}Failed %{WORD:auth_method} for (?:invalid user )?%{USERNAME:username} from %{IP:src_ip} port %{POSINT:src_port}(?: %{WORD:protocol})?`,
+	"SSHD_ACCEPTED":          `%{LINUXAUTH_PREFIX}Accepted %{WORD:auth_method} for %{USERNAME:username} from %{IP:src_ip} port %{POSINT:src_port}(?: %{WORD:protocol})?`,
+	"SSHD_INVALID_USER":      `%{LINUXAUTH_PREFIX}Invalid user %{USERNAME:username} from %{IP:src_ip}(?: port %{POSINT:src_port})?`,
+	"SSHD_DISCONNECTED":      `%{LINUXAUTH_PREFIX}Disconnected from (?:(?:invalid |authenticating )?user %{USERNAME:username} )?%{IP:src_ip} port %{POSINT:src_port}`,
+	"PAM_SESSION":            `%{LINUXAUTH_PREFIX}pam_unix\(%{DATA:pam_service}:session\): session %{WORD:session_state} for user %{USERNAME:username}(?:\(uid=%{INT:uid}\))?(?: by %{USERNAME:by_user}?\(uid=%{INT:by_uid}\))?`,
+	"PAM_AUTH_FAILURE":       `%{LINUXAUTH_PREFIX}pam_unix\(%{DATA:pam_service}:auth\): authentication failure;%{GREEDYDATA:pam_details}`,
+	"SYSTEMD_LOGIND_NEW":     `%{LINUXAUTH_PREFIX}New session %{NOTSPACE:session_id} of user %{USERNAME:username}\.?`,
+	"SYSTEMD_LOGIND_REMOVED": `%{LINUXAUTH_PREFIX}Removed session %{NOTSPACE:session_id}\.?`,
+	"SYSTEMD_SESSION":        `%{LINUXAUTH_PREFIX}session %{WORD:session_state} for user %{USERNAME:username}`,
+	"SUDO_COMMAND":           `%{LINUXAUTH_PREFIX}\s*%{USERNAME:username} : (?:%{DATA:sudo_error} ; )?TTY=%{NOTSPACE:tty} ; PWD=%{DATA:pwd} ; USER=%{USERNAME:target_user} ; COMMAND=%{GREEDYDATA:command}`,
+}
+
+// LinuxAuth lists the built-in auth log patterns in match order.
+var LinuxAuth = []string{
+	"SSHD_FAILED",
+	"SSHD_ACCEPTED",
+	"SSHD_INVALID_USER",
+	"SSHD_DISCONNECTED",
+	"PAM_SESSION",
+	"PAM_AUTH_FAILURE",
+	"SYSTEMD_LOGIND_NEW",
+	"SYSTEMD_LOGIND_REMOVED",
+	"SYSTEMD_SESSION",
+	"SUDO_COMMAND",
+}
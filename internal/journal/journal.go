@@ -0,0 +1,98 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// MaxFieldSize bounds binary field lengths so a corrupt stream cannot make
+// the reader allocate without limit.
+const MaxFieldSize = 16 << 20
+
+// Entry holds the fields of one journal entry. Binary fields keep their
+// raw bytes; for repeated fields the last value wins.
+type Entry map[string]string
+
+// Time returns __REALTIME_TIMESTAMP, which is in microseconds since the
+// epoch, or the zero time.
+func (e Entry) Time() time.Time {
+	us, err := strconv.ParseInt(e["__REALTIME_TIMESTAMP"], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMicro(us).UTC()
+}
+
+// Reader decodes the journal export format written by
+// `journalctl -o export`: entries are separated by an empty line and each
+// field is either "KEY=value\n" or, when the value may contain newlines or
+// other binary data, "KEY\n" followed by a little-endian uint64 length, the
+// data and "\n".
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next entry, or io.EOF when the stream ends cleanly.
+func (r *Reader) Next() (Entry, error) {
+	e := Entry{}
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF && len(line) == 0 {
+				if len(e) > 0 {
+					return e, nil
+				}
+				return nil, io.EOF
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = line[:len(line)-1]
+		if len(line) == 0 {
+			if len(e) > 0 {
+				return e, nil
+			}
+			continue
+		}
+		if i := bytes.IndexByte(line, '='); i >= 0 {
+			e[string(line[:i])] = string(line[i+1:])
+			continue
+		}
+		v, err := r.binary()
+		if err != nil {
+			return nil, fmt.Errorf("journal field %s: %w", line, err)
+		}
+		e[string(line)] = v
+	}
+}
+
+func (r *Reader) binary() (string, error) {
+	var size [8]byte
+	if _, err := io.ReadFull(r.r, size[:]); err != nil {
+		return "", err
+	}
+	n := binary.LittleEndian.Uint64(size[:])
+	if n > MaxFieldSize {
+		return "", errors.New("field too large")
+	}
+	data := make([]byte, n+1)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return "", err
+	}
+	if data[n] != '\n' {
+		return "", errors.New("missing newline after binary data")
+	}
+	return string(data[:n]), nil
+}
@@ -0,0 +1,77 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func binaryField(name, value string) string {
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(value)))
+	return name + "\n" + string(n[:]) + value + "\n"
+}
+
+func TestReaderExportFormat(t *testing.T) {
+	stream := "__CURSOR=s=1;i=1\n" +
+		"__REALTIME_TIMESTAMP=1722256524123456\n" +
+		"_HOSTNAME=web01\n" +
+		"SYSLOG_IDENTIFIER=sshd\n" +
+		"_PID=4242\n" +
+		"PRIORITY=6\n" +
+		"MESSAGE=Accepted publickey for alice from 203.0.113.9 port 52144 ssh2\n" +
+		"\n" +
+		"_HOSTNAME=web01\n" +
+		binaryField("MESSAGE", "line one\nline two\x00\x01") +
+		"EMPTY=\n" +
+		"\n\n"
+	r := NewReader(strings.NewReader(stream))
+	e, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e["_HOSTNAME"] != "web01" || e["SYSLOG_IDENTIFIER"] != "sshd" || e["_PID"] != "4242" {
+		t.Fatalf("unexpected entry %v", e)
+	}
+	if want := time.Date(2024, 7, 29, 12, 35, 24, 123456000, time.UTC); !e.Time().Equal(want) {
+		t.Fatalf("expected %s, got %s", want, e.Time())
+	}
+	e, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e["MESSAGE"] != "line one\nline two\x00\x01" {
+		t.Fatalf("binary field not decoded: %q", e["MESSAGE"])
+	}
+	if v, ok := e["EMPTY"]; !ok || v != "" {
+		t.Fatalf("expected empty field, got %q", v)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestReaderLastEntryWithoutBlankLine(t *testing.T) {
+	r := NewReader(strings.NewReader("MESSAGE=hi\n"))
+	if e, err := r.Next(); err != nil || e["MESSAGE"] != "hi" {
+		t.Fatalf("expected final entry, got %v, %v", e, err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	var huge [8]byte
+	binary.LittleEndian.PutUint64(huge[:], MaxFieldSize+1)
+	for name, stream := range map[string]string{
+		"truncated line":   "MESSAGE=hi",
+		"truncated binary": "MESSAGE\n\x05\x00\x00\x00\x00\x00\x00\x00ab",
+		"no newline":       binaryField("MESSAGE", "abc")[:len(binaryField("MESSAGE", "abc"))-1] + "X",
+		"too large":        "MESSAGE\n" + string(huge[:]),
+	} {
+		if _, err := NewReader(bytes.NewBufferString(stream)).Next(); err == nil || err == io.EOF {
+			t.Fatalf("%s: expected error, got %v", name, err)
+		}
+	}
+}
@@ -4,13 +4,37 @@ import "time"
 
 
 type LogEntry struct {
-	Timestamp       time.Time `json:"timestamp"`
-	EventCategory   string    `json:"event.category"`
-	EventSourceType string    `json:"event.source.type"`
-	Username        string    `json:"username,omitempty"`
-	Hostname        string    `json:"hostname,omitempty"`
-	Severity        string    `json:"severity,omitempty"`
-	Service         string    `json:"service,omitempty"`
-	RawMessage      string    `json:"raw.message"`
-	IsBlacklisted   bool      `json:"is.blacklisted"`
+	Timestamp       time.Time  `json:"timestamp"`
+	EventCategory   string     `json:"event.category"`
+	EventSourceType string     `json:"event.source.type"`
+	Username        string     `json:"username,omitempty"`
+	Hostname        string     `json:"hostname,omitempty"`
+	Severity        string     `json:"severity,omitempty"`
+	Service         string     `json:"service,omitempty"`
+	RawMessage      string     `json:"raw.message"`
+	IsBlacklisted   bool       `json:"is.blacklisted"`
+	Attributes      Attributes `json:"attributes,omitempty"`
+
+	// Tenant owns the entry; the server keeps each tenant's data apart.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Set when identical entries were collapsed by the collector.
+	RepeatCount int        `json:"repeat.count,omitempty"`
+	FirstSeen   *time.Time `json:"first.seen,omitempty"`
+	LastSeen    *time.Time `json:"last.seen,omitempty"`
+}
+
+// Attributes holds parsed or enriched fields that have no dedicated
+// LogEntry field, such as source IPs, PIDs or session IDs.
+type Attributes map[string]string
+
+func (e *LogEntry) SetAttr(key, value string) {
+	if e.Attributes == nil {
+		e.Attributes = make(Attributes)
+	}
+	e.Attributes[key] = value
+}
+
+func (e LogEntry) Attr(key string) string {
+	return e.Attributes[key]
 }
@@ -0,0 +1,115 @@
+package multiline
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes how raw lines group into records. A line continues the
+// current record when it matches Continue, or when Start is set and the
+// line does not match it; any other line begins a new record. With neither
+// pattern every line is its own record.
+type Config struct {
+	Start    string `json:"start,omitempty"`
+	Continue string `json:"continue,omitempty"`
+	MaxLines int    `json:"maxLines,omitempty"` // default 500
+	Timeout  string `json:"timeout,omitempty"`  // flush after this much quiet, default 2s
+}
+
+func (c Config) Enabled() bool {
+	return c.Start != "" || c.Continue != ""
+}
+
+// Aggregator assembles lines into records and hands each finished record
+// to emit. It is safe for concurrent use; emit is called in order.
+type Aggregator struct {
+	start, cont *regexp.Regexp
+	maxLines    int
+	timeout     time.Duration
+	emit        func(string)
+
+	mu    sync.Mutex
+	lines []string
+	timer *time.Timer
+}
+
+func New(cfg Config, emit func(string)) (*Aggregator, error) {
+	a := &Aggregator{maxLines: cfg.MaxLines, timeout: 2 * time.Second, emit: emit}
+	var err error
+	if cfg.Start != "" {
+		if a.start, err = regexp.Compile(cfg.Start); err != nil {
+			return nil, fmt.Errorf("multiline start: %w", err)
+		}
+	}
+	if cfg.Continue != "" {
+		if a.cont, err = regexp.Compile(cfg.Continue); err != nil {
+			return nil, fmt.Errorf("multiline continue: %w", err)
+		}
+	}
+	if cfg.Timeout != "" {
+		if a.timeout, err = time.ParseDuration(cfg.Timeout); err != nil {
+			return nil, fmt.Errorf("multiline timeout: %w", err)
+		}
+	}
+	if a.maxLines <= 0 {
+		a.maxLines = 500
+	}
+	return a, nil
+}
+
+func (a *Aggregator) continues(line string) bool {
+	if a.cont != nil && a.cont.MatchString(line) {
+		return true
+	}
+	return a.start != nil && !a.start.MatchString(line)
+}
+
+// Extends reports whether a record is pending and line matches the
+// Continue pattern, so that it belongs to that record whatever it holds.
+func (a *Aggregator) Extends(line string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.lines) > 0 && a.cont != nil && a.cont.MatchString(strings.TrimRight(line, "\r\n"))
+}
+
+// Add feeds one line without its trailing newline.
+func (a *Aggregator) Add(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.lines) > 0 && !a.continues(line) {
+		a.flush()
+	}
+	a.lines = append(a.lines, line)
+	if len(a.lines) >= a.maxLines {
+		a.flush()
+		return
+	}
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.timeout, a.Flush)
+	} else {
+		a.timer.Reset(a.timeout)
+	}
+}
+
+// Flush emits the pending record, if any.
+func (a *Aggregator) Flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.flush()
+}
+
+func (a *Aggregator) flush() {
+	if len(a.lines) == 0 {
+		return
+	}
+	rec := strings.Join(a.lines, "\n")
+	a.lines = a.lines[:0]
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.emit(rec)
+}
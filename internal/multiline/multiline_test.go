@@ -0,0 +1,106 @@
+package multiline
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu   sync.Mutex
+	recs []string
+}
+
+func (r *recorder) emit(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recs = append(r.recs, s)
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.recs...)
+}
+
+func TestStartPattern(t *testing.T) {
+	var r recorder
+	a, err := New(Config{Start: `^\d{4}-\d{2}-\d{2} `}, r.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{
+		"2024-05-01 10:00:00 ERROR boom",
+		"java.lang.IllegalStateException: bad",
+		"\tat com.example.Foo.bar(Foo.java:42)",
+		"2024-05-01 10:00:01 INFO recovered",
+	} {
+		a.Add(l)
+	}
+	a.Flush()
+	got := r.get()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 records, got %q", got)
+	}
+	if got[0] != "2024-05-01 10:00:00 ERROR boom\njava.lang.IllegalStateException: bad\n\tat com.example.Foo.bar(Foo.java:42)" {
+		t.Fatalf("unexpected first record %q", got[0])
+	}
+}
+
+func TestContinuePatternAndMaxLines(t *testing.T) {
+	var r recorder
+	a, err := New(Config{Continue: `^\s`, MaxLines: 3}, r.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{"a", " 1", " 2", " 3", "b", "c\r\n"} {
+		a.Add(l)
+	}
+	a.Flush()
+	got := r.get()
+	want := []string{"a\n 1\n 2", " 3", "b", "c"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestTimeoutFlush(t *testing.T) {
+	var r recorder
+	a, err := New(Config{Start: `^START`, Timeout: "20ms"}, r.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Add("START one")
+	a.Add("more")
+	deadline := time.Now().Add(time.Second)
+	for len(r.get()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := r.get(); len(got) != 1 || got[0] != "START one\nmore" {
+		t.Fatalf("expected timeout flush, got %q", got)
+	}
+	if _, err := New(Config{Start: "("}, r.emit); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+}
+
+func TestExtends(t *testing.T) {
+	var r recorder
+	a, err := New(Config{Continue: `^\s`}, r.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Extends(" {}") {
+		t.Fatalf("no record is pending")
+	}
+	a.Add("a")
+	if !a.Extends(" {}\n") || a.Extends("{}") {
+		t.Fatalf("expected only indented lines to extend the record")
+	}
+	a.Flush()
+}
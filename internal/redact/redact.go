@@ -0,0 +1,214 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"motadata/internal/model"
+)
+
+type Mode string
+
+const (
+	ModeMask Mode = "mask" // replace the match with Mask
+	ModeHash Mode = "hash" // replace the match with a keyed HMAC token
+	ModeDrop Mode = "drop" // remove the match; attributes left empty are removed
+)
+
+const Mask = "[REDACTED]"
+
+// HashPrefix marks values produced by ModeHash.
+const HashPrefix = "hmac:"
+
+type Rule struct {
+	Name     string `json:"name"`
+	Detector string `json:"detector,omitempty"` // built-in: email, ipv4, ipv6, credit_card
+	Pattern  string `json:"pattern,omitempty"`  // custom regexp, used when Detector is empty
+	Mode     Mode   `json:"mode"`
+}
+
+type Config struct {
+	HMACKey string `json:"hmacKey,omitempty"`
+	Rules   []Rule `json:"rules"`
+}
+
+type detector struct {
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+var detectors = map[string]detector{
+	"email": {re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	"ipv4": {
+		re: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\b`),
+	},
+	// The pattern is loose on purpose; candidates such as "10:01:02" are
+	// rejected by the parser.
+	"ipv6": {
+		re: regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`),
+		valid: func(s string) bool {
+			ip := net.ParseIP(s)
+			return ip != nil && ip.To4() == nil
+		},
+	},
+	"credit_card": {re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhn},
+}
+
+type compiled struct {
+	Rule
+	detector
+}
+
+// Redactor rewrites sensitive substrings of log entries and counts how many
+// replacements each rule made.
+type Redactor struct {
+	rules []compiled
+	key   []byte
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func New(cfg Config) (*Redactor, error) {
+	r := &Redactor{key: []byte(cfg.HMACKey), counts: make(map[string]int)}
+	for _, rule := range cfg.Rules {
+		c := compiled{Rule: rule}
+		switch {
+		case rule.Detector != "":
+			d, ok := detectors[rule.Detector]
+			if !ok {
+				return nil, fmt.Errorf("redact: unknown detector %q", rule.Detector)
+			}
+			c.detector = d
+		case rule.Pattern != "":
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("redact: rule %q: %w", rule.Name, err)
+			}
+			c.re = re
+		default:
+			return nil, fmt.Errorf("redact: rule %q needs a detector or pattern", rule.Name)
+		}
+		switch rule.Mode {
+		case ModeMask, ModeDrop:
+		case ModeHash:
+			if len(r.key) == 0 {
+				return nil, errors.New("redact: hash mode requires an HMAC key")
+			}
+		default:
+			return nil, fmt.Errorf("redact: rule %q: unknown mode %q", rule.Name, rule.Mode)
+		}
+		if c.Name == "" {
+			c.Name = rule.Detector
+		}
+		r.rules = append(r.rules, c)
+	}
+	return r, nil
+}
+
+// LoadConfig reads a JSON Config. REDACT_HMAC_KEY, when set, overrides the
+// key in the file so it can be kept out of it.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if k := os.Getenv("REDACT_HMAC_KEY"); k != "" {
+		cfg.HMACKey = k
+	}
+	return cfg, nil
+}
+
+// Apply redacts the raw message, username and attribute values of e.
+func (r *Redactor) Apply(e *model.LogEntry) {
+	counts := make(map[string]int)
+	e.RawMessage = r.redact(e.RawMessage, counts)
+	e.Username = r.redact(e.Username, counts)
+	for k, v := range e.Attributes {
+		if nv := r.redact(v, counts); nv == "" {
+			delete(e.Attributes, k)
+		} else {
+			e.Attributes[k] = nv
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+	r.mu.Lock()
+	for k, n := range counts {
+		r.counts[k] += n
+	}
+	r.mu.Unlock()
+}
+
+func (r *Redactor) redact(s string, counts map[string]int) string {
+	if s == "" {
+		return s
+	}
+	for _, rule := range r.rules {
+		s = rule.re.ReplaceAllStringFunc(s, func(m string) string {
+			if rule.valid != nil && !rule.valid(m) {
+				return m
+			}
+			counts[rule.Name]++
+			switch rule.Mode {
+			case ModeHash:
+				return r.hash(m)
+			case ModeDrop:
+				return ""
+			default:
+				return Mask
+			}
+		})
+	}
+	return s
+}
+
+func (r *Redactor) hash(s string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(s))
+	return HashPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Counts returns the number of redactions per rule since start.
+func (r *Redactor) Counts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]int, len(r.counts))
+	for k, v := range r.counts {
+		out[k] = v
+	}
+	return out
+}
+
+func luhn(s string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,65 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+
+	"motadata/internal/model"
+)
+
+func TestBuiltinDetectorsAndModes(t *testing.T) {
+	r, err := New(Config{
+		HMACKey: "secret",
+		Rules: []Rule{
+			{Detector: "email", Mode: ModeMask},
+			{Detector: "ipv4", Mode: ModeHash},
+			{Detector: "ipv6", Mode: ModeMask},
+			{Detector: "credit_card", Mode: ModeMask},
+			{Name: "password", Pattern: `(?i)password=\S+`, Mode: ModeDrop},
+		},
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	e := model.LogEntry{
+		Username:   "alice@example.com",
+		RawMessage: "Oct 18 10:01:02 h1 app: login alice@example.com from 10.0.0.13 via 2001:db8::1 card 4111 1111 1111 1111 order 1234567890123 password=hunter2",
+		Attributes: model.Attributes{"src_ip": "10.0.0.13", "secret": "password=x"},
+	}
+	r.Apply(&e)
+	if strings.Contains(e.RawMessage, "alice@") || strings.Contains(e.RawMessage, "10.0.0.13") ||
+		strings.Contains(e.RawMessage, "2001:db8") || strings.Contains(e.RawMessage, "4111") || strings.Contains(e.RawMessage, "hunter2") {
+		t.Fatalf("unredacted data left: %q", e.RawMessage)
+	}
+	if !strings.Contains(e.RawMessage, "10:01:02") || !strings.Contains(e.RawMessage, "1234567890123") {
+		t.Fatalf("non-sensitive values must be kept: %q", e.RawMessage)
+	}
+	if e.Username != Mask {
+		t.Fatalf("expected username to be masked, got %q", e.Username)
+	}
+	ip := e.Attr("src_ip")
+	if !strings.HasPrefix(ip, HashPrefix) || !strings.Contains(e.RawMessage, ip) {
+		t.Fatalf("expected the same HMAC token in message and attribute, got %q in %q", ip, e.RawMessage)
+	}
+	if _, ok := e.Attributes["secret"]; ok {
+		t.Fatalf("expected dropped attribute to be removed: %v", e.Attributes)
+	}
+	c := r.Counts()
+	if c["email"] != 2 || c["ipv4"] != 2 || c["ipv6"] != 1 || c["credit_card"] != 1 || c["password"] != 2 {
+		t.Fatalf("unexpected counts: %v", c)
+	}
+}
+
+func TestHashIsKeyed(t *testing.T) {
+	a, _ := New(Config{HMACKey: "k1", Rules: []Rule{{Detector: "ipv4", Mode: ModeHash}}})
+	b, _ := New(Config{HMACKey: "k2", Rules: []Rule{{Detector: "ipv4", Mode: ModeHash}}})
+	if a.hash("10.0.0.1") == b.hash("10.0.0.1") || a.hash("10.0.0.1") != a.hash("10.0.0.1") {
+		t.Fatalf("hash must be deterministic per key and differ between keys")
+	}
+	if _, err := New(Config{Rules: []Rule{{Detector: "ipv4", Mode: ModeHash}}}); err == nil {
+		t.Fatalf("expected error for hash mode without key")
+	}
+	if _, err := New(Config{Rules: []Rule{{Detector: "ssn", Mode: ModeMask}}}); err == nil {
+		t.Fatalf("expected error for unknown detector")
+	}
+}
@@ -0,0 +1,296 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"motadata/internal/model"
+)
+
+const (
+	StateOpen     = "open"
+	StateClosed   = "closed"
+	StateUnclosed = "unclosed" // no logout seen before the timeout
+)
+
+type Session struct {
+	ID        string     `json:"id"`
+	Hostname  string     `json:"hostname"`
+	Username  string     `json:"username,omitempty"`
+	SessionID string     `json:"session.id,omitempty"`
+	PID       string     `json:"pid,omitempty"`
+	Start     time.Time  `json:"start"`
+	End       *time.Time `json:"end,omitempty"`
+	Duration  float64    `json:"duration.seconds,omitempty"`
+	State     string     `json:"state"`
+}
+
+// Category is the event category of the entries returned by Entry.
+const Category = "session.audit"
+
+// Entry returns s as a log entry, so that derived sessions can be stored
+// and searched along with the events they were derived from. Its message
+// and attributes are chosen so that the Correlator ignores it.
+func (s Session) Entry() model.LogEntry {
+	e := model.LogEntry{
+		Timestamp:       s.Start,
+		EventCategory:   Category,
+		EventSourceType: "session",
+		Username:        s.Username,
+		Hostname:        s.Hostname,
+		Severity:        "INFO",
+		RawMessage:      fmt.Sprintf("session %s for user %s on %s %s", s.ID, s.Username, s.Hostname, s.State),
+	}
+	e.SetAttr("session.state", s.State)
+	e.SetAttr("session.start", s.Start.Format(time.RFC3339Nano))
+	if s.End != nil {
+		e.Timestamp = *s.End
+		e.SetAttr("session.end", s.End.Format(time.RFC3339Nano))
+		e.SetAttr("session.duration", strconv.FormatFloat(s.Duration, 'f', -1, 64))
+	}
+	if s.SessionID != "" {
+		e.SetAttr("session_id", s.SessionID)
+	}
+	if s.PID != "" {
+		e.SetAttr("pid", s.PID)
+	}
+	return e
+}
+
+type Filter struct {
+	Username string
+	Hostname string
+	State    string
+	Limit    int
+}
+
+var (
+	reOpened = regexp.MustCompile(`(?i)session opened|new session`)
+	reClosed = regexp.MustCompile(`(?i)session closed|removed session`)
+)
+
+// Correlator pairs session-opened and session-closed events by host, user
+// and session ID or PID, falling back to the oldest open session of the
+// user on that host. At most maxOpen sessions are kept open; beyond that
+// the oldest is reported as unclosed.
+type Correlator struct {
+	mu         sync.Mutex
+	timeout    time.Duration
+	maxHistory int
+	maxOpen    int
+	now        func() time.Time
+	latest     time.Time // newest event time observed
+	seq        int
+	open       map[string][]*Session // by hostname
+	nopen      int
+	done       []Session
+}
+
+func NewCorrelator(timeout time.Duration) *Correlator {
+	return &Correlator{
+		timeout:    timeout,
+		maxHistory: 10000,
+		maxOpen:    10000,
+		now:        time.Now,
+		open:       make(map[string][]*Session),
+	}
+}
+
+func classify(e model.LogEntry) (opened, closed bool) {
+	switch strings.ToLower(e.Attr("session_state")) {
+	case "opened":
+		return true, false
+	case "closed":
+		return false, true
+	}
+	return reOpened.MatchString(e.RawMessage), reClosed.MatchString(e.RawMessage)
+}
+
+// Observe feeds an ingested entry to the correlator. It returns the session
+// that was closed by the entry, if any.
+func (c *Correlator) Observe(e model.LogEntry) (Session, bool) {
+	opened, closed := classify(e)
+	if !opened && !closed {
+		return Session{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Expiry follows event time, so that replaying old entries pairs them
+	// as they happened.
+	if e.Timestamp.After(c.latest) {
+		c.latest = e.Timestamp
+	}
+	c.expireLocked(c.latest)
+	host := strings.ToLower(e.Hostname)
+	if opened {
+		if c.nopen >= c.maxOpen {
+			c.evictOldestLocked()
+		}
+		c.nopen++
+		c.seq++
+		c.open[host] = append(c.open[host], &Session{
+			ID:        strconv.Itoa(c.seq),
+			Hostname:  e.Hostname,
+			Username:  e.Username,
+			SessionID: e.Attr("session_id"),
+			PID:       e.Attr("pid"),
+			Start:     e.Timestamp,
+			State:     StateOpen,
+		})
+		return Session{}, false
+	}
+	i := c.matchLocked(host, e)
+	if i < 0 {
+		return Session{}, false
+	}
+	s := c.removeLocked(host, i)
+	end := e.Timestamp
+	s.End = &end
+	s.Duration = end.Sub(s.Start).Seconds()
+	s.State = StateClosed
+	if s.Username == "" {
+		s.Username = e.Username
+	}
+	c.finishLocked(*s)
+	return *s, true
+}
+
+func (c *Correlator) matchLocked(host string, e model.LogEntry) int {
+	sessions := c.open[host]
+	if id := e.Attr("session_id"); id != "" {
+		for i, s := range sessions {
+			if s.SessionID == id {
+				return i
+			}
+		}
+	}
+	if pid := e.Attr("pid"); pid != "" {
+		for i, s := range sessions {
+			if s.PID == pid && (e.Username == "" || strings.EqualFold(s.Username, e.Username)) {
+				return i
+			}
+		}
+	}
+	if e.Username == "" {
+		return -1
+	}
+	for i, s := range sessions {
+		if strings.EqualFold(s.Username, e.Username) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Correlator) finishLocked(s Session) {
+	c.done = append(c.done, s)
+	if over := len(c.done) - c.maxHistory; over > 0 {
+		c.done = append(c.done[:0:0], c.done[over:]...)
+	}
+}
+
+// expired reports whether a session started at start has timed out as of
+// now.
+func (c *Correlator) expired(start, now time.Time) bool {
+	return c.timeout > 0 && start.Before(now.Add(-c.timeout))
+}
+
+// expireLocked flags open sessions that timed out as of now as unclosed.
+func (c *Correlator) expireLocked(now time.Time) {
+	if c.timeout <= 0 {
+		return
+	}
+	for host, sessions := range c.open {
+		kept := sessions[:0]
+		for _, s := range sessions {
+			if c.expired(s.Start, now) {
+				s.State = StateUnclosed
+				c.finishLocked(*s)
+				c.nopen--
+				continue
+			}
+			kept = append(kept, s)
+		}
+		if len(kept) == 0 {
+			delete(c.open, host)
+		} else {
+			c.open[host] = kept
+		}
+	}
+}
+
+// evictOldestLocked reports the open session that started first as
+// unclosed to make room for a new one.
+func (c *Correlator) evictOldestLocked() {
+	var host string
+	oldest := -1
+	for h, sessions := range c.open {
+		for i, s := range sessions {
+			if oldest < 0 || s.Start.Before(c.open[host][oldest].Start) {
+				host, oldest = h, i
+			}
+		}
+	}
+	if oldest < 0 {
+		return
+	}
+	s := c.removeLocked(host, oldest)
+	s.State = StateUnclosed
+	c.finishLocked(*s)
+}
+
+// removeLocked takes the i-th open session of host off the open list.
+func (c *Correlator) removeLocked(host string, i int) *Session {
+	s := c.open[host][i]
+	c.open[host] = append(c.open[host][:i], c.open[host][i+1:]...)
+	if len(c.open[host]) == 0 {
+		delete(c.open, host)
+	}
+	c.nopen--
+	return s
+}
+
+// Query returns matching sessions, most recently started first. Open
+// sessions that timed out by the wall clock are reported as unclosed but
+// can still be closed by a late logout.
+func (c *Correlator) Query(f Filter) []Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	all := make([]Session, 0, len(c.done))
+	all = append(all, c.done...)
+	for _, sessions := range c.open {
+		for _, s := range sessions {
+			cp := *s
+			if c.expired(cp.Start, now) {
+				cp.State = StateUnclosed
+			}
+			all = append(all, cp)
+		}
+	}
+	res := make([]Session, 0)
+	for _, s := range all {
+		if f.Username != "" && !strings.EqualFold(s.Username, f.Username) {
+			continue
+		}
+		if f.Hostname != "" && !strings.EqualFold(s.Hostname, f.Hostname) {
+			continue
+		}
+		if f.State != "" && !strings.EqualFold(s.State, f.State) {
+			continue
+		}
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Start.After(res[j].Start)
+	})
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[:f.Limit]
+	}
+	return res
+}
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"motadata/internal/model"
+)
+
+func TestPairOpenClose(t *testing.T) {
+	c := NewCorrelator(time.Hour)
+	base := time.Now().UTC()
+	c.Observe(model.LogEntry{Timestamp: base, Hostname: "h1", Username: "alice", RawMessage: "<86> h1 sudo: pam_unix(sudo:session): session opened for user alice(uid=0)"})
+	c.Observe(model.LogEntry{Timestamp: base.Add(time.Second), Hostname: "h1", Username: "bob", Attributes: model.Attributes{"session_state": "opened", "pid": "7"}})
+	c.Observe(model.LogEntry{Timestamp: base.Add(2 * time.Second), Hostname: "h2", Username: "alice", RawMessage: "session opened for user alice"})
+
+	s, ok := c.Observe(model.LogEntry{Timestamp: base.Add(90 * time.Second), Hostname: "h1", Username: "alice", RawMessage: "<86> h1 systemd: session closed for user alice"})
+	if !ok || s.Hostname != "h1" || s.Username != "alice" || s.State != StateClosed || s.Duration != 90 {
+		t.Fatalf("unexpected session: %+v (ok=%v)", s, ok)
+	}
+	s, ok = c.Observe(model.LogEntry{Timestamp: base.Add(5 * time.Second), Hostname: "h1", Attributes: model.Attributes{"session_state": "closed", "pid": "7"}})
+	if !ok || s.Username != "bob" {
+		t.Fatalf("expected bob's session to be closed by pid, got %+v (ok=%v)", s, ok)
+	}
+	if _, ok := c.Observe(model.LogEntry{Timestamp: base, Hostname: "h3", Username: "carol", RawMessage: "session closed for user carol"}); ok {
+		t.Fatalf("close without open must not produce a session")
+	}
+
+	if got := c.Query(Filter{State: StateClosed}); len(got) != 2 {
+		t.Fatalf("expected 2 closed sessions, got %+v", got)
+	}
+	open := c.Query(Filter{State: StateOpen})
+	if len(open) != 1 || open[0].Hostname != "h2" {
+		t.Fatalf("expected h2 session to stay open, got %+v", open)
+	}
+}
+
+func TestUnclosedAfterTimeout(t *testing.T) {
+	c := NewCorrelator(time.Hour)
+	now := time.Now().UTC()
+	c.now = func() time.Time { return now }
+	c.Observe(model.LogEntry{Timestamp: now.Add(-2 * time.Hour), Hostname: "h1", Username: "alice", RawMessage: "session opened for user alice"})
+	c.Observe(model.LogEntry{Timestamp: now.Add(-time.Minute), Hostname: "h1", Username: "bob", RawMessage: "session opened for user bob"})
+
+	got := c.Query(Filter{State: StateUnclosed})
+	if len(got) != 1 || got[0].Username != "alice" || got[0].End != nil {
+		t.Fatalf("expected alice's session flagged unclosed, got %+v", got)
+	}
+	if _, ok := c.Observe(model.LogEntry{Timestamp: now, Hostname: "h1", Username: "alice", RawMessage: "session closed for user alice"}); ok {
+		t.Fatalf("expired session must not be closed again")
+	}
+}
+
+func TestReplayUsesEventTime(t *testing.T) {
+	c := NewCorrelator(24 * time.Hour)
+	start := time.Now().UTC().Add(-72 * time.Hour)
+	c.Observe(model.LogEntry{Timestamp: start, Hostname: "h1", Username: "alice", RawMessage: "session opened for user alice"})
+	c.Observe(model.LogEntry{Timestamp: start.Add(time.Second), Hostname: "h1", Username: "bob", RawMessage: "session opened for user bob"})
+	if _, ok := c.Observe(model.LogEntry{Timestamp: start.Add(time.Minute), Hostname: "h1", Username: "alice", RawMessage: "session closed for user alice"}); !ok {
+		t.Fatalf("expected a replayed logout to close its session")
+	}
+	got := c.Query(Filter{})
+	if len(got) != 2 || got[1].Username != "alice" || got[1].State != StateClosed || got[0].State != StateUnclosed {
+		t.Fatalf("expected alice closed and bob reported unclosed, got %+v", got)
+	}
+}
+
+func TestOpenSessionsBounded(t *testing.T) {
+	c := NewCorrelator(0)
+	c.maxOpen = 2
+	base := time.Now().UTC()
+	for i, user := range []string{"alice", "bob", "carol"} {
+		c.Observe(model.LogEntry{Timestamp: base.Add(time.Duration(i) * time.Second), Hostname: "h" + user, Username: user, RawMessage: "session opened for user " + user})
+	}
+	if got := c.Query(Filter{State: StateOpen}); len(got) != 2 {
+		t.Fatalf("expected 2 open sessions, got %+v", got)
+	}
+	if got := c.Query(Filter{State: StateUnclosed}); len(got) != 1 || got[0].Username != "alice" {
+		t.Fatalf("expected the oldest session evicted as unclosed, got %+v", got)
+	}
+}
+
+func TestSessionEntry(t *testing.T) {
+	c := NewCorrelator(time.Hour)
+	base := time.Now().UTC()
+	c.Observe(model.LogEntry{Timestamp: base, Hostname: "h1", Username: "alice", Attributes: model.Attributes{"session_state": "opened", "pid": "7"}})
+	s, ok := c.Observe(model.LogEntry{Timestamp: base.Add(time.Minute), Hostname: "h1", Attributes: model.Attributes{"session_state": "closed", "pid": "7"}})
+	if !ok {
+		t.Fatal("expected a closed session")
+	}
+	e := s.Entry()
+	if e.EventCategory != Category || e.Username != "alice" || !e.Timestamp.Equal(base.Add(time.Minute)) {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if e.Attr("session.state") != StateClosed || e.Attr("session.duration") != "60" || e.Attr("pid") != "7" {
+		t.Fatalf("unexpected attributes %v", e.Attributes)
+	}
+	// Feeding the derived entry back, e.g. when rebuilding from the store,
+	// changes nothing.
+	c.Observe(model.LogEntry{Timestamp: base.Add(2 * time.Minute), Hostname: "h1", Username: "alice", Attributes: model.Attributes{"session_state": "opened"}})
+	if _, ok := c.Observe(e); ok || len(c.Query(Filter{State: StateOpen})) != 1 {
+		t.Fatal("derived entry must not open or close sessions")
+	}
+}
@@ -0,0 +1,161 @@
+package sigma
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"motadata/internal/model"
+)
+
+type condParser struct {
+	tokens   []string
+	pos      int
+	searches map[string]predicate
+}
+
+// parseCondition compiles a Sigma condition such as
+// "selection and not 1 of filter_*" over the named searches.
+func parseCondition(cond string, searches map[string]predicate) (predicate, error) {
+	if strings.Contains(cond, "|") {
+		return nil, fmt.Errorf("aggregation conditions are not supported: %q", cond)
+	}
+	p := &condParser{tokens: tokenize(cond), searches: searches}
+	pred, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in condition %q", p.tokens[p.pos], cond)
+	}
+	return pred, nil
+}
+
+func tokenize(s string) []string {
+	s = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(s)
+	return strings.Fields(s)
+}
+
+func (p *condParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *condParser) next() string {
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *condParser) or() (predicate, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	alts := []predicate{left}
+	for p.peek() == "or" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, right)
+	}
+	return anyOf(alts), nil
+}
+
+func (p *condParser) and() (predicate, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	all := []predicate{left}
+	for p.peek() == "and" {
+		p.next()
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, right)
+	}
+	return allOf(all), nil
+}
+
+func (p *condParser) not() (predicate, error) {
+	if p.peek() == "not" {
+		p.next()
+		inner, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(e model.LogEntry) bool { return !inner(e) }, nil
+	}
+	return p.primary()
+}
+
+func (p *condParser) primary() (predicate, error) {
+	switch tok := p.peek(); tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of condition")
+	case "(":
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.next()
+		return inner, nil
+	case "1", "any", "all":
+		p.next()
+		if p.peek() != "of" {
+			return nil, fmt.Errorf("expected 'of' after %q", tok)
+		}
+		p.next()
+		if p.peek() == "" {
+			return nil, fmt.Errorf("missing search pattern after 'of'")
+		}
+		matched := p.expand(p.next())
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no search identifiers match %q", p.tokens[p.pos-1])
+		}
+		if tok == "all" {
+			return allOf(matched), nil
+		}
+		return anyOf(matched), nil
+	default:
+		name := p.next()
+		s, ok := p.searches[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown search identifier %q", name)
+		}
+		return s, nil
+	}
+}
+
+// expand resolves "them" or a wildcard pattern to the matching searches.
+func (p *condParser) expand(pattern string) []predicate {
+	names := make([]string, 0, len(p.searches))
+	for name := range p.searches {
+		if pattern == "them" {
+			if !strings.HasPrefix(name, "_") {
+				names = append(names, name)
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	out := make([]predicate, 0, len(names))
+	for _, n := range names {
+		out = append(out, p.searches[n])
+	}
+	return out
+}
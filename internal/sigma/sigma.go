@@ -0,0 +1,468 @@
+package sigma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"motadata/internal/model"
+)
+
+type LogSource struct {
+	Category string `yaml:"category"`
+	Product  string `yaml:"product"`
+	Service  string `yaml:"service"`
+}
+
+type Rule struct {
+	ID          string    `yaml:"id"`
+	Title       string    `yaml:"title"`
+	Status      string    `yaml:"status"`
+	Description string    `yaml:"description"`
+	Level       string    `yaml:"level"`
+	Tags        []string  `yaml:"tags"`
+	LogSource   LogSource `yaml:"logsource"`
+	Detection   yaml.Node `yaml:"detection"`
+
+	match predicate
+}
+
+type predicate func(model.LogEntry) bool
+
+// Match reports whether e comes from the rule's log source and satisfies
+// its detection.
+func (r *Rule) Match(e model.LogEntry) bool {
+	return r.LogSource.matches(e) && r.match(e)
+}
+
+// productSources lists the source types a Sigma product covers, besides
+// the product name itself.
+var productSources = map[string][]string{
+	"linux": {"auditd", "journald"},
+}
+
+// matches compares category with the event category, product with the
+// source type and service with the service or syslog program. Empty
+// fields match anything.
+func (ls LogSource) matches(e model.LogEntry) bool {
+	if ls.Category != "" && !strings.EqualFold(ls.Category, e.EventCategory) {
+		return false
+	}
+	if ls.Service != "" && !strings.EqualFold(ls.Service, e.Service) && !strings.EqualFold(ls.Service, e.Attr("program")) {
+		return false
+	}
+	if ls.Product == "" || strings.EqualFold(ls.Product, e.EventSourceType) {
+		return true
+	}
+	for _, s := range productSources[strings.ToLower(ls.Product)] {
+		if strings.EqualFold(s, e.EventSourceType) {
+			return true
+		}
+	}
+	return false
+}
+
+var levels = map[string]int{"informational": 1, "low": 2, "medium": 3, "high": 4, "critical": 5}
+
+// LevelRank orders Sigma levels; unknown levels rank lowest.
+func LevelRank(level string) int {
+	return levels[strings.ToLower(level)]
+}
+
+// Parse reads one or more YAML documents and compiles each Sigma rule.
+func Parse(r io.Reader) ([]*Rule, error) {
+	var rules []*Rule
+	err := parse(r, func(rule *Rule, err error) error {
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// parse hands each rule, or the error compiling it, to fn and stops at the
+// first error fn returns or that is not about a single rule.
+func parse(r io.Reader, fn func(*Rule, error) error) error {
+	dec := yaml.NewDecoder(r)
+	for {
+		var rule Rule
+		err := dec.Decode(&rule)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rule.Detection.Kind == 0 {
+			continue
+		}
+		if err = rule.compile(); err != nil {
+			err = fn(nil, fmt.Errorf("rule %q: %w", rule.title(), err))
+		} else {
+			err = fn(&rule, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// LoadDir compiles every .yml/.yaml file in dir. Rules that cannot be
+// compiled, e.g. aggregations, and files that are not valid YAML are
+// skipped and reported in skipped; err is only set if dir cannot be read.
+func LoadDir(dir string) (rules []*Rule, skipped []error, err error) {
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if ext := strings.ToLower(filepath.Ext(path)); ext != ".yml" && ext != ".yaml" {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		err = parse(bytes.NewReader(b), func(rule *Rule, err error) error {
+			if err != nil {
+				skipped = append(skipped, fmt.Errorf("%s: %w", path, err))
+			} else {
+				rules = append(rules, rule)
+			}
+			return nil
+		})
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("%s: %w", path, err))
+		}
+		return nil
+	})
+	return rules, skipped, err
+}
+
+func (r *Rule) title() string {
+	if r.Title != "" {
+		return r.Title
+	}
+	return r.ID
+}
+
+func (r *Rule) compile() error {
+	if r.Detection.Kind != yaml.MappingNode {
+		return errors.New("detection must be a mapping")
+	}
+	searches := make(map[string]predicate)
+	var conditions []string
+	for i := 0; i+1 < len(r.Detection.Content); i += 2 {
+		key, val := r.Detection.Content[i].Value, r.Detection.Content[i+1]
+		switch key {
+		case "condition":
+			switch val.Kind {
+			case yaml.ScalarNode:
+				conditions = append(conditions, val.Value)
+			case yaml.SequenceNode:
+				for _, c := range val.Content {
+					conditions = append(conditions, c.Value)
+				}
+			}
+		case "timeframe":
+			// Only used by aggregations, which are not supported.
+		default:
+			p, err := compileSearch(val)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			searches[key] = p
+		}
+	}
+	if len(conditions) == 0 {
+		return errors.New("missing condition")
+	}
+	var alts []predicate
+	for _, c := range conditions {
+		p, err := parseCondition(c, searches)
+		if err != nil {
+			return err
+		}
+		alts = append(alts, p)
+	}
+	r.match = anyOf(alts)
+	return nil
+}
+
+func compileSearch(n *yaml.Node) (predicate, error) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return compileMap(n)
+	case yaml.SequenceNode:
+		var alts []predicate
+		for _, item := range n.Content {
+			if item.Kind == yaml.MappingNode {
+				p, err := compileMap(item)
+				if err != nil {
+					return nil, err
+				}
+				alts = append(alts, p)
+				continue
+			}
+			// Keyword search over the raw message.
+			m, err := compileValue(item.Value, []string{"contains"})
+			if err != nil {
+				return nil, err
+			}
+			alts = append(alts, func(e model.LogEntry) bool { return m(e.RawMessage, true) })
+		}
+		return anyOf(alts), nil
+	case yaml.ScalarNode:
+		m, err := compileValue(n.Value, []string{"contains"})
+		if err != nil {
+			return nil, err
+		}
+		return func(e model.LogEntry) bool { return m(e.RawMessage, true) }, nil
+	}
+	return nil, errors.New("unsupported search definition")
+}
+
+func compileMap(n *yaml.Node) (predicate, error) {
+	var all []predicate
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		parts := strings.Split(n.Content[i].Value, "|")
+		field, mods := parts[0], parts[1:]
+		values := []*yaml.Node{n.Content[i+1]}
+		if n.Content[i+1].Kind == yaml.SequenceNode {
+			values = n.Content[i+1].Content
+		}
+		requireAll := false
+		for _, m := range mods {
+			if m == "all" {
+				requireAll = true
+			}
+		}
+		var matchers []valueMatcher
+		for _, v := range values {
+			if v.Tag == "!!null" {
+				matchers = append(matchers, func(s string, ok bool) bool { return !ok || s == "" })
+				continue
+			}
+			m, err := compileValue(v.Value, mods)
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, m)
+		}
+		all = append(all, func(e model.LogEntry) bool {
+			s, ok := Field(e, field)
+			for _, m := range matchers {
+				if m(s, ok) != requireAll {
+					return !requireAll
+				}
+			}
+			return requireAll
+		})
+	}
+	return allOf(all), nil
+}
+
+type valueMatcher func(value string, present bool) bool
+
+func compileValue(v string, mods []string) (valueMatcher, error) {
+	kind := ""
+	for _, m := range mods {
+		switch m {
+		case "contains", "startswith", "endswith", "re", "cidr":
+			kind = m
+		case "all":
+		default:
+			return nil, fmt.Errorf("unsupported modifier %q", m)
+		}
+	}
+	switch kind {
+	case "re":
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		return func(s string, ok bool) bool { return ok && re.MatchString(s) }, nil
+	case "cidr":
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		return func(s string, ok bool) bool {
+			ip := net.ParseIP(s)
+			return ok && ip != nil && n.Contains(ip)
+		}, nil
+	}
+	pattern := wildcard(v)
+	switch kind {
+	case "contains":
+		pattern = ".*" + pattern + ".*"
+	case "startswith":
+		pattern = pattern + ".*"
+	case "endswith":
+		pattern = ".*" + pattern
+	}
+	re, err := regexp.Compile("(?is)^" + pattern + "$")
+	if err != nil {
+		return nil, err
+	}
+	return func(s string, ok bool) bool { return ok && re.MatchString(s) }, nil
+}
+
+// wildcard translates Sigma's * and ? wildcards (with \ escapes) to regexp.
+func wildcard(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '\\' && i+1 < len(v) && strings.IndexByte(`*?\`, v[i+1]) >= 0:
+			b.WriteString(regexp.QuoteMeta(v[i+1 : i+2]))
+			i++
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+var fieldAliases = map[string]string{
+	"user": "username", "user.name": "username", "targetusername": "username",
+	"host": "hostname", "host.hostname": "hostname", "host.name": "hostname", "computer": "hostname",
+	"message": "raw.message", "msg": "raw.message", "event.original": "raw.message",
+	"service.name": "service",
+	"level":        "severity", "log.level": "severity",
+	"category":     "event.category",
+	"event.module": "event.source.type",
+	"source.ip":    "src_ip", "source.port": "src_port", "process.pid": "pid", "process.name": "program",
+}
+
+// Field resolves a Sigma field name against an entry. LogEntry fields are
+// addressed by their JSON name or a common alias; anything else is looked up
+// in the attributes.
+func Field(e model.LogEntry, name string) (string, bool) {
+	key := strings.ToLower(name)
+	if a, ok := fieldAliases[key]; ok {
+		key = a
+	}
+	switch key {
+	case "username":
+		return e.Username, e.Username != ""
+	case "hostname":
+		return e.Hostname, e.Hostname != ""
+	case "raw.message":
+		return e.RawMessage, true
+	case "service":
+		return e.Service, e.Service != ""
+	case "severity":
+		return e.Severity, e.Severity != ""
+	case "event.category":
+		return e.EventCategory, e.EventCategory != ""
+	case "event.source.type":
+		return e.EventSourceType, e.EventSourceType != ""
+	case "is.blacklisted":
+		return strconv.FormatBool(e.IsBlacklisted), true
+	}
+	for _, k := range []string{name, key, strings.TrimPrefix(key, "attr."), strings.TrimPrefix(key, "attributes.")} {
+		if v, ok := e.Attributes[k]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func anyOf(ps []predicate) predicate {
+	if len(ps) == 1 {
+		return ps[0]
+	}
+	return func(e model.LogEntry) bool {
+		for _, p := range ps {
+			if p(e) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func allOf(ps []predicate) predicate {
+	if len(ps) == 1 {
+		return ps[0]
+	}
+	return func(e model.LogEntry) bool {
+		for _, p := range ps {
+			if !p(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Engine evaluates a rule set and tags matching entries.
+type Engine struct {
+	rules []*Rule
+}
+
+func NewEngine(rules []*Rule) *Engine {
+	return &Engine{rules: rules}
+}
+
+func (en *Engine) Len() int {
+	return len(en.rules)
+}
+
+func (en *Engine) Match(e model.LogEntry) []*Rule {
+	var out []*Rule
+	for _, r := range en.rules {
+		if r.Match(e) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Tag records matching rule IDs in sigma.rule_id (comma-separated) and the
+// highest matching level in sigma.level. It reports whether any rule matched.
+func (en *Engine) Tag(e *model.LogEntry) bool {
+	matched := en.Match(*e)
+	if len(matched) == 0 {
+		return false
+	}
+	ids := make([]string, 0, len(matched))
+	level := ""
+	for _, r := range matched {
+		id := r.ID
+		if id == "" {
+			id = r.Title
+		}
+		ids = append(ids, id)
+		if LevelRank(r.Level) > LevelRank(level) {
+			level = strings.ToLower(r.Level)
+		}
+	}
+	sort.Strings(ids)
+	e.SetAttr("sigma.rule_id", strings.Join(ids, ","))
+	if level != "" {
+		e.SetAttr("sigma.level", level)
+	}
+	return true
+}
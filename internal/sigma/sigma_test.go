@@ -0,0 +1,145 @@
+package sigma
+
+import (
+	"strings"
+	"testing"
+
+	"motadata/internal/model"
+)
+
+func mustParse(t *testing.T, src string) *Rule {
+	t.Helper()
+	rules, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(rules))
+	}
+	return rules[0]
+}
+
+func TestSelectionsAndModifiers(t *testing.T) {
+	r := mustParse(t, `
+title: test
+id: t1
+level: high
+detection:
+  sel_user:
+    user.name|startswith: adm
+  sel_msg:
+    - message|contains|all:
+        - failed
+        - password
+    - message|re: '^Accepted \w+ for'
+  sel_host:
+    hostname: 'db-*'
+  filter:
+    pid: null
+  condition: (1 of sel_user or sel_msg) and sel_host and not filter
+`)
+	cases := []struct {
+		e    model.LogEntry
+		want bool
+	}{
+		{model.LogEntry{Username: "Administrator", Hostname: "db-01", Attributes: model.Attributes{"pid": "1"}}, true},
+		{model.LogEntry{Username: "bob", Hostname: "DB-02", RawMessage: "FAILED login, bad Password", Attributes: model.Attributes{"pid": "1"}}, true},
+		{model.LogEntry{Username: "bob", Hostname: "db-02", RawMessage: "failed login", Attributes: model.Attributes{"pid": "1"}}, false},
+		{model.LogEntry{Username: "bob", Hostname: "db-02", RawMessage: "Accepted publickey for bob", Attributes: model.Attributes{"pid": "1"}}, true},
+		{model.LogEntry{Username: "admin", Hostname: "api-03", Attributes: model.Attributes{"pid": "1"}}, false},
+		{model.LogEntry{Username: "admin", Hostname: "db-01"}, false},
+	}
+	for i, c := range cases {
+		if got := r.Match(c.e); got != c.want {
+			t.Fatalf("case %d: got %v, want %v for %+v", i, got, c.want, c.e)
+		}
+	}
+}
+
+func TestAllOfThemAndErrors(t *testing.T) {
+	r := mustParse(t, `
+detection:
+  a:
+    username: alice
+  b:
+    severity: warn
+  condition: all of them
+`)
+	if !r.Match(model.LogEntry{Username: "alice", Severity: "WARN"}) || r.Match(model.LogEntry{Username: "alice"}) {
+		t.Fatalf("unexpected all-of-them result")
+	}
+	for _, bad := range []string{
+		"detection:\n  a:\n    username: x\n  condition: a | count() > 5\n",
+		"detection:\n  a:\n    username: x\n  condition: b\n",
+		"detection:\n  a:\n    username|base64: x\n  condition: a\n",
+		"detection:\n  a:\n    username: x\n  condition: (a\n",
+	} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadDirAndTag(t *testing.T) {
+	rules, skipped, err := LoadDir("testdata")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// The aggregation is skipped; the rest of its file still loads.
+	if len(skipped) != 1 || !strings.Contains(skipped[0].Error(), "Many SSH Failures") {
+		t.Fatalf("expected the aggregation rule to be skipped, got %v", skipped)
+	}
+	en := NewEngine(rules)
+	if en.Len() != 3 {
+		t.Fatalf("expected 3 rules, got %d", en.Len())
+	}
+	e := model.LogEntry{
+		Username:        "root",
+		EventSourceType: "linux",
+		RawMessage:      "Failed password for invalid user root from 10.0.0.13 port 22 ssh2; session opened",
+		Attributes:      model.Attributes{"src_ip": "10.0.0.13", "program": "sshd"},
+	}
+	if !en.Tag(&e) {
+		t.Fatalf("expected a match")
+	}
+	if e.Attr("sigma.rule_id") != "privileged-session,ssh-failed-invalid-user" || e.Attr("sigma.level") != "high" {
+		t.Fatalf("unexpected tags: %v", e.Attributes)
+	}
+	internal := model.LogEntry{EventSourceType: "linux", RawMessage: "Failed password for invalid user bob", Attributes: model.Attributes{"src_ip": "192.168.1.66", "program": "sshd"}}
+	if en.Tag(&internal) {
+		t.Fatalf("filtered source must not match: %v", internal.Attributes)
+	}
+}
+
+func TestLogSource(t *testing.T) {
+	r := mustParse(t, `
+id: ssh
+logsource:
+  category: login.audit
+  product: linux
+  service: sshd
+detection:
+  keywords:
+    - 'Failed password'
+  condition: keywords
+`)
+	e := model.LogEntry{EventCategory: "login.audit", EventSourceType: "linux", RawMessage: "Failed password for bob", Attributes: model.Attributes{"program": "sshd"}}
+	if !r.Match(e) {
+		t.Fatalf("expected a match for %+v", e)
+	}
+	e.EventSourceType = "journald"
+	if !r.Match(e) {
+		t.Fatalf("expected journald entries to count as linux")
+	}
+	for _, change := range []func(*model.LogEntry){
+		func(e *model.LogEntry) { e.EventSourceType = "windows" },
+		func(e *model.LogEntry) { e.EventCategory = "application.log" },
+		func(e *model.LogEntry) { e.Attributes = model.Attributes{"program": "sudo"} },
+	} {
+		other := e
+		change(&other)
+		if r.Match(other) {
+			t.Fatalf("expected no match outside the log source: %+v", other)
+		}
+	}
+}
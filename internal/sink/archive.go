@@ -0,0 +1,289 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"motadata/internal/model"
+)
+
+const (
+	RotateHourly = "hourly"
+	RotateDaily  = "daily"
+
+	archiveExt = ".ndjson.gz"
+	partialExt = ".partial"
+)
+
+// Archive writes gzip-compressed NDJSON files rotated per hour or day (UTC).
+// The file being written carries a .partial suffix and is renamed when its
+// period ends, so anything without the suffix is complete. Finished
+// archives older than the retention are deleted; zero keeps them forever.
+type Archive struct {
+	dir       string
+	prefix    string
+	rotation  string
+	retention time.Duration
+	format    string
+	now       func() time.Time
+
+	mu        sync.Mutex
+	period    string
+	final     string
+	f         *os.File
+	gz        *gzip.Writer
+	enc       *json.Encoder
+	lastFlush time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewArchive(dir, prefix, rotation string, retention time.Duration, format string) (*Archive, error) {
+	if rotation == "" {
+		rotation = RotateDaily
+	}
+	if rotation != RotateHourly && rotation != RotateDaily {
+		return nil, fmt.Errorf("unknown rotation %q", rotation)
+	}
+	if prefix == "" {
+		prefix = "collector"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	a := &Archive{
+		dir:       dir,
+		prefix:    prefix,
+		rotation:  rotation,
+		retention: retention,
+		format:    format,
+		now:       time.Now,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := a.recoverPartials(); err != nil {
+		return nil, err
+	}
+	a.cleanup()
+	go a.run()
+	return a, nil
+}
+
+func (a *Archive) periodOf(t time.Time) string {
+	t = t.UTC()
+	if a.rotation == RotateHourly {
+		return t.Format("2006-01-02T15")
+	}
+	return t.Format("2006-01-02")
+}
+
+// recoverPartials finalizes files a previous process left unfinished. Their
+// gzip stream may be truncated, so the complete lines that were flushed are
+// rewritten into a new, complete archive and the partial file is removed.
+func (a *Archive) recoverPartials() error {
+	matches, err := filepath.Glob(filepath.Join(a.dir, a.prefix+"-*"+archiveExt+partialExt))
+	if err != nil {
+		return err
+	}
+	for _, p := range matches {
+		final := strings.TrimSuffix(p, partialExt)
+		if exists(final) {
+			final = freeName(strings.TrimSuffix(final, archiveExt))
+		}
+		n, err := repair(p, final)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		log.Printf("archive: recovered %d entries from unfinished %s", n, filepath.Base(p))
+	}
+	return nil
+}
+
+// repair copies the complete lines readable from the gzip file src into a
+// new archive dst, keeping the modification time of src for retention. No
+// file is written when nothing is readable.
+func repair(src, dst string) (int, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	n := 0
+	if gzr, err := gzip.NewReader(in); err == nil {
+		r := bufio.NewReader(gzr)
+		for {
+			// A read error is where the stream was cut off.
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				break
+			}
+			if json.Valid(line) {
+				gzw.Write(line)
+				n++
+			}
+		}
+	}
+	if err := gzw.Close(); err != nil || n == 0 {
+		return 0, err
+	}
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return 0, err
+	}
+	if info, err := in.Stat(); err == nil {
+		os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	}
+	return n, os.Rename(tmp, dst)
+}
+
+// freeName returns base.ndjson.gz, or base.N.ndjson.gz when a file for the
+// same period already exists (e.g. after a restart).
+func freeName(base string) string {
+	name := base + archiveExt
+	for n := 1; ; n++ {
+		if !exists(name) && !exists(name+partialExt) {
+			return name
+		}
+		name = fmt.Sprintf("%s.%d%s", base, n, archiveExt)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (a *Archive) Send(e model.LogEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	if p := a.periodOf(now); a.f == nil || p != a.period {
+		if err := a.finalize(); err != nil {
+			return err
+		}
+		if err := a.open(p); err != nil {
+			return err
+		}
+	}
+	if err := a.enc.Encode(encode(e, a.format)); err != nil {
+		return err
+	}
+	// Flush at most once a second so a crash loses little without
+	// defeating compression.
+	if now.Sub(a.lastFlush) >= time.Second {
+		a.lastFlush = now
+		return a.gz.Flush()
+	}
+	return nil
+}
+
+func (a *Archive) open(period string) error {
+	final := freeName(filepath.Join(a.dir, a.prefix+"-"+period))
+	f, err := os.OpenFile(final+partialExt, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	a.period, a.final, a.f = period, final, f
+	a.gz = gzip.NewWriter(f)
+	a.enc = json.NewEncoder(a.gz)
+	return nil
+}
+
+// finalize completes the current file and renames it into place.
+func (a *Archive) finalize() error {
+	if a.f == nil {
+		return nil
+	}
+	f, gz, final := a.f, a.gz, a.final
+	a.f, a.gz, a.enc = nil, nil, nil
+
+	err := gz.Close()
+	if serr := f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), final); err != nil {
+		return err
+	}
+	a.cleanup()
+	return nil
+}
+
+// cleanup removes finished archives last written before the retention.
+func (a *Archive) cleanup() {
+	if a.retention <= 0 {
+		return
+	}
+	matches, _ := filepath.Glob(filepath.Join(a.dir, a.prefix+"-*"+archiveExt))
+	cutoff := a.now().Add(-a.retention)
+	for _, p := range matches {
+		info, err := os.Stat(p)
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			log.Printf("archive: %v", err)
+		}
+	}
+}
+
+// run finalizes the file once its period is over even if no further
+// entries arrive, and flushes buffered data.
+func (a *Archive) run() {
+	defer close(a.done)
+	t := time.NewTicker(10 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-t.C:
+			a.tick()
+		}
+	}
+}
+
+func (a *Archive) tick() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return
+	}
+	var err error
+	if a.periodOf(a.now()) != a.period {
+		err = a.finalize()
+	} else {
+		err = a.gz.Flush()
+	}
+	if err != nil {
+		log.Printf("archive: %v", err)
+	}
+}
+
+func (a *Archive) Close() error {
+	close(a.stop)
+	<-a.done
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.finalize()
+}
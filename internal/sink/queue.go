@@ -0,0 +1,140 @@
+package sink
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"motadata/internal/model"
+)
+
+type QueueOptions struct {
+	Size       int
+	MaxRetries int
+	Backoff    time.Duration
+	// Workers sending in parallel. Entries are spread by tenant, so each
+	// tenant's entries stay in order and one tenant being retried does not
+	// hold up the tenants of the other workers. More than one needs a sink
+	// safe for concurrent use.
+	Workers int
+	// DropWhenFull drops entries while the buffer is full instead of
+	// making the caller wait, for best-effort destinations that must not
+	// slow down the rest.
+	DropWhenFull bool
+}
+
+type Stats struct {
+	Sent    int64 `json:"sent"`
+	Failed  int64 `json:"failed"`
+	Dropped int64 `json:"dropped"`
+	Queued  int   `json:"queued"`
+}
+
+// Queue feeds a sink from bounded buffers on its own goroutines, one buffer
+// per worker, retrying failed sends with exponential backoff unless the
+// error is permanent. A full buffer makes Enqueue wait, passing the
+// backpressure on to the inputs, unless DropWhenFull is set.
+type Queue struct {
+	name string
+	sink Sink
+	opts QueueOptions
+	chs  []chan model.LogEntry
+	done sync.WaitGroup
+
+	sent, failed, dropped atomic.Int64
+}
+
+func NewQueue(name string, s Sink, opts QueueOptions) *Queue {
+	if opts.Size <= 0 {
+		opts.Size = 1024
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 500 * time.Millisecond
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	q := &Queue{name: name, sink: s, opts: opts}
+	size := max(opts.Size/opts.Workers, 1)
+	q.done.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		ch := make(chan model.LogEntry, size)
+		q.chs = append(q.chs, ch)
+		go q.run(ch)
+	}
+	return q
+}
+
+// buffer returns the buffer of the worker sending e's tenant.
+func (q *Queue) buffer(e model.LogEntry) chan model.LogEntry {
+	if len(q.chs) == 1 {
+		return q.chs[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(e.Tenant))
+	return q.chs[h.Sum32()%uint32(len(q.chs))]
+}
+
+// Enqueue buffers e and reports whether it was accepted; only a queue with
+// DropWhenFull rejects entries.
+func (q *Queue) Enqueue(e model.LogEntry) bool {
+	if !q.opts.DropWhenFull {
+		q.buffer(e) <- e
+		return true
+	}
+	select {
+	case q.buffer(e) <- e:
+		return true
+	default:
+		q.dropped.Add(1)
+		return false
+	}
+}
+
+func (q *Queue) run(ch chan model.LogEntry) {
+	defer q.done.Done()
+	for e := range ch {
+		backoff := q.opts.Backoff
+		var err error
+		for attempt := 0; attempt <= q.opts.MaxRetries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+			if err = q.sink.Send(e); err == nil || permanent(err) {
+				break
+			}
+		}
+		if err != nil {
+			q.failed.Add(1)
+			log.Printf("sink %s: %v", q.name, err)
+			continue
+		}
+		q.sent.Add(1)
+	}
+}
+
+func permanent(err error) bool {
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+func (q *Queue) Stats() Stats {
+	queued := 0
+	for _, ch := range q.chs {
+		queued += len(ch)
+	}
+	return Stats{Sent: q.sent.Load(), Failed: q.failed.Load(), Dropped: q.dropped.Load(), Queued: queued}
+}
+
+// Close drains the buffers and closes the sink.
+func (q *Queue) Close() error {
+	for _, ch := range q.chs {
+		close(ch)
+	}
+	q.done.Wait()
+	return q.sink.Close()
+}
@@ -0,0 +1,248 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"motadata/internal/model"
+)
+
+// Match selects entries for a route; empty fields match anything.
+// Categories accept globs such as "alert.*".
+type Match struct {
+	Categories  []string `json:"categories,omitempty"`
+	Severities  []string `json:"severities,omitempty"`
+	Blacklisted *bool    `json:"blacklisted,omitempty"`
+}
+
+func (m Match) matches(e model.LogEntry) bool {
+	if len(m.Categories) > 0 && !anyGlob(m.Categories, e.EventCategory) {
+		return false
+	}
+	if len(m.Severities) > 0 && !anyGlob(m.Severities, e.Severity) {
+		return false
+	}
+	if m.Blacklisted != nil && *m.Blacklisted != e.IsBlacklisted {
+		return false
+	}
+	return true
+}
+
+func anyGlob(patterns []string, s string) bool {
+	s = strings.ToLower(s)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type Route struct {
+	Match Match    `json:"match"`
+	Sinks []string `json:"sinks"`
+}
+
+type SinkConfig struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"` // http, webhook, file, archive, stdout
+	URL     string            `json:"url,omitempty"`
+	Path    string            `json:"path,omitempty"` // file path, or directory for archive
+	Headers map[string]string `json:"headers,omitempty"`
+	Format  string            `json:"format,omitempty"` // native (default) or ecs
+
+	QueueSize    int    `json:"queueSize,omitempty"`
+	MaxRetries   int    `json:"maxRetries,omitempty"`
+	RetryBackoff string `json:"retryBackoff,omitempty"`
+	Workers      int    `json:"workers,omitempty"` // http and webhook only
+	DropWhenFull bool   `json:"dropWhenFull,omitempty"`
+
+	// Archive settings: file name prefix, "hourly" or "daily" rotation, and
+	// how long finished archives are kept (e.g. "720h"; empty keeps all).
+	Prefix    string `json:"prefix,omitempty"`
+	Rotation  string `json:"rotation,omitempty"`
+	Retention string `json:"retention,omitempty"`
+}
+
+type Config struct {
+	Sinks  []SinkConfig `json:"sinks"`
+	Routes []Route      `json:"routes,omitempty"`
+	// Default lists the sinks for entries no route matches.
+	Default []string `json:"default"`
+	// Mirror lists sinks that receive every entry handed to Router.Mirror,
+	// independent of routes, e.g. a compliance archive. Their queues never
+	// drop entries.
+	Mirror []string `json:"mirror,omitempty"`
+}
+
+func LoadConfig(file string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// Router fans entries out to the queues of every matching route.
+type Router struct {
+	queues   map[string]*Queue
+	order    []string
+	routes   []Route
+	defaults []string
+	mirror   []string
+}
+
+// NewRouter builds the sinks in cfg. HTTP sinks share client.
+func NewRouter(cfg Config, client *http.Client) (*Router, error) {
+	r := &Router{queues: make(map[string]*Queue), routes: cfg.Routes, defaults: cfg.Default, mirror: cfg.Mirror}
+	for _, sc := range cfg.Sinks {
+		if sc.Name == "" {
+			r.Close()
+			return nil, errors.New("sink: missing name")
+		}
+		if _, dup := r.queues[sc.Name]; dup {
+			r.Close()
+			return nil, fmt.Errorf("sink %s: duplicate name", sc.Name)
+		}
+		s, err := build(sc, client)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("sink %s: %w", sc.Name, err)
+		}
+		if _, ok := s.(*HTTP); !ok && sc.Workers > 1 {
+			s.Close()
+			r.Close()
+			return nil, fmt.Errorf("sink %s: workers needs an http sink", sc.Name)
+		}
+		opts := QueueOptions{Size: sc.QueueSize, MaxRetries: sc.MaxRetries, Workers: sc.Workers, DropWhenFull: sc.DropWhenFull}
+		if sc.RetryBackoff != "" {
+			if opts.Backoff, err = time.ParseDuration(sc.RetryBackoff); err != nil {
+				s.Close()
+				r.Close()
+				return nil, fmt.Errorf("sink %s: %w", sc.Name, err)
+			}
+		}
+		r.queues[sc.Name] = NewQueue(sc.Name, s, opts)
+		r.order = append(r.order, sc.Name)
+	}
+	for _, rt := range cfg.Routes {
+		if err := r.checkNames(rt.Sinks); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	for _, names := range [][]string{cfg.Default, cfg.Mirror} {
+		if err := r.checkNames(names); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	// A mirror keeps a copy of everything, so it waits rather than drops.
+	for _, name := range cfg.Mirror {
+		if r.queues[name].opts.DropWhenFull {
+			r.Close()
+			return nil, fmt.Errorf("sink %s: a mirror sink cannot drop entries", name)
+		}
+	}
+	return r, nil
+}
+
+func (r *Router) checkNames(names []string) error {
+	for _, n := range names {
+		if _, ok := r.queues[n]; !ok {
+			return fmt.Errorf("sink: route references unknown sink %q", n)
+		}
+	}
+	return nil
+}
+
+func build(sc SinkConfig, client *http.Client) (Sink, error) {
+	if sc.Format != "" && sc.Format != FormatNative && sc.Format != FormatECS {
+		return nil, fmt.Errorf("unknown format %q", sc.Format)
+	}
+	switch sc.Type {
+	case "http", "webhook":
+		if sc.URL == "" {
+			return nil, errors.New("missing url")
+		}
+		return &HTTP{URL: sc.URL, Client: client, Headers: sc.Headers, Format: sc.Format}, nil
+	case "file":
+		if sc.Path == "" {
+			return nil, errors.New("missing path")
+		}
+		return NewFile(sc.Path, sc.Format)
+	case "archive":
+		if sc.Path == "" {
+			return nil, errors.New("missing path")
+		}
+		var retention time.Duration
+		if sc.Retention != "" {
+			var err error
+			if retention, err = time.ParseDuration(sc.Retention); err != nil {
+				return nil, err
+			}
+		}
+		return NewArchive(sc.Path, sc.Prefix, sc.Rotation, retention, sc.Format)
+	case "stdout":
+		return NewWriter(os.Stdout, sc.Format), nil
+	}
+	return nil, fmt.Errorf("unknown type %q", sc.Type)
+}
+
+// Route enqueues e on every sink selected by the matching routes, or on the
+// default sinks when none match.
+func (r *Router) Route(e model.LogEntry) {
+	var targets []string
+	for _, rt := range r.routes {
+		if rt.Match.matches(e) {
+			targets = append(targets, rt.Sinks...)
+		}
+	}
+	if len(targets) == 0 {
+		targets = r.defaults
+	}
+	r.enqueue(targets, e)
+}
+
+// Mirror enqueues e on the mirror sinks only.
+func (r *Router) Mirror(e model.LogEntry) {
+	r.enqueue(r.mirror, e)
+}
+
+func (r *Router) enqueue(targets []string, e model.LogEntry) {
+	seen := make(map[string]bool, len(targets))
+	for _, name := range targets {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		r.queues[name].Enqueue(e)
+	}
+}
+
+func (r *Router) Stats() map[string]Stats {
+	out := make(map[string]Stats, len(r.queues))
+	for name, q := range r.queues {
+		out[name] = q.Stats()
+	}
+	return out
+}
+
+// Close drains and closes every sink.
+func (r *Router) Close() error {
+	var errs []error
+	for _, name := range r.order {
+		errs = append(errs, r.queues[name].Close())
+	}
+	return errors.Join(errs...)
+}
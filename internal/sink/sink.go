@@ -0,0 +1,129 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"motadata/internal/ecs"
+	"motadata/internal/model"
+)
+
+// Sink is a destination for log entries. Send may be called from one
+// goroutine at a time, except for HTTP; queues provide concurrency and
+// retries.
+type Sink interface {
+	Send(e model.LogEntry) error
+	Close() error
+}
+
+const (
+	FormatNative = "native"
+	FormatECS    = "ecs"
+)
+
+func encode(e model.LogEntry, format string) any {
+	if format == FormatECS {
+		return ecs.FromEntry(e)
+	}
+	return e
+}
+
+// StatusError is returned for a response outside 2xx.
+type StatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d", e.URL, e.Code)
+}
+
+// Permanent reports whether resending cannot help: client errors such as
+// a malformed entry or an invalid tenant. A request timeout, a rejected key
+// (which may be mid-rotation) and a tenant over its quota are retried.
+func (e *StatusError) Permanent() bool {
+	switch e.Code {
+	case http.StatusRequestTimeout, http.StatusUnauthorized, http.StatusTooManyRequests:
+		return false
+	}
+	return e.Code >= 400 && e.Code < 500
+}
+
+// HTTP posts each entry as JSON, e.g. to log-server /ingest or a webhook.
+type HTTP struct {
+	URL     string
+	Client  *http.Client
+	Headers map[string]string
+	Format  string
+}
+
+func (h *HTTP) Send(e model.LogEntry) error {
+	b, err := json.Marshal(encode(e, h.Format))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.Tenant != "" {
+		req.Header.Set("X-Tenant-ID", e.Tenant)
+	}
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{URL: h.URL, Code: resp.StatusCode}
+	}
+	return nil
+}
+
+func (h *HTTP) Close() error {
+	return nil
+}
+
+// Writer encodes entries as NDJSON to w.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	enc    *json.Encoder
+	format string
+}
+
+func NewWriter(w io.Writer, format string) *Writer {
+	return &Writer{w: w, enc: json.NewEncoder(w), format: format}
+}
+
+func (w *Writer) Send(e model.LogEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(encode(e, w.format))
+}
+
+func (w *Writer) Close() error {
+	if c, ok := w.w.(io.Closer); ok && w.w != os.Stdout && w.w != os.Stderr {
+		return c.Close()
+	}
+	return nil
+}
+
+// NewFile appends NDJSON to path.
+func NewFile(path, format string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(f, format), nil
+}
@@ -0,0 +1,343 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"motadata/internal/model"
+)
+
+type memSink struct {
+	mu    sync.Mutex
+	fails int
+	got   []model.LogEntry
+}
+
+func (m *memSink) Send(e model.LogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fails > 0 {
+		m.fails--
+		return errors.New("unavailable")
+	}
+	m.got = append(m.got, e)
+	return nil
+}
+
+func (m *memSink) Close() error { return nil }
+
+func TestQueueRetries(t *testing.T) {
+	s := &memSink{fails: 2}
+	q := NewQueue("mem", s, QueueOptions{Size: 4, MaxRetries: 2, Backoff: time.Millisecond})
+	q.Enqueue(model.LogEntry{RawMessage: "a"})
+	q.Close()
+	if st := q.Stats(); st.Sent != 1 || st.Failed != 0 || len(s.got) != 1 {
+		t.Fatalf("expected delivery after retries, got %+v", st)
+	}
+
+	s = &memSink{fails: 10}
+	q = NewQueue("mem", s, QueueOptions{Size: 4, MaxRetries: 1, Backoff: time.Millisecond})
+	q.Enqueue(model.LogEntry{RawMessage: "a"})
+	q.Close()
+	if st := q.Stats(); st.Sent != 0 || st.Failed != 1 {
+		t.Fatalf("expected failure after retries, got %+v", st)
+	}
+}
+
+// blockSink holds every Send until release is closed.
+type blockSink struct {
+	memSink
+	release chan struct{}
+}
+
+func (b *blockSink) Send(e model.LogEntry) error {
+	<-b.release
+	return b.memSink.Send(e)
+}
+
+func TestQueueFullBlocksUnlessDropping(t *testing.T) {
+	s := &blockSink{release: make(chan struct{})}
+	q := NewQueue("slow", s, QueueOptions{Size: 1})
+	q.Enqueue(model.LogEntry{RawMessage: "a"}) // taken by the worker
+	q.Enqueue(model.LogEntry{RawMessage: "b"}) // buffered
+	done := make(chan bool)
+	go func() { done <- q.Enqueue(model.LogEntry{RawMessage: "c"}) }()
+	select {
+	case <-done:
+		t.Fatal("expected Enqueue to wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(s.release)
+	if !<-done {
+		t.Fatal("expected the entry to be accepted")
+	}
+	q.Close()
+	if st := q.Stats(); st.Sent != 3 || st.Dropped != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	s = &blockSink{release: make(chan struct{})}
+	q = NewQueue("best-effort", s, QueueOptions{Size: 1, DropWhenFull: true})
+	accepted := 0
+	for _, m := range []string{"a", "b", "c", "d"} {
+		if q.Enqueue(model.LogEntry{RawMessage: m}) {
+			accepted++
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(s.release)
+	q.Close()
+	if st := q.Stats(); accepted != 2 || st.Dropped != 2 {
+		t.Fatalf("expected 2 drops, accepted %d, stats %+v", accepted, st)
+	}
+}
+
+func TestQueueWorkersKeepTenantOrder(t *testing.T) {
+	s := &memSink{}
+	q := NewQueue("mem", s, QueueOptions{Size: 4000, Workers: 4})
+	for i := 0; i < 100; i++ {
+		for _, tenant := range []string{"a", "b", "c", "d", "e", "f"} {
+			q.Enqueue(model.LogEntry{Tenant: tenant, RawMessage: strconv.Itoa(i)})
+		}
+	}
+	q.Close()
+	next := map[string]int{}
+	for _, e := range s.got {
+		if e.RawMessage != strconv.Itoa(next[e.Tenant]) {
+			t.Fatalf("tenant %s: got %s, want %d", e.Tenant, e.RawMessage, next[e.Tenant])
+		}
+		next[e.Tenant]++
+	}
+	if len(s.got) != 600 {
+		t.Fatalf("expected 600 entries, got %d", len(s.got))
+	}
+}
+
+func TestQueueDoesNotRetryClientErrors(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get("X-Tenant-ID")
+		mu.Lock()
+		defer mu.Unlock()
+		calls[tenant]++
+		switch {
+		case tenant == "bad":
+			w.WriteHeader(http.StatusBadRequest)
+		case tenant == "full" && calls[tenant] == 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case tenant == "rotated" && calls[tenant] == 1:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	q := NewQueue("server", &HTTP{URL: srv.URL, Client: srv.Client()}, QueueOptions{MaxRetries: 3, Backoff: time.Millisecond, Workers: 2})
+	for _, tenant := range []string{"bad", "full", "rotated", "ok"} {
+		q.Enqueue(model.LogEntry{Tenant: tenant})
+	}
+	q.Close()
+	// A 400 is given up at once; 401 and 429 are retried and then sent.
+	if st := q.Stats(); st.Sent != 3 || st.Failed != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if calls["bad"] != 1 || calls["full"] != 2 || calls["rotated"] != 2 || calls["ok"] != 1 {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+}
+
+func TestRouterFanOut(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string][]map[string]any{}
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var doc map[string]any
+			json.NewDecoder(r.Body).Decode(&doc)
+			mu.Lock()
+			received[name] = append(received[name], doc)
+			mu.Unlock()
+		}
+	}
+	primary := httptest.NewServer(handler("primary"))
+	defer primary.Close()
+	siem := httptest.NewServer(handler("siem"))
+	defer siem.Close()
+	archive := filepath.Join(t.TempDir(), "alerts.ndjson")
+
+	yes := true
+	r, err := NewRouter(Config{
+		Sinks: []SinkConfig{
+			{Name: "primary", Type: "http", URL: primary.URL},
+			{Name: "siem", Type: "webhook", URL: siem.URL, Format: FormatECS},
+			{Name: "archive", Type: "file", Path: archive},
+		},
+		Routes: []Route{
+			{Match: Match{Categories: []string{"alert.*"}}, Sinks: []string{"siem", "archive"}},
+			{Match: Match{Blacklisted: &yes}, Sinks: []string{"primary", "siem"}},
+		},
+		Default: []string{"primary"},
+	}, primary.Client())
+	if err != nil {
+		t.Fatalf("router: %v", err)
+	}
+	r.Route(model.LogEntry{Username: "alice", EventCategory: "login.audit"})
+	r.Route(model.LogEntry{Username: "root", EventCategory: "login.audit", IsBlacklisted: true})
+	r.Route(model.LogEntry{Username: "bob", EventCategory: "alert.detection"})
+	if err := r.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if len(received["primary"]) != 2 || received["primary"][0]["username"] != "alice" {
+		t.Fatalf("unexpected primary deliveries: %+v", received["primary"])
+	}
+	if len(received["siem"]) != 2 || received["siem"][0]["user"].(map[string]any)["name"] != "root" {
+		t.Fatalf("unexpected siem deliveries: %+v", received["siem"])
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer f.Close()
+	lines := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+	}
+	if lines != 1 {
+		t.Fatalf("expected 1 archived alert, got %d", lines)
+	}
+	if st := r.Stats(); st["siem"].Sent != 2 || st["primary"].Sent != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestRouterMirrorDoesNotDrop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirror.ndjson")
+	r, err := NewRouter(Config{
+		Sinks:  []SinkConfig{{Name: "copy", Type: "file", Path: path, QueueSize: 1}},
+		Mirror: []string{"copy"},
+	}, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		r.Mirror(model.LogEntry{RawMessage: strconv.Itoa(i)})
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n != 200 || r.Stats()["copy"].Dropped != 0 {
+		t.Fatalf("expected every entry mirrored, got %d lines, stats %+v", n, r.Stats()["copy"])
+	}
+}
+
+func TestRouterConfigErrors(t *testing.T) {
+	for _, cfg := range []Config{
+		{Sinks: []SinkConfig{{Name: "a", Type: "ftp"}}},
+		{Sinks: []SinkConfig{{Name: "a", Type: "stdout"}}, Default: []string{"b"}},
+		{Sinks: []SinkConfig{{Name: "a", Type: "stdout"}, {Name: "a", Type: "stdout"}}},
+		{Sinks: []SinkConfig{{Name: "a", Type: "http"}}},
+		{Sinks: []SinkConfig{{Name: "a", Type: "archive", Path: t.TempDir(), Rotation: "weekly"}}},
+		{Sinks: []SinkConfig{{Name: "a", Type: "stdout"}}, Mirror: []string{"b"}},
+		{Sinks: []SinkConfig{{Name: "a", Type: "stdout", DropWhenFull: true}}, Mirror: []string{"a"}},
+	} {
+		if _, err := NewRouter(cfg, http.DefaultClient); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func readArchive(t *testing.T, path string) []model.LogEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []model.LogEntry
+	dec := json.NewDecoder(gz)
+	for dec.More() {
+		var e model.LogEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func TestArchiveRotationAndRetention(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "edge-2020-01-01T00.ndjson.gz")
+	os.WriteFile(old, nil, 0o644)
+	os.Chtimes(old, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour))
+	// Left behind by a crashed process: two flushed entries and a third cut
+	// off mid-stream, without the gzip trailer.
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`{"raw.message":"a"}` + "\n" + `{"raw.message":"b"}` + "\n"))
+	gz.Flush()
+	gz.Write([]byte(`{"raw.message":"c"`))
+	gz.Flush()
+	os.WriteFile(filepath.Join(dir, "edge-2024-05-01T09.ndjson.gz.partial"), buf.Bytes()[:buf.Len()-3], 0o644)
+	os.WriteFile(filepath.Join(dir, "edge-2024-05-01T08.ndjson.gz.partial"), []byte("garbage"), 0o644)
+
+	a, err := NewArchive(dir, "edge", RotateHourly, 24*time.Hour, FormatNative)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatal("expected expired archive to be removed")
+	}
+	if got := readArchive(t, filepath.Join(dir, "edge-2024-05-01T09.ndjson.gz")); len(got) != 2 || got[1].RawMessage != "b" {
+		t.Fatalf("expected the flushed entries to be recovered into a complete archive, got %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "edge-2024-05-01T08.ndjson.gz")); !os.IsNotExist(err) {
+		t.Fatal("expected an unreadable partial file not to be finalized")
+	}
+
+	// The run goroutine reads a.now under the lock.
+	setNow := func(now time.Time) {
+		a.mu.Lock()
+		a.now = func() time.Time { return now }
+		a.mu.Unlock()
+	}
+	now := time.Date(2024, 5, 1, 10, 59, 0, 0, time.UTC)
+	setNow(now)
+	a.Send(model.LogEntry{RawMessage: "one"})
+	a.Send(model.LogEntry{RawMessage: "two"})
+	if _, err := os.Stat(filepath.Join(dir, "edge-2024-05-01T10.ndjson.gz.partial")); err != nil {
+		t.Fatal("expected open file to carry the partial suffix")
+	}
+	setNow(now.Add(2 * time.Minute))
+	a.Send(model.LogEntry{RawMessage: "three"})
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readArchive(t, filepath.Join(dir, "edge-2024-05-01T10.ndjson.gz")); len(got) != 2 || got[1].RawMessage != "two" {
+		t.Fatalf("unexpected first hour: %+v", got)
+	}
+	if got := readArchive(t, filepath.Join(dir, "edge-2024-05-01T11.ndjson.gz")); len(got) != 1 || got[0].RawMessage != "three" {
+		t.Fatalf("unexpected second hour: %+v", got)
+	}
+	if partials, _ := filepath.Glob(filepath.Join(dir, "*.partial")); len(partials) != 0 {
+		t.Fatalf("expected no partial files after close, got %v", partials)
+	}
+}
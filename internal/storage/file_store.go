@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"motadata/internal/model"
 )
@@ -13,6 +14,7 @@ import (
 
 type FileBackedStore struct {
 	mem  *InMemoryStore
+	path string
 	file *os.File
 	enc  *json.Encoder
 	fMu  sync.Mutex
@@ -29,6 +31,7 @@ func NewFileBackedStore(filePath string) (*FileBackedStore, error) {
 	}
 	s := &FileBackedStore{
 		mem:  NewInMemoryStore(),
+		path: filePath,
 		file: f,
 		enc:  json.NewEncoder(f),
 	}
@@ -43,17 +46,16 @@ func NewFileBackedStore(filePath string) (*FileBackedStore, error) {
 }
 
 func (s *FileBackedStore) Ingest(entry model.LogEntry) error {
+	// The lock also covers the memory copy so that Prune, which rewrites
+	// the file from it, cannot miss an entry.
 	s.fMu.Lock()
+	defer s.fMu.Unlock()
 	if err := s.enc.Encode(entry); err != nil {
-		s.fMu.Unlock()
 		return err
 	}
 	if err := s.file.Sync(); err != nil {
-		s.fMu.Unlock()
 		return err
 	}
-	s.fMu.Unlock()
-	
 	return s.mem.Ingest(entry)
 }
 
@@ -65,6 +67,44 @@ func (s *FileBackedStore) Metrics() Metrics {
 	return s.mem.Metrics()
 }
 
+// Prune removes entries older than before, rewriting the file without them.
+func (s *FileBackedStore) Prune(before time.Time) (int, error) {
+	s.fMu.Lock()
+	defer s.fMu.Unlock()
+	removed, _ := s.mem.Prune(before)
+	if removed == 0 {
+		return 0, nil
+	}
+	kept, _ := s.mem.Query(QueryFilter{})
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return removed, err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range kept {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return removed, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return removed, err
+	}
+	f.Close()
+	if err := os.Rename(tmp, s.path); err != nil {
+		return removed, err
+	}
+	nf, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return removed, err
+	}
+	s.file.Close()
+	s.file, s.enc = nf, json.NewEncoder(nf)
+	return removed, nil
+}
+
 func dirOf(path string) string {
 	for i := len(path) - 1; i >= 0; i-- {
 		if path[i] == '/' {
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"errors"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,14 +19,69 @@ type LogStore interface {
 }
 
 type QueryFilter struct {
+	Category      string
 	Service       string
 	Level         string
 	Username      string
 	IsBlacklisted *bool
+	Attributes    map[string]string // attr.<name>=value
 	Limit         int
 	SortBy        string // e.g. "timestamp"
 }
 
+// ParseQueryFilter builds a filter from /logs style query parameters.
+func ParseQueryFilter(q url.Values) QueryFilter {
+	filter := QueryFilter{}
+	filter.Category = q.Get("category")
+	filter.Service = q.Get("service")
+	filter.Level = q.Get("level")
+	filter.Username = q.Get("username")
+	if v := q.Get("is.blacklisted"); v != "" {
+		b := strings.EqualFold(v, "true") || v == "1"
+		filter.IsBlacklisted = &b
+	}
+	for k, vs := range q {
+		if name, ok := strings.CutPrefix(k, "attr."); ok && name != "" && len(vs) > 0 {
+			if filter.Attributes == nil {
+				filter.Attributes = make(map[string]string)
+			}
+			filter.Attributes[name] = vs[0]
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			filter.Limit = n
+		}
+	}
+	filter.SortBy = q.Get("sort")
+	return filter
+}
+
+func (f QueryFilter) Matches(e model.LogEntry) bool {
+	if f.Category != "" && !strings.EqualFold(e.EventCategory, f.Category) {
+		return false
+	}
+	if f.Service != "" && !strings.EqualFold(e.Service, f.Service) {
+		return false
+	}
+	if f.Level != "" && !strings.EqualFold(e.Severity, f.Level) {
+		return false
+	}
+	if f.Username != "" && !strings.EqualFold(e.Username, f.Username) {
+		return false
+	}
+	if f.IsBlacklisted != nil && e.IsBlacklisted != *f.IsBlacklisted {
+		return false
+	}
+	for k, v := range f.Attributes {
+		got, ok := e.Attributes[k]
+		if !ok || !strings.EqualFold(got, v) {
+			return false
+		}
+	}
+	return true
+}
+
 type Metrics struct {
 	Total      int
 	ByCategory map[string]int
@@ -66,16 +123,7 @@ func (s *InMemoryStore) Query(filter QueryFilter) ([]model.LogEntry, error) {
 	defer s.mu.RUnlock()
 	results := make([]model.LogEntry, 0)
 	for _, e := range s.logs {
-		if filter.Service != "" && !strings.EqualFold(e.Service, filter.Service) {
-			continue
-		}
-		if filter.Level != "" && !strings.EqualFold(e.Severity, filter.Level) {
-			continue
-		}
-		if filter.Username != "" && !strings.EqualFold(e.Username, filter.Username) {
-			continue
-		}
-		if filter.IsBlacklisted != nil && e.IsBlacklisted != *filter.IsBlacklisted {
+		if !filter.Matches(e) {
 			continue
 		}
 		results = append(results, e)
@@ -97,6 +145,31 @@ func (s *InMemoryStore) Query(filter QueryFilter) ([]model.LogEntry, error) {
 	return results, nil
 }
 
+// Prune removes entries older than before and returns how many it removed.
+// Metrics count only the entries that remain.
+func (s *InMemoryStore) Prune(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.logs[:0]
+	for _, e := range s.logs {
+		if !e.Timestamp.Before(before) {
+			kept = append(kept, e)
+			continue
+		}
+		s.total--
+		if e.EventCategory != "" {
+			s.byCat[strings.ToLower(e.EventCategory)]--
+		}
+		if e.Severity != "" {
+			s.bySev[strings.ToUpper(e.Severity)]--
+		}
+	}
+	removed := len(s.logs) - len(kept)
+	clear(s.logs[len(kept):])
+	s.logs = kept
+	return removed, nil
+}
+
 func (s *InMemoryStore) Metrics() Metrics {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -21,6 +24,14 @@ func TestIngestAndQuery(t *testing.T) {
 		t.Fatalf("unexpected result: %+v", res)
 	}
 
+	res, err = store.Query(QueryFilter{Category: "LOGOUT.audit"})
+	if err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	if len(res) != 1 || res[0].Username != "bob" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
 	v := true
 	res, err = store.Query(QueryFilter{IsBlacklisted: &v})
 	if err != nil {
@@ -53,3 +64,93 @@ func TestSortingAndLimit(t *testing.T) {
 		t.Fatalf("expected ascending timestamps")
 	}
 }
+
+func TestAttributeFilterAndFileRoundTrip(t *testing.T) {
+	path := t.TempDir() + "/logs.jsonl"
+	store, err := NewFileBackedStore(path)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	now := time.Now().UTC()
+	_ = store.Ingest(model.LogEntry{Timestamp: now, Username: "bob", Attributes: model.Attributes{"src_ip": "10.0.0.13", "pid": "42"}})
+	_ = store.Ingest(model.LogEntry{Timestamp: now, Username: "alice", Attributes: model.Attributes{"src_ip": "10.0.0.14"}})
+	store.file.Close()
+
+	reopened, err := NewFileBackedStore(path)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	res, err := reopened.Query(QueryFilter{Attributes: map[string]string{"src_ip": "10.0.0.13"}})
+	if err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	if len(res) != 1 || res[0].Username != "bob" || res[0].Attr("pid") != "42" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	res, _ = reopened.Query(QueryFilter{Attributes: map[string]string{"missing": "x"}})
+	if len(res) != 0 {
+		t.Fatalf("expected no results for unknown attribute, got %+v", res)
+	}
+}
+
+func TestTenantIsolationQuotaAndRetention(t *testing.T) {
+	path := t.TempDir() + "/logs.jsonl"
+	ts, err := NewTenantStore(FilePartitions(path), TenantConfig{
+		Tenants: map[string]Limits{"team-a": {MaxEntries: 2, Retention: "1h"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	if err := ts.Ingest("team-a", model.LogEntry{Timestamp: now.Add(-2 * time.Hour), Username: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Ingest("team-a", model.LogEntry{Timestamp: now, Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Ingest("team-a", model.LogEntry{Timestamp: now, Username: "carol"}); err != ErrQuotaExceeded {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if err := ts.Ingest(DefaultTenant, model.LogEntry{Timestamp: now, Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Ingest("../etc", model.LogEntry{}); !errors.Is(err, ErrInvalidTenant) {
+		t.Fatalf("expected invalid tenant error, got %v", err)
+	}
+
+	// Reading an unknown tenant touches nothing on disk.
+	if res, err := ts.Query("nobody", QueryFilter{}); err != nil || len(res) != 0 {
+		t.Fatalf("unknown tenant: %+v, %v", res, err)
+	}
+	if m, err := ts.Metrics("nobody"); err != nil || m.Total != 0 {
+		t.Fatalf("unknown tenant metrics: %+v, %v", m, err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "logs.nobody.jsonl")); err == nil || len(ts.Tenants()) != 2 {
+		t.Fatalf("expected no partition for a read of an unknown tenant, got %v", ts.Tenants())
+	}
+
+	res, _ := ts.Query(DefaultTenant, QueryFilter{})
+	if len(res) != 1 || res[0].Username != "bob" || res[0].Tenant != DefaultTenant {
+		t.Fatalf("default tenant sees %+v", res)
+	}
+	if m, _ := ts.Metrics("team-a"); m.Total != 2 {
+		t.Fatalf("expected 2 entries for team-a, got %d", m.Total)
+	}
+
+	// Retention frees quota and is persisted.
+	ts.Prune(now)
+	if err := ts.Ingest("team-a", model.LogEntry{Timestamp: now, Username: "carol"}); err != nil {
+		t.Fatalf("expected room after pruning, got %v", err)
+	}
+	reopened, err := NewTenantStore(FilePartitions(path), TenantConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Tenants(); len(got) != 2 || got[0] != DefaultTenant || got[1] != "team-a" {
+		t.Fatalf("unexpected tenants %v", got)
+	}
+	res, _ = reopened.Query("team-a", QueryFilter{SortBy: "timestamp"})
+	if len(res) != 2 || res[0].Username != "alice" || res[1].Username != "carol" {
+		t.Fatalf("unexpected team-a entries %+v", res)
+	}
+}
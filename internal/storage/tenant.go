@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"motadata/internal/model"
+)
+
+// DefaultTenant owns entries that name no tenant.
+const DefaultTenant = "default"
+
+var (
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+	ErrInvalidTenant = errors.New("invalid tenant id")
+
+	// Tenant IDs end up in file names.
+	reTenant = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+)
+
+func ValidTenant(id string) bool {
+	return reTenant.MatchString(id)
+}
+
+// Limits bound the data kept for a tenant. Zero values mean unlimited.
+type Limits struct {
+	MaxEntries int    `json:"maxEntries,omitempty"`
+	Retention  string `json:"retention,omitempty"` // e.g. "720h"
+}
+
+type TenantConfig struct {
+	Default Limits            `json:"default"`
+	Tenants map[string]Limits `json:"tenants,omitempty"`
+}
+
+func LoadTenantConfig(file string) (TenantConfig, error) {
+	var cfg TenantConfig
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// Partitions opens the separate store of each tenant.
+type Partitions interface {
+	Open(tenant string) (LogStore, error)
+	// Existing lists tenants that already have data.
+	Existing() ([]string, error)
+}
+
+type memoryPartitions struct{}
+
+// MemoryPartitions keeps every tenant in its own InMemoryStore.
+func MemoryPartitions() Partitions { return memoryPartitions{} }
+
+func (memoryPartitions) Open(string) (LogStore, error) { return NewInMemoryStore(), nil }
+func (memoryPartitions) Existing() ([]string, error)   { return nil, nil }
+
+type filePartitions struct{ path string }
+
+// FilePartitions keeps the default tenant in path, as before tenants
+// existed, and tenant t in <dir>/<name>.<t><ext> next to it.
+func FilePartitions(path string) Partitions { return filePartitions{path} }
+
+func (p filePartitions) file(tenant string) string {
+	if tenant == DefaultTenant {
+		return p.path
+	}
+	ext := filepath.Ext(p.path)
+	return strings.TrimSuffix(p.path, ext) + "." + tenant + ext
+}
+
+func (p filePartitions) Open(tenant string) (LogStore, error) {
+	return NewFileBackedStore(p.file(tenant))
+}
+
+func (p filePartitions) Existing() ([]string, error) {
+	ext := filepath.Ext(p.path)
+	prefix := strings.TrimSuffix(p.path, ext) + "."
+	names, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	if _, err := os.Stat(p.path); err == nil {
+		out = append(out, DefaultTenant)
+	}
+	for _, n := range names {
+		if t := strings.TrimSuffix(strings.TrimPrefix(n, prefix), ext); ValidTenant(t) && t != DefaultTenant {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// Pruner is implemented by stores that can drop old entries.
+type Pruner interface {
+	Prune(before time.Time) (int, error)
+}
+
+type partition struct {
+	store     LogStore
+	count     int
+	limits    Limits
+	retention time.Duration
+}
+
+// TenantStore routes each tenant to its own store, so queries and metrics
+// never see another tenant's entries, and applies the tenant's limits.
+type TenantStore struct {
+	parts  Partitions
+	limits TenantConfig
+
+	mu      sync.Mutex
+	tenants map[string]*partition
+}
+
+func NewTenantStore(parts Partitions, cfg TenantConfig) (*TenantStore, error) {
+	for name, l := range cfg.Tenants {
+		if !ValidTenant(name) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTenant, name)
+		}
+		if _, err := parseRetention(l.Retention); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", name, err)
+		}
+	}
+	if _, err := parseRetention(cfg.Default.Retention); err != nil {
+		return nil, fmt.Errorf("default limits: %w", err)
+	}
+	t := &TenantStore{parts: parts, limits: cfg, tenants: make(map[string]*partition)}
+	existing, err := parts.Existing()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range existing {
+		if _, err := t.partition(name, true); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func parseRetention(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("retention: %w", err)
+	}
+	return d, nil
+}
+
+// partition returns the tenant's partition. Only ingest creates one: a read
+// of a tenant without data returns nil rather than opening files for
+// whatever tenant a caller names.
+func (t *TenantStore) partition(tenant string, create bool) (*partition, error) {
+	if !ValidTenant(tenant) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTenant, tenant)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.tenants[tenant]; ok || !create {
+		return p, nil
+	}
+	store, err := t.parts.Open(tenant)
+	if err != nil {
+		return nil, err
+	}
+	limits, ok := t.limits.Tenants[tenant]
+	if !ok {
+		limits = t.limits.Default
+	}
+	retention, _ := parseRetention(limits.Retention)
+	p := &partition{store: store, count: store.Metrics().Total, limits: limits, retention: retention}
+	t.tenants[tenant] = p
+	return p, nil
+}
+
+// Ingest stores entry for tenant, or returns ErrQuotaExceeded when the
+// tenant holds its maximum number of entries.
+func (t *TenantStore) Ingest(tenant string, entry model.LogEntry) error {
+	p, err := t.partition(tenant, true)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if p.limits.MaxEntries > 0 && p.count >= p.limits.MaxEntries {
+		t.mu.Unlock()
+		return ErrQuotaExceeded
+	}
+	p.count++
+	t.mu.Unlock()
+	entry.Tenant = tenant
+	if err := p.store.Ingest(entry); err != nil {
+		t.mu.Lock()
+		p.count--
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (t *TenantStore) Query(tenant string, filter QueryFilter) ([]model.LogEntry, error) {
+	p, err := t.partition(tenant, false)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return []model.LogEntry{}, nil
+	}
+	return p.store.Query(filter)
+}
+
+func (t *TenantStore) Metrics(tenant string) (Metrics, error) {
+	p, err := t.partition(tenant, false)
+	if err != nil {
+		return Metrics{}, err
+	}
+	if p == nil {
+		return NewInMemoryStore().Metrics(), nil
+	}
+	return p.store.Metrics(), nil
+}
+
+// Tenants lists the tenants with a partition, sorted.
+func (t *TenantStore) Tenants() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]string, 0, len(t.tenants))
+	for name := range t.tenants {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Prune applies each tenant's retention as of now.
+func (t *TenantStore) Prune(now time.Time) {
+	for _, name := range t.Tenants() {
+		p, _ := t.partition(name, false)
+		pr, ok := p.store.(Pruner)
+		if p.retention <= 0 || !ok {
+			continue
+		}
+		n, err := pr.Prune(now.Add(-p.retention))
+		if err != nil {
+			log.Printf("tenant %s: prune: %v", name, err)
+		}
+		if n > 0 {
+			t.mu.Lock()
+			p.count -= n
+			t.mu.Unlock()
+			log.Printf("tenant %s: removed %d entries past retention", name, n)
+		}
+	}
+}
+
+// RunRetention prunes every interval until stop is closed.
+func (t *TenantStore) RunRetention(interval time.Duration, stop <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		t.Prune(time.Now())
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
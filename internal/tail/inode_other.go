@@ -0,0 +1,11 @@
+//go:build !unix
+
+package tail
+
+import "os"
+
+// fileKey falls back to the path where inodes are unavailable; a renamed
+// file is then only recognised by os.SameFile at its old path.
+func fileKey(path string, fi os.FileInfo) string {
+	return path
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package tail
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileKey identifies the file behind path by device and inode, so that it
+// is recognised under a new name after a rename.
+func fileKey(path string, fi os.FileInfo) string {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+	}
+	return path
+}
@@ -0,0 +1,495 @@
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"motadata/internal/multiline"
+)
+
+// Source configures a set of files read the same way.
+type Source struct {
+	Paths     []string         `json:"paths"` // file names or globs
+	Source    string           `json:"source"`
+	Category  string           `json:"category"`
+	Hostname  string           `json:"hostname,omitempty"`
+	Format    string           `json:"format,omitempty"` // "" or "raw" for text lines, "json" for client payloads
+	Multiline multiline.Config `json:"multiline,omitempty"`
+
+	// Hold is set by consumers that handle records after emit returns,
+	// such as by grouping them: records of the source then carry Done,
+	// and offsets only advance past records that are done.
+	Hold bool `json:"-"`
+}
+
+type Config struct {
+	Sources []Source `json:"sources"`
+	// Checkpoint is the file read offsets are saved to; without it every
+	// start reads files from the beginning.
+	Checkpoint   string `json:"checkpoint,omitempty"`
+	PollInterval string `json:"pollInterval,omitempty"` // default 1s
+	// RotateGrace is how long a file renamed away or deleted is still read
+	// after its last write, for writers that have not reopened the log
+	// yet. Default 5s.
+	RotateGrace string `json:"rotateGrace,omitempty"`
+}
+
+func LoadConfig(file string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// Record is one line, or one multi-line record, read from Path.
+type Record struct {
+	Source *Source
+	Path   string
+	Text   string
+	Done   func() // set for sources with Hold; call once when handled
+}
+
+// Checkpoint is the saved read position of a file. Checkpoints are keyed
+// by device and inode, so the offset follows a file that is renamed.
+type Checkpoint struct {
+	Path   string `json:"path,omitempty"` // name the file was opened under
+	Offset int64  `json:"offset"`
+}
+
+// Tailer polls the configured files and emits their lines. Files are
+// tracked by device and inode: a file renamed to another matching name is
+// read on where it was, and one renamed away (logrotate's default) or
+// deleted keeps being read until it has been idle for RotateGrace, while
+// the new file at the path is read from the start. A file truncated in
+// place (copytruncate) is read again from the start. Offsets only advance
+// past lines that were emitted, so a restart resumes without losing lines;
+// a crash between emitting and saving can repeat the last few.
+type Tailer struct {
+	sources  []Source
+	interval time.Duration
+	grace    time.Duration
+	cpPath   string
+	emit     func(Record)
+	now      func() time.Time
+
+	mu          sync.Mutex
+	checkpoints map[string]Checkpoint // by file key
+	files       map[string]*file      // by file key
+	saved       map[string]Checkpoint
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type file struct {
+	path string
+	src  *Source
+	f    *os.File
+	r    *bufio.Reader
+	pos  int64  // bytes consumed from f
+	part []byte // incomplete last line
+	agg  *multiline.Aggregator
+	idle time.Time // while gone from the globs, when it was last read from
+
+	mu        sync.Mutex
+	ends      []int64 // end offsets of lines handed to agg
+	held      []*held // records of a Hold source not done yet, oldest first
+	committed int64
+}
+
+type held struct {
+	end  int64
+	done bool
+}
+
+func New(cfg Config, emit func(Record)) (*Tailer, error) {
+	t := &Tailer{
+		sources:     cfg.Sources,
+		interval:    time.Second,
+		grace:       5 * time.Second,
+		cpPath:      cfg.Checkpoint,
+		emit:        emit,
+		now:         time.Now,
+		checkpoints: make(map[string]Checkpoint),
+		files:       make(map[string]*file),
+		saved:       make(map[string]Checkpoint),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if cfg.PollInterval != "" {
+		d, err := time.ParseDuration(cfg.PollInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("tail: invalid pollInterval %q", cfg.PollInterval)
+		}
+		t.interval = d
+	}
+	if cfg.RotateGrace != "" {
+		d, err := time.ParseDuration(cfg.RotateGrace)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("tail: invalid rotateGrace %q", cfg.RotateGrace)
+		}
+		t.grace = d
+	}
+	for i, s := range cfg.Sources {
+		if len(s.Paths) == 0 {
+			return nil, fmt.Errorf("tail: source %d has no paths", i)
+		}
+		for _, p := range s.Paths {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("tail: %q: %w", p, err)
+			}
+		}
+		if _, err := multiline.New(s.Multiline, nil); err != nil {
+			return nil, fmt.Errorf("tail: %w", err)
+		}
+	}
+	if t.cpPath != "" {
+		b, err := os.ReadFile(t.cpPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &t.checkpoints); err != nil {
+				return nil, fmt.Errorf("%s: %w", t.cpPath, err)
+			}
+		}
+	}
+	return t, nil
+}
+
+// Sources returns the configured sources; records point at these.
+func (t *Tailer) Sources() []*Source {
+	out := make([]*Source, len(t.sources))
+	for i := range t.sources {
+		out[i] = &t.sources[i]
+	}
+	return out
+}
+
+// Start polls until Close is called.
+func (t *Tailer) Start() {
+	t.started = true
+	go func() {
+		defer close(t.done)
+		tk := time.NewTicker(t.interval)
+		defer tk.Stop()
+		for {
+			t.Poll()
+			select {
+			case <-t.stop:
+				return
+			case <-tk.C:
+			}
+		}
+	}()
+}
+
+// Poll discovers new files, reads what was appended and saves checkpoints.
+func (t *Tailer) Poll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	found := make(map[string]bool)
+	for i := range t.sources {
+		src := &t.sources[i]
+		for _, pattern := range src.Paths {
+			matches, _ := filepath.Glob(pattern)
+			for _, p := range matches {
+				info, err := os.Stat(p)
+				if err != nil {
+					continue
+				}
+				key := fileKey(p, info)
+				if found[key] {
+					continue
+				}
+				found[key] = true
+				if f, ok := t.files[key]; ok {
+					if sameFile(f.f, info) {
+						if info.Size() < f.pos {
+							log.Printf("tail %s: truncated, reading from start", p)
+							f.reset()
+						}
+						continue
+					}
+					// Replaced where files have no inode to follow.
+					t.close(key, f)
+				}
+				if f, err := t.open(p, key, src); err != nil {
+					log.Printf("tail: %v", err)
+				} else {
+					t.files[key] = f
+				}
+			}
+		}
+	}
+	// Files gone from the globs go first: their lines were written before
+	// those of the files replacing them.
+	keys := make([]string, 0, len(t.files))
+	for key := range t.files {
+		keys = append(keys, key)
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return !found[keys[i]] && found[keys[j]] })
+	for _, key := range keys {
+		f := t.files[key]
+		n, err := f.read(t.emit)
+		if err != nil {
+			log.Printf("tail %s: %v", f.path, err)
+		}
+		switch {
+		case found[key]:
+			f.idle = time.Time{}
+		case f.idle.IsZero() || n > 0:
+			f.idle = now
+		}
+		if !found[key] && now.Sub(f.idle) >= t.grace {
+			t.close(key, f)
+		}
+	}
+	// Offsets of files no longer found are dropped, so that a new file
+	// reusing the inode is read from the start.
+	for key := range t.checkpoints {
+		if _, ok := t.files[key]; !ok && !found[key] {
+			delete(t.checkpoints, key)
+		}
+	}
+	for key, f := range t.files {
+		t.checkpoints[key] = Checkpoint{Path: f.path, Offset: f.offset()}
+	}
+	if err := t.saveLocked(); err != nil {
+		log.Printf("tail checkpoint: %v", err)
+	}
+}
+
+// close finishes the last line and pending record of a file that is no
+// longer read.
+func (t *Tailer) close(key string, f *file) {
+	f.finish(t.emit)
+	f.f.Close()
+	delete(t.files, key)
+	delete(t.checkpoints, key)
+}
+
+func sameFile(f *os.File, info os.FileInfo) bool {
+	fi, err := f.Stat()
+	return err == nil && os.SameFile(fi, info)
+}
+
+func (t *Tailer) open(path, key string, src *Source) (*file, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	f := &file{path: path, src: src, f: fh}
+	if cp, ok := t.checkpoints[key]; ok && cp.Offset <= info.Size() {
+		if _, err := fh.Seek(cp.Offset, io.SeekStart); err != nil {
+			fh.Close()
+			return nil, err
+		}
+		f.pos, f.committed = cp.Offset, cp.Offset
+	}
+	f.r = bufio.NewReader(fh)
+	if src.Multiline.Enabled() {
+		f.agg, _ = multiline.New(src.Multiline, func(rec string) {
+			f.emit(t.emit, rec, f.take(strings.Count(rec, "\n")+1))
+		})
+	}
+	return f, nil
+}
+
+// read consumes complete lines up to the current end of the file and
+// returns the number of bytes read.
+func (f *file) read(emit func(Record)) (int64, error) {
+	start := f.pos
+	for {
+		b, err := f.r.ReadBytes('\n')
+		f.pos += int64(len(b))
+		if err != nil {
+			f.part = append(f.part, b...)
+			if err == io.EOF {
+				err = nil
+			}
+			return f.pos - start, err
+		}
+		if len(f.part) > 0 {
+			b = append(f.part, b...)
+			f.part = nil
+		}
+		f.line(string(bytes.TrimRight(b, "\r\n")), emit)
+	}
+}
+
+func (f *file) line(text string, emit func(Record)) {
+	if f.agg == nil {
+		f.emit(emit, text, f.pos)
+		return
+	}
+	f.mu.Lock()
+	f.ends = append(f.ends, f.pos)
+	f.mu.Unlock()
+	f.agg.Add(text)
+}
+
+// take removes the n oldest lines given to agg and returns the offset
+// they end at.
+func (f *file) take(n int) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n > len(f.ends) {
+		n = len(f.ends)
+	}
+	if n == 0 {
+		return f.committed
+	}
+	end := f.ends[n-1]
+	f.ends = f.ends[n:]
+	return end
+}
+
+// emit hands a record ending at offset end to the consumer. The offset is
+// committed at once, or for a Hold source when the record and all before
+// it are done.
+func (f *file) emit(emit func(Record), text string, end int64) {
+	r := Record{Source: f.src, Path: f.path, Text: text}
+	if !f.src.Hold {
+		emit(r)
+		f.mu.Lock()
+		f.committed = end
+		f.mu.Unlock()
+		return
+	}
+	h := &held{end: end}
+	f.mu.Lock()
+	f.held = append(f.held, h)
+	f.mu.Unlock()
+	var once sync.Once
+	r.Done = func() { once.Do(func() { f.done(h) }) }
+	emit(r)
+}
+
+func (f *file) done(h *held) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h.done = true
+	for len(f.held) > 0 && f.held[0].done {
+		f.committed = f.held[0].end
+		f.held = f.held[1:]
+	}
+}
+
+func (f *file) offset() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.committed
+}
+
+// finish emits an unterminated last line and any pending record.
+func (f *file) finish(emit func(Record)) {
+	if len(f.part) > 0 {
+		text := string(bytes.TrimRight(f.part, "\r\n"))
+		f.part = nil
+		f.line(text, emit)
+	}
+	if f.agg != nil {
+		f.agg.Flush()
+	}
+}
+
+func (f *file) reset() {
+	if f.agg != nil {
+		f.agg.Flush()
+	}
+	f.f.Seek(0, io.SeekStart)
+	f.r.Reset(f.f)
+	f.part = nil
+	f.pos = 0
+	f.mu.Lock()
+	f.ends, f.held, f.committed = nil, nil, 0
+	f.mu.Unlock()
+}
+
+func (t *Tailer) saveLocked() error {
+	if t.cpPath == "" || equal(t.checkpoints, t.saved) {
+		return nil
+	}
+	b, err := json.MarshalIndent(t.checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.cpPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, t.cpPath); err != nil {
+		return err
+	}
+	t.saved = make(map[string]Checkpoint, len(t.checkpoints))
+	for k, v := range t.checkpoints {
+		t.saved[k] = v
+	}
+	return nil
+}
+
+func equal(a, b map[string]Checkpoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Close stops polling, flushes pending multi-line records and saves the
+// final offsets. Unterminated last lines stay unread until the next start.
+func (t *Tailer) Close() error {
+	close(t.stop)
+	if t.started {
+		<-t.done
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, f := range t.files {
+		if f.agg != nil {
+			f.agg.Flush()
+		}
+		t.checkpoints[key] = Checkpoint{Path: f.path, Offset: f.offset()}
+		f.f.Close()
+	}
+	return t.saveLocked()
+}
+
+// Save writes the offsets of the files read. Close saves them too; with
+// Hold sources, Save again once the records still held at Close are done.
+func (t *Tailer) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, f := range t.files {
+		t.checkpoints[key] = Checkpoint{Path: f.path, Offset: f.offset()}
+	}
+	return t.saveLocked()
+}
@@ -0,0 +1,256 @@
+package tail
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"motadata/internal/multiline"
+)
+
+type collect struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *collect) emit(r Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, r.Text)
+}
+
+func (c *collect) take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := c.lines
+	c.lines = nil
+	return out
+}
+
+func appendFile(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(s)
+	f.Close()
+}
+
+func expect(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestTailRotationAndCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "auth.log")
+	cfg := Config{
+		Sources:    []Source{{Paths: []string{filepath.Join(dir, "*.log")}, Source: "linux", Category: "login.audit"}},
+		Checkpoint: filepath.Join(dir, "offsets.json"),
+	}
+	var c collect
+	tl, err := New(cfg, c.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tl.now = func() time.Time { return now }
+
+	appendFile(t, logPath, "one\ntwo\nthr")
+	tl.Poll()
+	expect(t, c.take(), "one", "two")
+	appendFile(t, logPath, "ee\n")
+	tl.Poll()
+	expect(t, c.take(), "three")
+
+	// logrotate: rename, then a new file appears at the same path. The
+	// renamed file is read first, and lines the writer still adds to it
+	// are read until it has been idle for the grace period.
+	appendFile(t, logPath, "four\n")
+	os.Rename(logPath, logPath+".1")
+	appendFile(t, logPath+".1", "five\n")
+	appendFile(t, logPath, "six\n")
+	tl.Poll()
+	expect(t, c.take(), "four", "five", "six")
+	now = now.Add(4 * time.Second)
+	appendFile(t, logPath+".1", "late")
+	tl.Poll()
+	now = now.Add(4 * time.Second)
+	tl.Poll()
+	expect(t, c.take())
+	now = now.Add(time.Second)
+	tl.Poll()
+	expect(t, c.take(), "late")
+	appendFile(t, logPath+".1", "\nclosed\n")
+	tl.Poll()
+	expect(t, c.take())
+
+	// copytruncate: same file cut back to zero.
+	os.Truncate(logPath, 0)
+	tl.Poll()
+	appendFile(t, logPath, "six\n")
+	tl.Poll()
+	expect(t, c.take(), "six")
+
+	// A second file matching the glob.
+	appendFile(t, filepath.Join(dir, "other.log"), "x\n")
+	tl.Poll()
+	expect(t, c.take(), "x")
+	if err := tl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart: only lines written while stopped are read.
+	appendFile(t, logPath, "seven\n")
+	tl, err = New(cfg, c.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Poll()
+	expect(t, c.take(), "seven")
+	tl.Close()
+}
+
+func TestTailGlobFollowsRename(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "auth.log")
+	cfg := Config{
+		Sources:    []Source{{Paths: []string{logPath + "*"}}},
+		Checkpoint: filepath.Join(dir, "offsets.json"),
+	}
+	var c collect
+	tl, err := New(cfg, c.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, logPath, "one\ntwo\n")
+	tl.Poll()
+	expect(t, c.take(), "one", "two")
+
+	// The rotated file still matches the glob and is read on from its
+	// offset, not from the start.
+	os.Rename(logPath, logPath+".1")
+	appendFile(t, logPath+".1", "three\n")
+	appendFile(t, logPath, "four\n")
+	tl.Poll()
+	expect(t, c.take(), "three", "four")
+	if err := tl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The offsets follow renames made while stopped.
+	os.Rename(logPath+".1", logPath+".2")
+	os.Rename(logPath, logPath+".1")
+	appendFile(t, logPath+".1", "five\n")
+	appendFile(t, logPath, "six\n")
+	tl, err = New(cfg, c.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Poll()
+	tl.Close()
+	got := c.take()
+	sort.Strings(got)
+	expect(t, got, "five", "six")
+}
+
+func TestTailMultilineCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	cfg := Config{
+		Sources: []Source{{
+			Paths:     []string{logPath},
+			Multiline: multiline.Config{Start: `^\d{4}-`, Timeout: "1h"},
+		}},
+		Checkpoint: filepath.Join(dir, "offsets.json"),
+	}
+	var c collect
+	tl, err := New(cfg, c.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, logPath, "2024-05-01 ERROR a\n  at x\n2024-05-01 INFO b\n")
+	tl.Poll()
+	expect(t, c.take(), "2024-05-01 ERROR a\n  at x")
+
+	// The pending record is not checkpointed until it is emitted, so a
+	// restart without a clean Close reads it again.
+	tl, err = New(cfg, c.emit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, logPath, "  at y\n")
+	tl.Poll()
+	if err := tl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c.take(), "2024-05-01 INFO b\n  at y")
+}
+
+func TestTailHoldCommitsWhenDone(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	cfg := Config{Sources: []Source{{Paths: []string{logPath}}}, Checkpoint: filepath.Join(dir, "offsets.json")}
+	var held []Record
+	tl, err := New(cfg, func(r Record) { held = append(held, r) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Sources()[0].Hold = true
+	saved := func() int64 {
+		b, _ := os.ReadFile(cfg.Checkpoint)
+		var cps map[string]Checkpoint
+		json.Unmarshal(b, &cps)
+		for _, cp := range cps {
+			return cp.Offset
+		}
+		return -1
+	}
+	appendFile(t, logPath, "a\nb\n")
+	tl.Poll()
+	if len(held) != 2 || held[0].Done == nil {
+		t.Fatalf("expected two held records, got %+v", held)
+	}
+	// b is done but a is not, so neither is committed.
+	held[1].Done()
+	if err := tl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if off := saved(); off != 0 {
+		t.Fatalf("expected offset 0 while a is held, got %d", off)
+	}
+	// Records done after Close are committed by Save.
+	held[0].Done()
+	if err := tl.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if off := saved(); off != 4 {
+		t.Fatalf("expected offset 4 once both are done, got %d", off)
+	}
+}
+
+func TestTailConfigErrors(t *testing.T) {
+	for _, cfg := range []Config{
+		{Sources: []Source{{}}},
+		{Sources: []Source{{Paths: []string{"["}}}},
+		{Sources: []Source{{Paths: []string{"a"}}}, PollInterval: "soon"},
+		{Sources: []Source{{Paths: []string{"a"}}}, RotateGrace: "-1s"},
+		{Sources: []Source{{Paths: []string{"a"}, Multiline: multiline.Config{Start: "("}}}},
+	} {
+		if _, err := New(cfg, nil); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client authentication modes for servers.
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request" // verify a client certificate if one is sent
+	ClientAuthRequire = "require"
+)
+
+// Config names the PEM files of one side of a connection. For a server,
+// CAFile is the bundle client certificates are verified against; for a
+// client, the bundle the server certificate is verified against (the
+// system roots when empty), and CertFile/KeyFile the certificate presented
+// for mutual TLS.
+type Config struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	ClientAuth string // servers only; "require" when CAFile is set, else "none"
+	ServerName string // clients only; overrides the host name checked
+}
+
+// FromEnv reads <prefix>CERT_FILE, <prefix>KEY_FILE, <prefix>CA_FILE,
+// <prefix>CLIENT_AUTH and <prefix>SERVER_NAME.
+func FromEnv(prefix string) Config {
+	return Config{
+		CertFile:   os.Getenv(prefix + "CERT_FILE"),
+		KeyFile:    os.Getenv(prefix + "KEY_FILE"),
+		CAFile:     os.Getenv(prefix + "CA_FILE"),
+		ClientAuth: os.Getenv(prefix + "CLIENT_AUTH"),
+		ServerName: os.Getenv(prefix + "SERVER_NAME"),
+	}
+}
+
+// Enabled reports whether any file is configured.
+func (c Config) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.CAFile != ""
+}
+
+// reloadInterval is how often the files are checked for changes.
+var reloadInterval = 10 * time.Second
+
+// Server returns a server config. Certificates and the CA bundle are
+// re-read when their files change, so renewed certificates are picked up by
+// new connections without a restart.
+func (c Config) Server() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("tls: server needs a certificate and key")
+	}
+	// Client certificates are checked by verify against the current CA
+	// bundle rather than a fixed ClientCAs.
+	auth := tls.NoClientCert
+	switch c.ClientAuth {
+	case "":
+		if c.CAFile != "" {
+			auth = tls.RequireAnyClientCert
+		}
+	case ClientAuthNone:
+	case ClientAuthRequest:
+		auth = tls.RequestClientCert
+	case ClientAuthRequire:
+		auth = tls.RequireAnyClientCert
+	default:
+		return nil, fmt.Errorf("tls: unknown client auth %q", c.ClientAuth)
+	}
+	if auth != tls.NoClientCert && c.CAFile == "" {
+		return nil, errors.New("tls: client verification needs a CA file")
+	}
+	r, err := newReloader(c)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ClientAuth: auth}
+	cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := r.current()
+		return cert, nil
+	}
+	if auth != tls.NoClientCert {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return nil // only possible with "request"
+			}
+			_, pool := r.current()
+			return verify(cs.PeerCertificates, pool, "", x509.ExtKeyUsageClientAuth)
+		}
+	}
+	return cfg, nil
+}
+
+// verify checks chain[0] against roots, using the rest of chain as
+// intermediates.
+func verify(chain []*x509.Certificate, roots *x509.CertPool, name string, usage x509.ExtKeyUsage) error {
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, ic := range chain[1:] {
+		opts.Intermediates.AddCert(ic)
+	}
+	_, err := chain[0].Verify(opts)
+	return err
+}
+
+// Client returns a client config for connections to host, which is checked
+// against the server certificate unless ServerName overrides it. The
+// client certificate and CA bundle are reloaded like the server's.
+func (c Config) Client(host string) (*tls.Config, error) {
+	forHost, err := c.ClientFunc()
+	if err != nil {
+		return nil, err
+	}
+	return forHost(host), nil
+}
+
+// ClientFunc is like Client for clients that dial several hosts: the
+// returned function gives the config for one host, and all of them share
+// the reloaded files.
+func (c Config) ClientFunc() (func(host string) *tls.Config, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("tls: client certificate needs both a certificate and key")
+	}
+	r, err := newReloader(c)
+	if err != nil {
+		return nil, err
+	}
+	return func(host string) *tls.Config {
+		name := c.ServerName
+		if name == "" {
+			name = host
+		}
+		cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: name}
+		if c.CertFile != "" {
+			cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				cert, _ := r.current()
+				return cert, nil
+			}
+		}
+		if c.CAFile != "" {
+			// Verify against the current bundle instead of a fixed RootCAs.
+			// The name is the one dialed: cs.ServerName is empty for IP
+			// addresses, which are not sent as SNI.
+			cfg.InsecureSkipVerify = true
+			cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+				if len(cs.PeerCertificates) == 0 {
+					return errors.New("tls: server sent no certificate")
+				}
+				if name == "" {
+					return errors.New("tls: no server name to verify")
+				}
+				_, pool := r.current()
+				return verify(cs.PeerCertificates, pool, name, x509.ExtKeyUsageServerAuth)
+			}
+		}
+		return cfg
+	}, nil
+}
+
+type reloader struct {
+	cfg Config
+
+	mu      sync.Mutex
+	checked time.Time
+	mod     map[string]time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+func newReloader(c Config) (*reloader, error) {
+	r := &reloader{cfg: c, mod: make(map[string]time.Time)}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+// load re-reads the files whose modification time changed.
+func (r *reloader) load() error {
+	changed := false
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		if !fi.ModTime().Equal(r.mod[f]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	cert := &tls.Certificate{}
+	if r.cfg.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.cfg.CAFile != "" {
+		pem, err := os.ReadFile(r.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificates in %s", r.cfg.CAFile)
+		}
+	}
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if fi, err := os.Stat(f); err == nil {
+			r.mod[f] = fi.ModTime()
+		}
+	}
+	r.cert, r.pool = cert, pool
+	return nil
+}
+
+// current returns the certificate and pool, reloading them at most once
+// per reloadInterval. A failed reload keeps the previous files in use.
+func (r *reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= reloadInterval {
+		r.checked = time.Now()
+		if err := r.load(); err != nil {
+			log.Printf("tls reload: %v", err)
+		}
+	}
+	return r.cert, r.pool
+}
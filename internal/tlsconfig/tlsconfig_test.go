@@ -0,0 +1,208 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue creates a certificate signed by parent, or self-signed when parent
+// is nil, for localhost and 127.0.0.1 or else the given names.
+func issue(t *testing.T, serial int64, parent *keyPair, isCA bool, names ...string) *keyPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if len(names) > 0 {
+		tmpl.DNSNames, tmpl.IPAddresses = names, nil
+	}
+	if isCA {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &keyPair{cert, key}
+}
+
+// write stores kp as <name>.pem and <name>-key.pem in dir.
+func (kp *keyPair) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	der, _ := x509.MarshalECPrivateKey(kp.key)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: kp.cert.Raw}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+	return certFile, keyFile
+}
+
+// serve accepts TLS connections and completes their handshakes.
+func serve(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Write([]byte("ok"))
+			c.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// dial connects and returns the server certificate's serial number.
+func dial(addr string, cfg *tls.Config) (int64, error) {
+	c, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	// With TLS 1.3 a rejected client certificate surfaces on first read.
+	if _, err := c.Read(make([]byte, 2)); err != nil {
+		return 0, err
+	}
+	return c.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, 1, nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := issue(t, 2, ca, false).write(t, dir, "server")
+	clientCert, clientKey := issue(t, 3, ca, false).write(t, dir, "client")
+	strangerCert, strangerKey := issue(t, 4, nil, false).write(t, dir, "stranger")
+
+	srv, err := Config{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile}.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, srv)
+
+	client, err := Config{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile}.Client("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial, err := dial(addr, client); err != nil || serial != 2 {
+		t.Fatalf("mTLS dial: serial %d, %v", serial, err)
+	}
+
+	noCert, _ := Config{CAFile: caFile}.Client("127.0.0.1")
+	if _, err := dial(addr, noCert); err == nil {
+		t.Fatal("expected a client without certificate to be rejected")
+	}
+	stranger, _ := Config{CertFile: strangerCert, KeyFile: strangerKey, CAFile: caFile}.Client("127.0.0.1")
+	if _, err := dial(addr, stranger); err == nil {
+		t.Fatal("expected a certificate from another CA to be rejected")
+	}
+	otherCA, _ := Config{CertFile: clientCert, KeyFile: clientKey, CAFile: strangerCert}.Client("127.0.0.1")
+	if _, err := dial(addr, otherCA); err == nil {
+		t.Fatal("expected the server certificate to fail verification")
+	}
+}
+
+func TestServerNameChecked(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, 1, nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issue(t, 2, ca, false, "evil.example").write(t, dir, "server")
+	srv, err := Config{CertFile: certFile, KeyFile: keyFile}.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, srv)
+
+	// Dialed by IP, so no SNI: the address itself must be in the certificate.
+	byIP, _ := Config{CAFile: caFile}.Client("127.0.0.1")
+	if _, err := dial(addr, byIP); err == nil {
+		t.Fatal("expected a certificate without the dialed IP to be rejected")
+	}
+	wrong, _ := Config{CAFile: caFile, ServerName: "localhost"}.Client("127.0.0.1")
+	if _, err := dial(addr, wrong); err == nil {
+		t.Fatal("expected a certificate for another name to be rejected")
+	}
+	right, _ := Config{CAFile: caFile, ServerName: "evil.example"}.Client("127.0.0.1")
+	if serial, err := dial(addr, right); err != nil || serial != 2 {
+		t.Fatalf("serial %d, %v", serial, err)
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	old := reloadInterval
+	reloadInterval = 0
+	defer func() { reloadInterval = old }()
+
+	dir := t.TempDir()
+	ca := issue(t, 1, nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issue(t, 10, ca, false).write(t, dir, "server")
+
+	srv, err := Config{CertFile: certFile, KeyFile: keyFile}.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, srv)
+	client, _ := Config{CAFile: caFile, ServerName: "localhost"}.Client("127.0.0.1")
+	if serial, err := dial(addr, client); err != nil || serial != 10 {
+		t.Fatalf("serial %d, %v", serial, err)
+	}
+
+	issue(t, 11, ca, false).write(t, dir, "server")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+	if serial, err := dial(addr, client); err != nil || serial != 11 {
+		t.Fatalf("expected renewed certificate, got serial %d, %v", serial, err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	if _, err := (Config{}).Server(); err == nil {
+		t.Fatal("expected error for server without certificate")
+	}
+	if _, err := (Config{CertFile: "a", KeyFile: "b", ClientAuth: ClientAuthRequire}).Server(); err == nil {
+		t.Fatal("expected error for client auth without CA")
+	}
+	if _, err := (Config{CertFile: "a"}).Client(""); err == nil {
+		t.Fatal("expected error for certificate without key")
+	}
+}
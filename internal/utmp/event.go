@@ -0,0 +1,150 @@
+package utmp
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type Kind string
+
+const (
+	KindLogin    Kind = "login"
+	KindLogout   Kind = "logout"
+	KindBoot     Kind = "boot"
+	KindShutdown Kind = "shutdown"
+	KindFailed   Kind = "failed_login"
+)
+
+// Event is a login-relevant record with the user of a logout resolved.
+type Event struct {
+	Kind Kind
+	Time time.Time
+	User string
+	Line string
+	Host string // remote host, or the kernel version for boot/shutdown
+	Addr string
+	PID  int32
+}
+
+// Tracker turns wtmp records into events. Logout (DEAD_PROCESS) records
+// usually carry no user name, so the login on the same tty supplies it.
+// A logout whose login was never seen is still reported, without a user.
+type Tracker struct {
+	open map[string]Record
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{open: make(map[string]Record)}
+}
+
+// Seed records the sessions still open in recs, the contents of utmp, so
+// that logouts of sessions begun before wtmp was followed name their user.
+func (t *Tracker) Seed(recs []Record) {
+	for _, r := range recs {
+		if r.Type == UserProcess && r.User != "" {
+			t.open[r.Line] = r
+		}
+	}
+}
+
+// Event converts r. Records read from btmp must pass failed=true; every
+// record there is a failed attempt. Records of no interest return false.
+func (t *Tracker) Event(r Record, failed bool) (Event, bool) {
+	ev := Event{Time: r.Time, User: r.User, Line: r.Line, Host: r.Host, Addr: addrString(r), PID: r.PID}
+	if failed {
+		if r.Type != UserProcess && r.Type != LoginProcess {
+			return Event{}, false
+		}
+		ev.Kind = KindFailed
+		return ev, true
+	}
+	switch r.Type {
+	case BootTime:
+		ev.Kind = KindBoot
+		t.open = make(map[string]Record)
+	case RunLevel:
+		if r.User != "shutdown" {
+			return Event{}, false
+		}
+		ev.Kind = KindShutdown
+	case UserProcess:
+		ev.Kind = KindLogin
+		t.open[r.Line] = r
+	case DeadProcess:
+		login := t.open[r.Line]
+		delete(t.open, r.Line)
+		ev.Kind = KindLogout
+		if ev.User == "" {
+			ev.User = login.User
+		}
+		if ev.Host == "" {
+			ev.Host, ev.Addr = login.Host, addrString(login)
+		}
+		if ev.PID == 0 {
+			ev.PID = login.PID
+		}
+	default:
+		return Event{}, false
+	}
+	return ev, true
+}
+
+func addrString(r Record) string {
+	if r.Addr == nil || r.Addr.IsUnspecified() {
+		return ""
+	}
+	return r.Addr.String()
+}
+
+// Message renders ev in the wording the collector's session and detection
+// rules recognise.
+func (ev Event) Message() string {
+	from := ""
+	if ev.Addr != "" {
+		from = " from " + ev.Addr
+	} else if ev.Host != "" {
+		from = " from " + ev.Host
+	}
+	switch ev.Kind {
+	case KindLogin:
+		return fmt.Sprintf("session opened for user %s on %s%s", ev.User, ev.Line, from)
+	case KindLogout:
+		if ev.User == "" {
+			return "session closed on " + ev.Line
+		}
+		return fmt.Sprintf("session closed for user %s on %s", ev.User, ev.Line)
+	case KindFailed:
+		return fmt.Sprintf("authentication failure for user %s on %s%s", ev.User, ev.Line, from)
+	case KindBoot:
+		return "system boot " + ev.Host
+	case KindShutdown:
+		return "system shutdown " + ev.Host
+	}
+	return string(ev.Kind)
+}
+
+// Attributes returns the parsed fields as collector attributes.
+func (ev Event) Attributes() map[string]string {
+	attrs := map[string]string{"utmp_kind": string(ev.Kind)}
+	set := func(k, v string) {
+		if v != "" {
+			attrs[k] = v
+		}
+	}
+	set("tty", ev.Line)
+	set("src_ip", ev.Addr)
+	if ev.Addr == "" && ev.Kind != KindBoot && ev.Kind != KindShutdown {
+		set("src_host", ev.Host)
+	}
+	if ev.PID != 0 {
+		attrs["pid"] = strconv.Itoa(int(ev.PID))
+	}
+	switch ev.Kind {
+	case KindLogin:
+		attrs["session_state"] = "opened"
+	case KindLogout:
+		attrs["session_state"] = "closed"
+	}
+	return attrs
+}
@@ -0,0 +1,206 @@
+package utmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// RecordSize is the size of struct utmp on Linux (glibc, x86_64 and arm64).
+// Fields are little-endian.
+const RecordSize = 384
+
+type Type int16
+
+const (
+	Empty Type = iota
+	RunLevel
+	BootTime
+	NewTime
+	OldTime
+	InitProcess
+	LoginProcess
+	UserProcess
+	DeadProcess
+	Accounting
+)
+
+var typeNames = [...]string{"EMPTY", "RUN_LVL", "BOOT_TIME", "NEW_TIME", "OLD_TIME",
+	"INIT_PROCESS", "LOGIN_PROCESS", "USER_PROCESS", "DEAD_PROCESS", "ACCOUNTING"}
+
+func (t Type) String() string {
+	if t >= 0 && int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return fmt.Sprintf("TYPE(%d)", int16(t))
+}
+
+// Record is one decoded utmp entry. For boot and run-level records User
+// holds a pseudo user such as "reboot" and Host the kernel version.
+type Record struct {
+	Type    Type
+	PID     int32
+	Line    string // tty, e.g. "pts/0" or "ssh:notty"
+	ID      string
+	User    string
+	Host    string
+	Exit    int16
+	Session int32
+	Time    time.Time
+	Addr    net.IP
+}
+
+// Field offsets within a record.
+const (
+	offType    = 0
+	offPID     = 4
+	offLine    = 8
+	offID      = 40
+	offUser    = 44
+	offHost    = 76
+	offExit    = 332
+	offSession = 336
+	offTime    = 340
+	offAddr    = 348
+)
+
+var ErrShortRecord = errors.New("utmp: short record")
+
+func Parse(b []byte) (Record, error) {
+	if len(b) < RecordSize {
+		return Record{}, ErrShortRecord
+	}
+	le := binary.LittleEndian
+	r := Record{
+		Type:    Type(le.Uint16(b[offType:])),
+		PID:     int32(le.Uint32(b[offPID:])),
+		Line:    cstring(b[offLine:offID]),
+		ID:      cstring(b[offID:offUser]),
+		User:    cstring(b[offUser:offHost]),
+		Host:    cstring(b[offHost:offExit]),
+		Exit:    int16(le.Uint16(b[offExit+2:])),
+		Session: int32(le.Uint32(b[offSession:])),
+		Time: time.Unix(int64(int32(le.Uint32(b[offTime:]))),
+			int64(int32(le.Uint32(b[offTime+4:])))*1000).UTC(),
+	}
+	addr := b[offAddr : offAddr+16]
+	switch {
+	case bytes.Equal(addr[4:], make([]byte, 12)):
+		if !bytes.Equal(addr[:4], make([]byte, 4)) {
+			r.Addr = net.IP(append([]byte(nil), addr[:4]...)).To4()
+		}
+	default:
+		r.Addr = net.IP(append([]byte(nil), addr...))
+	}
+	return r, nil
+}
+
+// Marshal encodes r in the on-disk format.
+func (r Record) Marshal() []byte {
+	b := make([]byte, RecordSize)
+	le := binary.LittleEndian
+	le.PutUint16(b[offType:], uint16(r.Type))
+	le.PutUint32(b[offPID:], uint32(r.PID))
+	copy(b[offLine:offID], r.Line)
+	copy(b[offID:offUser], r.ID)
+	copy(b[offUser:offHost], r.User)
+	copy(b[offHost:offExit], r.Host)
+	le.PutUint16(b[offExit+2:], uint16(r.Exit))
+	le.PutUint32(b[offSession:], uint32(r.Session))
+	if !r.Time.IsZero() {
+		le.PutUint32(b[offTime:], uint32(r.Time.Unix()))
+		le.PutUint32(b[offTime+4:], uint32(r.Time.Nanosecond()/1000))
+	}
+	if ip4 := r.Addr.To4(); ip4 != nil {
+		copy(b[offAddr:], ip4)
+	} else if r.Addr != nil {
+		copy(b[offAddr:], r.Addr.To16())
+	}
+	return b
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Read decodes all complete records from r.
+func Read(r io.Reader) ([]Record, error) {
+	var out []Record
+	buf := make([]byte, RecordSize)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return out, nil
+			}
+			return out, err
+		}
+		rec, _ := Parse(buf)
+		out = append(out, rec)
+	}
+}
+
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Follower reads records appended to a file since the last call, like
+// `last -f` on a live wtmp. A file that shrinks (rotated or truncated) is
+// read again from the start.
+type Follower struct {
+	Path   string
+	offset int64
+}
+
+// NewFollower starts at the end of path, or at its beginning if fromStart.
+func NewFollower(path string, fromStart bool) (*Follower, error) {
+	f := &Follower{Path: path}
+	if !fromStart {
+		info, err := os.Stat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			f.offset = info.Size() - info.Size()%RecordSize
+		}
+	}
+	return f, nil
+}
+
+// Next returns the records written since the previous call. A missing file
+// yields no records.
+func (f *Follower) Next() ([]Record, error) {
+	fh, err := os.Open(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < f.offset {
+		f.offset = 0
+	}
+	if _, err := fh.Seek(f.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	recs, err := Read(fh)
+	f.offset += int64(len(recs)) * RecordSize
+	return recs, err
+}
@@ -0,0 +1,142 @@
+package utmp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadWtmpFixture(t *testing.T) {
+	recs, err := ReadFile("testdata/wtmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 8 {
+		t.Fatalf("expected 8 records, got %d", len(recs))
+	}
+	alice := recs[3]
+	if alice.Type != UserProcess || alice.User != "alice" || alice.Line != "pts/0" || alice.PID != 4242 {
+		t.Fatalf("unexpected record %+v", alice)
+	}
+	if !alice.Addr.Equal(net.ParseIP("203.0.113.9")) || alice.Session != 4242 {
+		t.Fatalf("unexpected address/session %+v", alice)
+	}
+	if want := time.Date(2024, 7, 29, 12, 35, 24, 120000000, time.UTC); !alice.Time.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, alice.Time)
+	}
+	if !recs[4].Addr.Equal(net.ParseIP("2001:db8::7")) {
+		t.Fatalf("expected IPv6 address, got %v", recs[4].Addr)
+	}
+	if recs[0].Type != BootTime || recs[0].Host != "6.1.0-21-amd64" || recs[0].Type.String() != "BOOT_TIME" {
+		t.Fatalf("unexpected boot record %+v", recs[0])
+	}
+
+	parsed, err := Parse(alice.Marshal())
+	if err != nil || parsed.User != alice.User || !parsed.Time.Equal(alice.Time) || !parsed.Addr.Equal(alice.Addr) {
+		t.Fatalf("marshal round trip failed: %+v, %v", parsed, err)
+	}
+	if _, err := Parse(make([]byte, 10)); err != ErrShortRecord {
+		t.Fatalf("expected ErrShortRecord, got %v", err)
+	}
+}
+
+func TestTrackerEvents(t *testing.T) {
+	recs, _ := ReadFile("testdata/wtmp")
+	tr := NewTracker()
+	var kinds []Kind
+	var logouts []Event
+	for _, r := range recs {
+		if ev, ok := tr.Event(r, false); ok {
+			kinds = append(kinds, ev.Kind)
+			if ev.Kind == KindLogout {
+				logouts = append(logouts, ev)
+			}
+		}
+	}
+	want := []Kind{KindBoot, KindLogin, KindLogin, KindLogout, KindLogout, KindShutdown}
+	if len(kinds) != len(want) {
+		t.Fatalf("expected %v, got %v", want, kinds)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, kinds)
+		}
+	}
+	logout := logouts[0]
+	if logout.User != "alice" || logout.Addr != "203.0.113.9" {
+		t.Fatalf("expected logout resolved to alice's login, got %+v", logout)
+	}
+	if got := logout.Message(); got != "session closed for user alice on pts/0" {
+		t.Fatalf("unexpected message %q", got)
+	}
+	if a := logout.Attributes(); a["session_state"] != "closed" || a["pid"] != "4242" || a["tty"] != "pts/0" {
+		t.Fatalf("unexpected attributes %v", a)
+	}
+	// pts/9 was opened before the file starts: the logout is still reported.
+	unknown := logouts[1]
+	if unknown.User != "" || unknown.Line != "pts/9" || unknown.Message() != "session closed on pts/9" {
+		t.Fatalf("unexpected untracked logout %+v", unknown)
+	}
+	if a := unknown.Attributes(); a["pid"] != "9999" || a["session_state"] != "closed" {
+		t.Fatalf("unexpected attributes %v", a)
+	}
+
+	btmp, err := ReadFile("testdata/btmp")
+	if err != nil || len(btmp) != 2 {
+		t.Fatalf("expected 2 btmp records, got %d (%v)", len(btmp), err)
+	}
+	ev, ok := tr.Event(btmp[0], true)
+	if !ok || ev.Kind != KindFailed || ev.User != "root" {
+		t.Fatalf("unexpected failed event %+v", ev)
+	}
+	if got := ev.Message(); got != "authentication failure for user root on ssh:notty from 198.51.100.23" {
+		t.Fatalf("unexpected message %q", got)
+	}
+}
+
+func TestTrackerSeed(t *testing.T) {
+	recs, _ := ReadFile("testdata/wtmp")
+	tr := NewTracker()
+	tr.Seed([]Record{
+		{Type: UserProcess, User: "carol", Line: "pts/9", Host: "bastion", PID: 9999},
+		{Type: DeadProcess, Line: "pts/3"},
+	})
+	ev, ok := tr.Event(recs[6], false)
+	if !ok || ev.Kind != KindLogout || ev.User != "carol" || ev.Host != "bastion" {
+		t.Fatalf("expected logout resolved from utmp, got %+v", ev)
+	}
+}
+
+func TestFollower(t *testing.T) {
+	src, _ := os.ReadFile("testdata/wtmp")
+	path := filepath.Join(t.TempDir(), "wtmp")
+	os.WriteFile(path, src[:3*RecordSize], 0o644)
+
+	f, err := NewFollower(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recs, _ := f.Next(); len(recs) != 0 {
+		t.Fatalf("expected to start at the end, got %d records", len(recs))
+	}
+	// Append one and a half records; only the complete one is returned.
+	fh, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	fh.Write(src[3*RecordSize : 4*RecordSize+100])
+	recs, _ := f.Next()
+	if len(recs) != 1 || recs[0].User != "alice" {
+		t.Fatalf("expected alice's login, got %+v", recs)
+	}
+	fh.Write(src[4*RecordSize+100 : 5*RecordSize])
+	fh.Close()
+	if recs, _ := f.Next(); len(recs) != 1 || recs[0].User != "bob" {
+		t.Fatalf("expected bob's login, got %+v", recs)
+	}
+
+	// Rotated: a new, shorter file is read from the start.
+	os.WriteFile(path, src[:RecordSize], 0o644)
+	if recs, _ := f.Next(); len(recs) != 1 || recs[0].Type != BootTime {
+		t.Fatalf("expected boot record after rotation, got %+v", recs)
+	}
+}
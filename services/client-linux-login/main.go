@@ -1,72 +1,26 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
-	"log"
+	"flag"
 	"math/rand/v2"
-	"net"
-	"os"
-	"time"
-)
 
-type Payload struct {
-	Timestamp string `json:"timestamp"`
-	Hostname  string `json:"hostname"`
-	Source    string `json:"event.source.type"`
-	Category  string `json:"event.category"`
-	Message   string `json:"message"`
-}
+	"motadata/internal/agent"
+	"motadata/internal/utmp"
+)
 
-func getEnv(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// message simulates a login audit success or, one time in five, a failure.
+func message(hostname, username string) string {
+	if rand.Float64() > 0.2 {
+		return "<86> " + hostname + " sudo: pam_unix(sudo:session): session opened for user " + username + "(uid=0) by motadata(uid=1000)"
 	}
-	return def
+	return "<4> " + hostname + " sshd: Failed password for invalid user " + username + " from 10.0.0.13 port 22 ssh2"
 }
 
 func main() {
-	addr := getEnv("COLLECTOR_ADDR", "log-collector:9000")
-	hostname := getEnv("HOSTNAME", "aiops9242")
-	d := net.Dialer{Timeout: 5 * time.Second}
-	for {
-		conn, err := d.Dial("tcp", addr)
-		if err != nil {
-			log.Printf("dial error: %v", err)
-			time.Sleep(2 * time.Second)
-			continue
-		}
-		log.Printf("connected to %s", addr)
-		writer := bufio.NewWriter(conn)
-		enc := json.NewEncoder(writer)
-		for {
-			// Simulate login audit success/failure
-			success := rand.Float64() > 0.2
-			username := []string{"root", "motadata", "alice", "bob"}[rand.IntN(4)]
-			var msg string
-			if success {
-				msg = "<86> " + hostname + " sudo: pam_unix(sudo:session): session opened for user " + username + "(uid=0) by motadata(uid=1000)"
-			} else {
-				msg = "<4> " + hostname + " sshd: Failed password for invalid user " + username + " from 10.0.0.13 port 22 ssh2"
-			}
-			p := Payload{
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-				Hostname:  hostname,
-				Source:    "linux",
-				Category:  "login.audit",
-				Message:   msg,
-			}
-			if err := enc.Encode(p); err != nil {
-				log.Printf("encode error: %v", err)
-				break
-			}
-			if err := writer.Flush(); err != nil {
-				log.Printf("flush error: %v", err)
-				break
-			}
-			time.Sleep(time.Duration(1000+rand.IntN(1000)) * time.Millisecond)
-		}
-		conn.Close()
-		time.Sleep(2 * time.Second)
-	}
+	var f agent.UtmpFlags
+	f.RegisterFlags(flag.CommandLine, true)
+	agent.Main(func(a *agent.Agent, stop <-chan struct{}) error {
+		kinds := []utmp.Kind{utmp.KindLogin, utmp.KindFailed, utmp.KindBoot, utmp.KindShutdown}
+		return f.Run(a, stop, kinds, "login.audit", message)
+	})
 }
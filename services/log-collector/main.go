@@ -3,20 +3,41 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
-    "fmt"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"motadata/internal/auditd"
+	"motadata/internal/auth"
+	"motadata/internal/dedup"
+	"motadata/internal/detect"
+	"motadata/internal/ecs"
+	"motadata/internal/filter"
+	"motadata/internal/frame"
+	"motadata/internal/grok"
+	"motadata/internal/journal"
 	"motadata/internal/model"
+	"motadata/internal/multiline"
+	"motadata/internal/redact"
+	"motadata/internal/sigma"
+	"motadata/internal/sink"
+	"motadata/internal/tail"
+	"motadata/internal/tlsconfig"
 )
 
 // Incoming payload from clients
@@ -26,6 +47,43 @@ type ClientLog struct {
 	Source    string `json:"event.source.type,omitempty"`
 	Category  string `json:"event.category,omitempty"`
 	Message   string `json:"message"`
+
+	// Optional pre-parsed fields, e.g. from ECS input; they take precedence
+	// over values extracted from Message.
+	Username   string            `json:"username,omitempty"`
+	Severity   string            `json:"severity,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// Tenant comes from the connection, never from the payload; see
+	// connTenant.
+	Tenant string `json:"-"`
+
+	// done, if set, is called once the workers have handed the entry to
+	// the sinks.
+	done func()
+}
+
+// decodeClientLog accepts either the flat client payload or an ECS document.
+func decodeClientLog(b []byte) (ClientLog, error) {
+	if d, ok := ecs.Parse(b); ok {
+		e := ecs.ToEntry(d)
+		cl := ClientLog{
+			Hostname:   e.Hostname,
+			Source:     e.EventSourceType,
+			Category:   e.EventCategory,
+			Message:    e.RawMessage,
+			Username:   e.Username,
+			Severity:   e.Severity,
+			Attributes: e.Attributes,
+		}
+		if !e.Timestamp.IsZero() {
+			cl.Timestamp = e.Timestamp.Format(time.RFC3339Nano)
+		}
+		return cl, nil
+	}
+	var cl ClientLog
+	err := json.Unmarshal(b, &cl)
+	return cl, err
 }
 
 var (
@@ -33,11 +91,52 @@ var (
 	blacklistIPs   = map[string]struct{}{"10.0.0.13": {}, "192.168.1.66": {}}
 )
 
+// Source IPs blacklisted automatically by detections in autoBlacklistRules.
+var (
+	autoBlacklist      = detect.NewBlacklist(time.Hour)
+	autoBlacklistRules = map[string]struct{}{detect.RuleBruteForceIP: {}}
+)
+
 var (
 	reSyslog = regexp.MustCompile(`^<(\d+)>\s+(\S+)\s+([^:]+):\s+(.*)$`)
 	reUser   = regexp.MustCompile(`user\s+([A-Za-z0-9_-]+)`)
 )
 
+var authPatterns = mustCompileSet(grok.New(), grok.LinuxAuth)
+
+// sigmaRules tags entries matching loaded Sigma rules; nil when no rules
+// are configured.
+var sigmaRules *sigma.Engine
+
+// redactor scrubs PII from entries before they leave the collector; nil
+// when redaction is not configured.
+var redactor *redact.Redactor
+
+func mustCompileSet(g *grok.Grok, names []string) *grok.Set {
+	s, err := g.CompileSet(names...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// loadGrokPatterns adds the definitions in file (if any) to the built-in
+// library and puts the named patterns in front of the Linux auth set.
+func loadGrokPatterns(file string, names []string) (*grok.Set, error) {
+	g := grok.New()
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := g.AddPatterns(f); err != nil {
+			return nil, err
+		}
+	}
+	return g.CompileSet(append(names, grok.LinuxAuth...)...)
+}
+
 func parseSeverity(codeStr string) string {
 	code := 6 // default info-ish
 	if codeStr != "" {
@@ -68,6 +167,42 @@ func enrichLog(entry *model.LogEntry) {
 	}
 }
 
+// flagAutoBlacklisted runs after redaction so that hashed source IPs match
+// the hashed values the detections were raised on.
+func flagAutoBlacklisted(entry *model.LogEntry) {
+	if autoBlacklist.Contains(entry.Tenant, detect.SourceIP(*entry)) {
+		entry.IsBlacklisted = true
+		entry.SetAttr("blacklist.source", "auto")
+	}
+}
+
+// applyDetections adds the source IP of qualifying alerts to the
+// automatic blacklist.
+func applyDetections(alerts []model.LogEntry) {
+	for _, a := range alerts {
+		if _, ok := autoBlacklistRules[a.Attr("rule")]; ok {
+			autoBlacklist.Add(a.Tenant, a.Attr("src_ip"), a.Attr("rule"))
+		}
+	}
+}
+
+// matchAuth runs the auth patterns over the message. Inputs that deliver
+// the program and pid as separate fields (journald) are also tried in the
+// classic "host program[pid]: message" syslog layout the patterns expect.
+func matchAuth(cl ClientLog) (string, map[string]string, bool) {
+	if name, fields, ok := authPatterns.Match(cl.Message); ok {
+		return name, fields, true
+	}
+	prog := cl.Attributes["program"]
+	if prog == "" || cl.Hostname == "" {
+		return "", nil, false
+	}
+	if pid := cl.Attributes["pid"]; pid != "" {
+		prog += "[" + pid + "]"
+	}
+	return authPatterns.Match(cl.Hostname + " " + prog + ": " + cl.Message)
+}
+
 func parseLog(cl ClientLog) model.LogEntry {
 	ts := time.Now().UTC()
 	if cl.Timestamp != "" {
@@ -82,6 +217,13 @@ func parseLog(cl ClientLog) model.LogEntry {
 		Hostname:        cl.Hostname,
 		RawMessage:      cl.Message,
 		Service:         strings.ToLower(cl.Source) + "_" + strings.ReplaceAll(strings.ToLower(cl.Category), ".", "_"),
+		Tenant:          cl.Tenant,
+	}
+	if entry.Tenant == "" {
+		entry.Tenant = defaultTenant
+	}
+	for k, v := range cl.Attributes {
+		entry.SetAttr(k, v)
 	}
 	if m := reSyslog.FindStringSubmatch(cl.Message); len(m) == 5 {
 		entry.Severity = parseSeverity(m[1])
@@ -92,33 +234,44 @@ func parseLog(cl ClientLog) model.LogEntry {
 			entry.Username = u[1]
 		}
 	}
+	if _, fields, ok := matchAuth(cl); ok {
+		rest := grok.Apply(&entry, fields)
+		if entry.Severity == "" && rest["syslog_pri"] != "" {
+			entry.Severity = parseSeverity(rest["syslog_pri"])
+		}
+		for k, v := range rest {
+			entry.SetAttr(k, v)
+		}
+	}
+	if cl.Username != "" {
+		entry.Username = cl.Username
+	}
+	if cl.Severity != "" {
+		entry.Severity = strings.ToUpper(cl.Severity)
+	}
 	enrichLog(&entry)
+	if sigmaRules != nil {
+		sigmaRules.Tag(&entry)
+	}
+	if redactor != nil {
+		redactor.Apply(&entry)
+	}
+	flagAutoBlacklisted(&entry)
 	return entry
 }
 
 var httpClient = &http.Client{Timeout: 5 * time.Second}
 
-func forwardLog(entry model.LogEntry, endpoint string) error {
-	b, _ := json.Marshal(entry)
-	req, _ := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(b))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("ingest returned status %d", resp.StatusCode)
-	}
-	return nil
-}
-
 type collectorMetrics struct {
-	mu    sync.RWMutex
-	total int
-	byCat map[string]int
-	bySev map[string]int
+	mu          sync.RWMutex
+	total       int
+	byCat       map[string]int
+	bySev       map[string]int
+	dropped     int
+	sampled     int
+	rateLimited int
+
+	sinkStats func() map[string]sink.Stats
 }
 
 func newCollectorMetrics() *collectorMetrics {
@@ -137,6 +290,20 @@ func (m *collectorMetrics) inc(category, severity string) {
 	}
 }
 
+// filtered counts an entry discarded by the filter rules.
+func (m *collectorMetrics) filtered(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch reason {
+	case filter.ReasonDropped:
+		m.dropped++
+	case filter.ReasonSampled:
+		m.sampled++
+	case filter.ReasonRateLimited:
+		m.rateLimited++
+	}
+}
+
 func (m *collectorMetrics) snapshot() map[string]any {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -148,10 +315,43 @@ func (m *collectorMetrics) snapshot() map[string]any {
 	for k, v := range m.bySev {
 		bySev[k] = v
 	}
-	return map[string]any{"total": m.total, "byCategory": byCat, "bySeverity": bySev}
+	snap := map[string]any{
+		"total":       m.total,
+		"byCategory":  byCat,
+		"bySeverity":  bySev,
+		"dropped":     m.dropped,
+		"sampled":     m.sampled,
+		"rateLimited": m.rateLimited,
+		"invalid":     invalidPayloads.Load(),
+	}
+	if redactor != nil {
+		snap["redactions"] = redactor.Counts()
+	}
+	if m.sinkStats != nil {
+		snap["sinks"] = m.sinkStats()
+	}
+	return snap
 }
 
-func startMetricsServer(addr string, m *collectorMetrics) {
+// adminKeys, loaded from ADMIN_KEYS_FILE, guard the blacklist endpoints.
+var adminKeys *auth.Authenticator
+
+// blacklistRoute guards a blacklist endpoint: with adminKeys it requires a
+// key allowed role, without them only clients on the same host may use it.
+func blacklistRoute(role auth.Role, h http.HandlerFunc) http.Handler {
+	if adminKeys != nil {
+		return adminKeys.Middleware()(auth.Require(role, h))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := net.ParseIP(auth.ClientIP(r)); ip == nil || !ip.IsLoopback() {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	})
+}
+
+func newAdminMux(m *collectorMetrics) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -160,6 +360,34 @@ func startMetricsServer(addr string, m *collectorMetrics) {
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
+	mux.Handle("GET /blacklist", blacklistRoute(auth.RoleRead, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(autoBlacklist.List())
+	}))
+	mux.Handle("DELETE /blacklist", blacklistRoute(auth.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"removed": autoBlacklist.Clear()})
+	}))
+	// Without ?tenant= the IP is removed for every tenant.
+	mux.Handle("DELETE /blacklist/{ip}", blacklistRoute(auth.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		ip := r.PathValue("ip")
+		removed := false
+		if q := r.URL.Query(); q.Has("tenant") {
+			removed = autoBlacklist.Remove(q.Get("tenant"), ip)
+		} else {
+			removed = autoBlacklist.RemoveIP(ip) > 0
+		}
+		if !removed {
+			http.Error(w, "not blacklisted", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return mux
+}
+
+func startMetricsServer(addr string, m *collectorMetrics) {
+	mux := newAdminMux(m)
 	go func() {
 		if err := http.ListenAndServe(addr, mux); err != nil {
 			log.Printf("metrics server error: %v", err)
@@ -167,78 +395,755 @@ func startMetricsServer(addr string, m *collectorMetrics) {
 	}()
 }
 
-func listenTCP(addr string, out chan<- ClientLog) error {
-	ln, err := net.Listen("tcp", addr)
+// listenTLS, when set, makes the TCP and journal listeners accept TLS
+// connections only.
+var listenTLS *tls.Config
+
+func listen(addr string) (net.Listener, error) {
+	if listenTLS != nil {
+		return tls.Listen("tcp", addr, listenTLS)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func listenTCP(addr string, out chan<- ClientLog) (net.Listener, error) {
+	ln, err := listen(addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Printf("log-collector listening on %s", addr)
 	go func() {
 		for {
 			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				log.Printf("accept error: %v", err)
+				continue
+			}
+			go handleConn(conn, out)
+		}
+	}()
+	return ln, nil
+}
+
+// handleConn reads newline-delimited records from c. Lines that decode as
+// JSON payloads are events, unless they continue a pending raw record;
+// anything else is raw text grouped by rawMultiline. A connection starting
+// with frame.Version uses the acknowledged protocol instead.
+func handleConn(c net.Conn, out chan<- ClientLog) {
+	defer c.Close()
+	reader := bufio.NewReader(c)
+	if b, err := reader.Peek(1); err == nil && b[0] == frame.Version {
+		handleFramed(c, reader, connTenant(c), out)
+		return
+	}
+	tenant := connTenant(c)
+	agg, err := multiline.New(rawMultiline, func(rec string) {
+		cl := rawClientLog(rec)
+		cl.Tenant = tenant
+		out <- cl
+	})
+	if err != nil {
+		log.Printf("multiline: %v", err)
+		return
+	}
+	defer agg.Flush()
+	for {
+		line, err := reader.ReadBytes('\n')
+		trimmed := bytes.TrimSpace(line)
+		if cl, raw, err := jsonLine(agg, line); err != nil {
+			invalidPayload(err)
+		} else if !raw {
+			agg.Flush()
+			cl.Tenant = tenant
+			out <- cl
+		} else if len(trimmed) > 0 || (len(line) > 0 && rawMultiline.Enabled()) {
+			agg.Add(string(line))
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("conn read error: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// jsonLine decodes a line starting with '{' as a client payload; raw is
+// set for other lines and for lines that continue a pending raw record.
+// A payload that does not decode is an error, unless multiline records
+// are configured: it is then taken as raw text, such as part of
+// pretty-printed JSON.
+func jsonLine(agg *multiline.Aggregator, line []byte) (cl ClientLog, raw bool, err error) {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 || trimmed[0] != '{' || agg.Extends(string(line)) {
+		return ClientLog{}, true, nil
+	}
+	cl, err = decodeClientLog(trimmed)
+	if err != nil && rawMultiline.Enabled() {
+		return ClientLog{}, true, nil
+	}
+	return cl, false, err
+}
+
+// invalidPayloads counts client payloads that did not decode.
+var invalidPayloads atomic.Int64
+
+func invalidPayload(err error) {
+	invalidPayloads.Add(1)
+	log.Printf("invalid client payload: %v", err)
+}
+
+// ackInterval is how often a slow window is acknowledged before it is
+// complete, so the sender knows the collector is making progress.
+var ackInterval = time.Second
+
+// acker acknowledges the events of a framed window once they are done.
+// Workers finish events out of order, so an ack names the longest prefix
+// of the window that is done.
+type acker struct {
+	c net.Conn
+
+	mu      sync.Mutex
+	gen     int // counts windows; events of an earlier one are ignored
+	window  uint32
+	through uint32 // every event up to through is done
+	acked   uint32
+	pending map[uint32]bool
+	last    time.Time
+	closed  bool
+}
+
+// start begins a window of n events.
+func (a *acker) start(n uint32) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.gen++
+	a.window, a.through, a.acked = n, 0, 0
+	a.pending = make(map[uint32]bool)
+	a.last = time.Now()
+	return a.gen
+}
+
+// done marks event seq of window gen as done. The ack is sent when the
+// window is complete, or after ackInterval while it is still in progress.
+func (a *acker) done(gen int, seq uint32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if gen != a.gen || a.closed {
+		return
+	}
+	a.pending[seq] = true
+	for a.pending[a.through+1] {
+		delete(a.pending, a.through+1)
+		a.through++
+	}
+	if a.through == a.acked || (a.through < a.window && time.Since(a.last) < ackInterval) {
+		return
+	}
+	// Acks are written by the workers; a stalled agent must not hold one up.
+	a.c.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := frame.Write(a.c, frame.Frame{Type: frame.TypeAck, Seq: a.through}); err != nil {
+		log.Printf("conn write error: %v", err)
+		a.closed = true
+		a.c.Close()
+		return
+	}
+	a.acked, a.last = a.through, time.Now()
+}
+
+// close stops acknowledging once the connection is gone.
+func (a *acker) close() {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+}
+
+// handleFramed reads windows of data frames and acknowledges their events
+// once the workers have handed them to the sinks, so an acked event is no
+// longer lost when a sink queue is full. Payloads that do not decode are
+// acknowledged too; resending them would not help.
+func handleFramed(c net.Conn, r *bufio.Reader, tenant string, out chan<- ClientLog) {
+	a := &acker{c: c}
+	defer a.close()
+	gen := a.start(0)
+	for {
+		f, err := frame.Read(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("conn read error: %v", err)
+			}
+			return
+		}
+		switch f.Type {
+		case frame.TypeWindow:
+			gen = a.start(f.Seq)
+			continue
+		case frame.TypeData:
+		default:
+			log.Printf("unexpected frame %q", f.Type)
+			return
+		}
+		gen, seq := gen, f.Seq
+		cl, err := decodeClientLog(f.Payload)
+		if err != nil {
+			invalidPayload(err)
+			a.done(gen, seq)
+			continue
+		}
+		cl.Tenant = tenant
+		cl.done = func() { a.done(gen, seq) }
+		out <- cl
+	}
+}
+
+// defaultTenant is given to client logs whose connection names no tenant
+// and to the local inputs.
+var defaultTenant string
+
+// connTenant returns the tenant of an agent connection: the first
+// organization (O=) of its verified client certificate, or "" for the
+// default tenant. Tenants named in payloads are not trusted, since any
+// agent that can reach the listener could write into another tenant.
+func connTenant(c net.Conn) string {
+	tc, ok := c.(*tls.Conn)
+	if !ok || tc.Handshake() != nil {
+		return ""
+	}
+	if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 && len(certs[0].Subject.Organization) > 0 {
+		return certs[0].Subject.Organization[0]
+	}
+	return ""
+}
+
+// Raw text records are wrapped with these source and category values.
+var (
+	rawMultiline multiline.Config
+	rawSource    = "raw"
+	rawCategory  = "application.log"
+)
+
+func rawClientLog(msg string) ClientLog {
+	return ClientLog{Source: rawSource, Category: rawCategory, Message: msg}
+}
+
+// tailClientLog wraps a record read by the file input.
+func tailClientLog(r tail.Record) (ClientLog, error) {
+	cl := ClientLog{Message: r.Text}
+	if r.Source.Format == "json" {
+		var err error
+		if cl, err = decodeClientLog([]byte(r.Text)); err != nil {
+			return cl, err
+		}
+	}
+	if cl.Hostname == "" {
+		cl.Hostname = r.Source.Hostname
+	}
+	if cl.Source == "" {
+		cl.Source = r.Source.Source
+	}
+	if cl.Category == "" {
+		cl.Category = r.Source.Category
+	}
+	if cl.Attributes == nil {
+		cl.Attributes = make(map[string]string)
+	}
+	cl.Attributes["file_path"] = r.Path
+	return cl, nil
+}
+
+// auditClientLog maps an aggregated auditd event onto a client log.
+func auditClientLog(ev auditd.Event) ClientLog {
+	cl := ClientLog{
+		Timestamp: ev.Time.Format(time.RFC3339Nano),
+		Hostname:  ev.Node,
+		Source:    "auditd",
+		Category:  "audit.log",
+		Message:   ev.Raw(),
+		Username:  ev.Username(),
+		Severity:  "INFO",
+		Attributes: map[string]string{
+			"audit_type":   strings.Join(ev.Types(), ","),
+			"audit_serial": strconv.FormatUint(ev.Serial, 10),
+		},
+	}
+	if ev.IsLogin() {
+		cl.Category = "login.audit"
+	}
+	outcome := ev.Outcome()
+	if outcome == "failure" {
+		cl.Severity = "WARN"
+	}
+	set := func(k, v string) {
+		if v != "" {
+			cl.Attributes[k] = v
+		}
+	}
+	set("outcome", outcome)
+	set("src_ip", ev.Field("addr"))
+	set("tty", ev.Field("terminal"))
+	set("pid", ev.Field("pid"))
+	set("exe", ev.Field("exe"))
+	if ses := ev.Field("ses"); ses != "4294967295" {
+		set("session_id", ses)
+	}
+	switch ev.Types()[0] {
+	case "USER_START":
+		cl.Attributes["session_state"] = "opened"
+	case "USER_END":
+		cl.Attributes["session_state"] = "closed"
+	}
+	return cl
+}
+
+// tailInput is the file input together with its auditd aggregators.
+type tailInput struct {
+	*tail.Tailer
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Close stops reading, forwards the auditd events still held by the
+// aggregators and then saves the offsets past them.
+func (ti *tailInput) Close() error {
+	err := ti.Tailer.Close()
+	close(ti.stop)
+	ti.wg.Wait()
+	if serr := ti.Save(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// startTail starts the file input configured in TAIL_CONFIG.
+func startTail(path string, out chan<- ClientLog) (*tailInput, error) {
+	cfg, err := tail.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range cfg.Sources {
+		switch s.Format {
+		case "", "raw", "json", "auditd":
+		default:
+			return nil, fmt.Errorf("tail: unknown format %q", s.Format)
+		}
+	}
+	// auditd lines are grouped by serial per source before forwarding.
+	// Their offsets are held until the event holding them is forwarded, so
+	// a crash does not lose the events the aggregators are waiting on.
+	audit := make(map[*tail.Source]*auditd.Aggregator)
+	t, err := tail.New(cfg, func(r tail.Record) {
+		if r.Source.Format == "auditd" {
+			rec, err := auditd.ParseLine(r.Text)
+			if err != nil {
+				log.Printf("tail %s: %v", r.Path, err)
+				r.Done()
+				return
+			}
+			rec.Done = r.Done
+			audit[r.Source].Add(rec)
+			if rec.Type == "EOE" {
+				// Not part of the event; it only ends it.
+				r.Done()
+			}
+			return
+		}
+		if cl, err := tailClientLog(r); err == nil {
+			out <- cl
+		} else {
+			log.Printf("tail %s: %v", r.Path, err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	ti := &tailInput{Tailer: t, stop: make(chan struct{})}
+	for _, src := range t.Sources() {
+		if src.Format != "auditd" {
+			continue
+		}
+		src := src
+		src.Hold = true
+		agg := auditd.NewAggregator(2*time.Second, func(ev auditd.Event) {
+			cl := auditClientLog(ev)
+			if cl.Hostname == "" {
+				cl.Hostname = src.Hostname
+			}
+			out <- cl
+			for _, r := range ev.Records {
+				if r.Done != nil {
+					r.Done()
+				}
+			}
+		})
+		audit[src] = agg
+		ti.wg.Add(1)
+		go func() {
+			defer ti.wg.Done()
+			agg.Run(ti.stop)
+		}()
+	}
+	t.Start()
+	log.Printf("tailing %d file sources", len(cfg.Sources))
+	return ti, nil
+}
+
+// journalCategory is used for journal entries outside the auth facilities.
+var journalCategory = "journal.log"
+
+// journalClientLog maps a journal export entry onto a client log.
+func journalClientLog(e journal.Entry) ClientLog {
+	cl := ClientLog{
+		Hostname:   e["_HOSTNAME"],
+		Source:     "journald",
+		Category:   journalCategory,
+		Message:    e["MESSAGE"],
+		Attributes: make(map[string]string),
+	}
+	if t := e.Time(); !t.IsZero() {
+		cl.Timestamp = t.Format(time.RFC3339Nano)
+	}
+	if p := e["PRIORITY"]; p != "" {
+		cl.Severity = parseSeverity(p)
+	}
+	// auth and authpriv
+	if f := e["SYSLOG_FACILITY"]; f == "4" || f == "10" {
+		cl.Category = "login.audit"
+	}
+	for field, attr := range map[string]string{
+		"SYSLOG_IDENTIFIER": "program",
+		"_PID":              "pid",
+		"_SYSTEMD_UNIT":     "systemd_unit",
+	} {
+		if v := e[field]; v != "" {
+			cl.Attributes[attr] = v
+		}
+	}
+	return cl
+}
+
+// readJournal forwards every entry of an export-format stream, for
+// tenant.
+func readJournal(r io.Reader, tenant string, out chan<- ClientLog) error {
+	jr := journal.NewReader(r)
+	for {
+		e, err := jr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cl := journalClientLog(e)
+		cl.Tenant = tenant
+		out <- cl
+	}
+}
+
+// listenJournal accepts journal export streams, e.g. from
+// `journalctl -o export -f | nc collector 9001`.
+func listenJournal(addr string, out chan<- ClientLog) (net.Listener, error) {
+	ln, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("journal input listening on %s", addr)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			if err != nil {
 				log.Printf("accept error: %v", err)
 				continue
 			}
 			go func(c net.Conn) {
 				defer c.Close()
-				reader := bufio.NewReader(c)
-				for {
-					line, err := reader.ReadBytes('\n')
-					if len(line) > 0 {
-						var cl ClientLog
-						if err := json.Unmarshal(bytes.TrimSpace(line), &cl); err == nil {
-							out <- cl
-						} else {
-							log.Printf("invalid client payload: %v", err)
-						}
-					}
-					if err != nil {
-						if err != io.EOF {
-							log.Printf("conn read error: %v", err)
-						}
-						return
-					}
+				if err := readJournal(c, connTenant(c), out); err != nil {
+					log.Printf("journal stream from %s: %v", c.RemoteAddr(), err)
 				}
 			}(conn)
 		}
 	}()
-	return nil
+	return ln, nil
+}
+
+// importJournalFiles reads journal export files once.
+func importJournalFiles(paths []string, out chan<- ClientLog) {
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			log.Printf("journal file: %v", err)
+			continue
+		}
+		if err := readJournal(f, "", out); err != nil {
+			log.Printf("journal file %s: %v", p, err)
+		}
+		f.Close()
+	}
 }
 
 func main() {
 	listenAddr := getEnv("LISTEN_ADDR", ":9000")
 	serverIngest := getEnv("SERVER_INGEST", "http://log-server:8000/ingest")
+	if file, names := os.Getenv("GROK_PATTERNS_FILE"), splitList(os.Getenv("GROK_MATCH")); file != "" || len(names) > 0 {
+		set, err := loadGrokPatterns(file, names)
+		if err != nil {
+			log.Fatalf("grok patterns: %v", err)
+		}
+		authPatterns = set
+	}
+	if dir := os.Getenv("SIGMA_RULES_DIR"); dir != "" {
+		rules, skipped, err := sigma.LoadDir(dir)
+		if err != nil {
+			log.Fatalf("sigma rules: %v", err)
+		}
+		for _, err := range skipped {
+			log.Printf("skipping sigma rule: %v", err)
+		}
+		sigmaRules = sigma.NewEngine(rules)
+		log.Printf("loaded %d sigma rules from %s", len(rules), dir)
+	}
+	if path := os.Getenv("REDACT_CONFIG"); path != "" {
+		cfg, err := redact.LoadConfig(path)
+		if err != nil {
+			log.Fatalf("redaction config: %v", err)
+		}
+		if redactor, err = redact.New(cfg); err != nil {
+			log.Fatalf("redaction config: %v", err)
+		}
+	}
+	rawMultiline = multiline.Config{
+		Start:    os.Getenv("MULTILINE_START"),
+		Continue: os.Getenv("MULTILINE_CONTINUE"),
+		Timeout:  os.Getenv("MULTILINE_TIMEOUT"),
+	}
+	if n, err := strconv.Atoi(os.Getenv("MULTILINE_MAX_LINES")); err == nil {
+		rawMultiline.MaxLines = n
+	}
+	if _, err := multiline.New(rawMultiline, nil); err != nil {
+		log.Fatalf("%v", err)
+	}
+	defaultTenant = os.Getenv("TENANT_ID")
+	rawSource = getEnv("RAW_SOURCE", rawSource)
+	rawCategory = getEnv("RAW_CATEGORY", rawCategory)
+	if c := tlsconfig.FromEnv("TLS_"); c.Enabled() {
+		cfg, err := c.Server()
+		if err != nil {
+			log.Fatalf("listener TLS: %v", err)
+		}
+		listenTLS = cfg
+	}
+	if c := tlsconfig.FromEnv("SERVER_TLS_"); c.Enabled() || c.ServerName != "" {
+		forHost, err := c.ClientFunc()
+		if err != nil {
+			log.Fatalf("server TLS: %v", err)
+		}
+		// HTTP sinks may point at different hosts; each is verified by
+		// the name it is dialed with.
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, _ := net.SplitHostPort(addr)
+			d := &tls.Dialer{Config: forHost(host)}
+			return d.DialContext(ctx, network, addr)
+		}
+		httpClient.Transport = t
+	}
+	if path := os.Getenv("ADMIN_KEYS_FILE"); path != "" {
+		var err error
+		if adminKeys, err = auth.LoadFile(path); err != nil {
+			log.Fatalf("failed to load admin keys: %v", err)
+		}
+	}
 	m := newCollectorMetrics()
 	startMetricsServer(":8080", m)
 
 	// Ch	annel and worker pool
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	ch := make(chan ClientLog, 1024)
-	if err := listenTCP(listenAddr, ch); err != nil {
+	// Inputs are closed first on shutdown.
+	var inputs []io.Closer
+	ln, err := listenTCP(listenAddr, ch)
+	if err != nil {
 		log.Fatalf("listen error: %v", err)
 	}
+	inputs = append(inputs, ln)
+	journalCategory = getEnv("JOURNAL_CATEGORY", journalCategory)
+	if addr := os.Getenv("JOURNAL_LISTEN_ADDR"); addr != "" {
+		ln, err := listenJournal(addr, ch)
+		if err != nil {
+			log.Fatalf("journal listen error: %v", err)
+		}
+		inputs = append(inputs, ln)
+	}
+	if files := splitList(os.Getenv("JOURNAL_FILES")); len(files) > 0 {
+		go importJournalFiles(files, ch)
+	}
+	if path := os.Getenv("TAIL_CONFIG"); path != "" {
+		ti, err := startTail(path, ch)
+		if err != nil {
+			log.Fatalf("file input: %v", err)
+		}
+		inputs = append(inputs, ti)
+	}
 	workers := 4
 	if wStr := os.Getenv("WORKERS"); wStr != "" {
 		if n, err := strconv.Atoi(wStr); err == nil && n > 0 {
 			workers = n
 		}
 	}
-	log.Printf("forwarding to %s with %d workers", serverIngest, workers)
+	var filters *filter.Engine
+	if path := os.Getenv("FILTER_RULES"); path != "" {
+		cfg, err := filter.LoadConfig(path)
+		if err == nil {
+			filters, err = filter.New(cfg)
+		}
+		if err != nil {
+			log.Fatalf("filter rules: %v", err)
+		}
+	}
+	detector := detect.NewEngine(detectConfig())
+	if d, err := time.ParseDuration(os.Getenv("BLACKLIST_TTL")); err == nil && d >= 0 {
+		autoBlacklist.SetTTL(d)
+	}
+	if v, ok := os.LookupEnv("AUTO_BLACKLIST_RULES"); ok {
+		autoBlacklistRules = make(map[string]struct{})
+		for _, r := range splitList(v) {
+			autoBlacklistRules[r] = struct{}{}
+		}
+	}
+	router, err := newRouter(serverIngest, workers)
+	if err != nil {
+		log.Fatalf("sinks: %v", err)
+	}
+	m.sinkStats = router.Stats
+	log.Printf("forwarding with %d workers", workers)
+	send := func(e model.LogEntry) {
+		m.inc(e.EventCategory, e.Severity)
+		router.Route(e)
+	}
+	dedupStop, dedupDone := make(chan struct{}), make(chan struct{})
+	close(dedupDone)
+	if d, err := time.ParseDuration(os.Getenv("DEDUP_WINDOW")); err == nil && d > 0 {
+		deduper := dedup.New(d, send)
+		dedupDone = make(chan struct{})
+		go func() {
+			defer close(dedupDone)
+			deduper.Run(dedupStop)
+		}()
+		send = deduper.Add
+		log.Printf("deduplicating repeated messages within %s", d)
+	}
+	process := func(cl ClientLog) {
+		if cl.done != nil {
+			defer cl.done()
+		}
+		entry := parseLog(cl)
+		alerts := detector.Observe(entry)
+		applyDetections(alerts)
+		for _, e := range append([]model.LogEntry{entry}, alerts...) {
+			router.Mirror(e)
+			if keep, reason := filters.Evaluate(e); !keep {
+				m.filtered(reason)
+				continue
+			}
+			send(e)
+		}
+	}
+	// Connection handlers may still hold ch, so it is never closed; after
+	// quit the workers process what is queued and return.
+	quit := make(chan struct{})
 	var wg sync.WaitGroup
 	wg.Add(workers)
 	for i := 0; i < workers; i++ {
 		go func() {
 			defer wg.Done()
-			for cl := range ch {
-				entry := parseLog(cl)
-				m.inc(entry.EventCategory, entry.Severity)
-				if err := forwardLog(entry, serverIngest); err != nil {
-					log.Printf("forward error: %v", err)
+			for {
+				select {
+				case cl := <-ch:
+					process(cl)
+				case <-quit:
+					for {
+						select {
+						case cl := <-ch:
+							process(cl)
+						default:
+							return
+						}
+					}
 				}
 			}
 		}()
 	}
+
+	<-ctx.Done()
+	stop() // a second signal exits at once
+	log.Printf("shutting down")
+	for _, in := range inputs {
+		if err := in.Close(); err != nil {
+			log.Printf("closing input: %v", err)
+		}
+	}
+	close(quit)
 	wg.Wait()
+	// Entries held for deduplication, then everything queued for the sinks.
+	close(dedupStop)
+	<-dedupDone
+	if err := router.Close(); err != nil {
+		log.Printf("closing sinks: %v", err)
+	}
+}
+
+// newRouter builds the sinks from SINKS_CONFIG, or a single log-server sink
+// for serverIngest, sending with workers goroutines, when it is unset.
+// ARCHIVE_DIR adds an archive sink that mirrors every entry, before filter
+// rules and dedup.
+func newRouter(serverIngest string, workers int) (*sink.Router, error) {
+	cfg := sink.Config{
+		Sinks:   []sink.SinkConfig{{Name: "log-server", Type: "http", URL: serverIngest, MaxRetries: 3, Workers: workers}},
+		Default: []string{"log-server"},
+	}
+	if key := os.Getenv("SERVER_API_KEY"); key != "" {
+		cfg.Sinks[0].Headers = map[string]string{"Authorization": "Bearer " + key}
+	}
+	if path := os.Getenv("SINKS_CONFIG"); path != "" {
+		var err error
+		if cfg, err = sink.LoadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+	if dir := os.Getenv("ARCHIVE_DIR"); dir != "" {
+		cfg.Sinks = append(cfg.Sinks, sink.SinkConfig{
+			Name:      "archive",
+			Type:      "archive",
+			Path:      dir,
+			Rotation:  getEnv("ARCHIVE_ROTATION", sink.RotateDaily),
+			Retention: os.Getenv("ARCHIVE_RETENTION"),
+			QueueSize: 8192,
+		})
+		cfg.Mirror = append(cfg.Mirror, "archive")
+	}
+	return sink.NewRouter(cfg, httpClient)
+}
+
+func detectConfig() detect.Config {
+	cfg := detect.Config{FailureThreshold: 5, FailureWindow: time.Minute, NewHostForUser: true}
+	if v := os.Getenv("DETECT_FAILED_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cfg.FailureThreshold = n
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("DETECT_FAILED_WINDOW")); err == nil && d > 0 {
+		cfg.FailureWindow = d
+	}
+	if v := os.Getenv("DETECT_NEW_HOST"); v != "" {
+		cfg.NewHostForUser = strings.EqualFold(v, "true") || v == "1"
+	}
+	return cfg
 }
 
 func getEnv(key, def string) string {
@@ -247,3 +1152,13 @@ func getEnv(key, def string) string {
 	}
 	return def
 }
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -1,8 +1,32 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"motadata/internal/auditd"
+	"motadata/internal/auth"
+	"motadata/internal/detect"
+	"motadata/internal/frame"
+	"motadata/internal/model"
+	"motadata/internal/multiline"
+	"motadata/internal/redact"
+	"motadata/internal/sigma"
+	"motadata/internal/tail"
 )
 
 func TestToLogEntryExtraction(t *testing.T) {
@@ -32,3 +56,494 @@ func TestEnrichLogBlacklistedUser(t *testing.T) {
 		t.Fatalf("expected root to be blacklisted, got: %+v", le)
 	}
 }
+
+func TestParseLogAuthPatterns(t *testing.T) {
+	cl := ClientLog{
+		Source:   "linux",
+		Category: "login.audit",
+		Message:  "Oct 18 10:01:02 bastion sshd[1234]: Failed password for invalid user bob from 10.0.0.13 port 50022 ssh2",
+	}
+	le := parseLog(cl)
+	if le.Username != "bob" || le.Hostname != "bastion" {
+		t.Fatalf("expected grok fields to be applied, got: %+v", le)
+	}
+	if !le.IsBlacklisted {
+		t.Fatalf("expected blacklisted source IP, got: %+v", le)
+	}
+	if le.Attr("src_ip") != "10.0.0.13" || le.Attr("pid") != "1234" {
+		t.Fatalf("expected remaining captures in attributes, got: %+v", le.Attributes)
+	}
+}
+
+func TestDecodeClientLogECS(t *testing.T) {
+	cl, err := decodeClientLog([]byte(`{"@timestamp":"2025-07-29T12:35:24Z","event":{"category":"login.audit","module":"linux"},"host":{"hostname":"h1"},"user":{"name":"carol"},"log":{"level":"warn"},"source":{"ip":"192.168.1.66"},"message":"custom login"}`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	le := parseLog(cl)
+	if le.Username != "carol" || le.Hostname != "h1" || le.Severity != "WARN" || le.Service != "linux_login_audit" {
+		t.Fatalf("unexpected entry: %+v", le)
+	}
+	if le.Attr("src_ip") != "192.168.1.66" {
+		t.Fatalf("expected source.ip attribute, got: %+v", le.Attributes)
+	}
+}
+
+func TestParseLogSigmaTags(t *testing.T) {
+	rules, err := sigma.Parse(strings.NewReader(`
+id: root-login
+level: high
+detection:
+  selection:
+    username: root
+  condition: selection
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	sigmaRules = sigma.NewEngine(rules)
+	defer func() { sigmaRules = nil }()
+
+	le := parseLog(ClientLog{Source: "linux", Category: "login.audit", Message: "<86> h1 sudo: pam_unix(sudo:session): session opened for user root(uid=0)"})
+	if le.Attr("sigma.rule_id") != "root-login" || le.Attr("sigma.level") != "high" {
+		t.Fatalf("expected sigma tags, got: %+v", le.Attributes)
+	}
+}
+
+func TestAutoBlacklistFromDetections(t *testing.T) {
+	defer autoBlacklist.Clear()
+	d := detect.NewEngine(detect.Config{FailureThreshold: 2, FailureWindow: time.Minute})
+	failed := ClientLog{Source: "linux", Category: "login.audit", Message: "Oct 18 10:01:02 bastion sshd[1]: Failed password for bob from 172.16.5.5 port 22 ssh2"}
+	for i := 0; i < 2; i++ {
+		le := parseLog(failed)
+		if le.IsBlacklisted {
+			t.Fatalf("source must not be blacklisted before detection: %+v", le)
+		}
+		applyDetections(d.Observe(le))
+	}
+	later := parseLog(ClientLog{Source: "linux", Category: "login.audit", Message: "Oct 18 10:05:00 bastion sshd[2]: Accepted password for bob from 172.16.5.5 port 22 ssh2"})
+	if !later.IsBlacklisted || later.Attr("blacklist.source") != "auto" {
+		t.Fatalf("expected later event to be flagged, got: %+v", later)
+	}
+
+	mux := newAdminMux(newCollectorMetrics())
+	local := func(method, path string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "127.0.0.1:40000"
+		return req
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, local(http.MethodGet, "/blacklist"))
+	var list []detect.BlacklistEntry
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || len(list) != 1 || list[0].IP != "172.16.5.5" {
+		t.Fatalf("unexpected blacklist listing: %+v (%v)", list, err)
+	}
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, local(http.MethodDelete, "/blacklist/172.16.5.5"))
+	if w.Code != http.StatusNoContent || autoBlacklist.Contains("", "172.16.5.5") {
+		t.Fatalf("expected entry to be removed, got %d", w.Code)
+	}
+}
+
+func TestBlacklistEndpointsGuarded(t *testing.T) {
+	defer autoBlacklist.Clear()
+	autoBlacklist.Add("", "172.16.5.5", "brute_force_ip")
+	do := func(method, path, remote, key string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remote
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		newAdminMux(newCollectorMetrics()).ServeHTTP(w, req)
+		return w.Code
+	}
+	// Without keys, only local clients are answered.
+	if code := do(http.MethodDelete, "/blacklist", "203.0.113.9:5000", ""); code != http.StatusForbidden {
+		t.Fatalf("expected a remote client to be refused, got %d", code)
+	}
+	if code := do(http.MethodGet, "/metrics", "203.0.113.9:5000", ""); code != http.StatusOK {
+		t.Fatalf("expected metrics to stay open, got %d", code)
+	}
+
+	adminKeys, _ = auth.New([]auth.Key{
+		{Name: "analyst", Role: auth.RoleRead, Hash: auth.HashKey("a")},
+		{Name: "ops", Role: auth.RoleAdmin, Hash: auth.HashKey("o")},
+	})
+	defer func() { adminKeys = nil }()
+	if code := do(http.MethodGet, "/blacklist", "127.0.0.1:5000", ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected a key to be required, got %d", code)
+	}
+	if code := do(http.MethodGet, "/blacklist", "203.0.113.9:5000", "a"); code != http.StatusOK {
+		t.Fatalf("expected a read key to list, got %d", code)
+	}
+	if code := do(http.MethodDelete, "/blacklist/172.16.5.5", "203.0.113.9:5000", "a"); code != http.StatusForbidden {
+		t.Fatalf("expected a read key to be refused, got %d", code)
+	}
+	if code := do(http.MethodDelete, "/blacklist/172.16.5.5", "203.0.113.9:5000", "o"); code != http.StatusNoContent {
+		t.Fatalf("expected an admin key to remove, got %d", code)
+	}
+}
+
+func TestParseLogRedaction(t *testing.T) {
+	r, err := redact.New(redact.Config{Rules: []redact.Rule{{Detector: "ipv4", Mode: redact.ModeMask}}})
+	if err != nil {
+		t.Fatalf("new redactor: %v", err)
+	}
+	redactor = r
+	defer func() { redactor = nil }()
+
+	le := parseLog(ClientLog{Source: "linux", Category: "login.audit", Message: "<4> h1 sshd: Failed password for invalid user bob from 10.0.0.13 port 22 ssh2"})
+	if strings.Contains(le.RawMessage, "10.0.0.13") || le.Attr("src_ip") != redact.Mask {
+		t.Fatalf("expected source IP to be masked, got: %+v", le)
+	}
+	if !le.IsBlacklisted {
+		t.Fatalf("static blacklist must be evaluated before redaction")
+	}
+	snap := newCollectorMetrics().snapshot()
+	if snap["redactions"].(map[string]int)["ipv4"] != 2 {
+		t.Fatalf("expected redaction counters in metrics, got: %v", snap["redactions"])
+	}
+}
+
+func TestHandleConnMultiline(t *testing.T) {
+	rawMultiline = multiline.Config{Start: `^\d{4}-\d{2}-\d{2} `}
+	defer func() { rawMultiline = multiline.Config{} }()
+
+	client, server := net.Pipe()
+	out := make(chan ClientLog, 10)
+	done := make(chan struct{})
+	go func() {
+		handleConn(server, out)
+		close(done)
+	}()
+	client.Write([]byte("2024-05-01 10:00:00 ERROR request failed\n" +
+		"Traceback (most recent call last):\n" +
+		"  File \"app.py\", line 3, in <module>\n" +
+		`{"hostname":"h1","event.source.type":"linux","event.category":"login.audit","message":"hello"}` + "\n" +
+		"2024-05-01 10:00:01 INFO ok\n"))
+	client.Close()
+	<-done
+	close(out)
+
+	var got []ClientLog
+	for cl := range out {
+		got = append(got, cl)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 records, got %+v", got)
+	}
+	if got[0].Category != rawCategory || strings.Count(got[0].Message, "\n") != 2 {
+		t.Fatalf("expected assembled raw record, got %+v", got[0])
+	}
+	if got[1].Hostname != "h1" || got[2].Message != "2024-05-01 10:00:01 INFO ok" {
+		t.Fatalf("unexpected records %+v", got[1:])
+	}
+}
+
+func TestHandleConnJSONInRawRecord(t *testing.T) {
+	rawMultiline = multiline.Config{Continue: `^\s`}
+	defer func() { rawMultiline = multiline.Config{} }()
+
+	client, server := net.Pipe()
+	out := make(chan ClientLog, 10)
+	done := make(chan struct{})
+	go func() {
+		handleConn(server, out)
+		close(done)
+	}()
+	client.Write([]byte("ERROR request failed, body:\n" +
+		`  {"hostname":"h1","message":"part of the body"}` + "\n" +
+		"{\n" +
+		`  "hostname": "h2",` + "\n" +
+		"}\n"))
+	client.Close()
+	<-done
+	close(out)
+
+	var got []ClientLog
+	for cl := range out {
+		got = append(got, cl)
+	}
+	// The indented object continues the raw record, and the pretty-printed
+	// one does not decode line by line, so it is kept as raw text.
+	want := []string{
+		"ERROR request failed, body:\n" + `  {"hostname":"h1","message":"part of the body"}`,
+		"{\n" + `  "hostname": "h2",`,
+		"}",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d records, got %+v", len(want), got)
+	}
+	for i, cl := range got {
+		if cl.Category != rawCategory || cl.Message != want[i] {
+			t.Fatalf("expected raw record %q, got %+v", want[i], cl)
+		}
+	}
+}
+
+func TestHandleConnInvalidPayload(t *testing.T) {
+	client, server := net.Pipe()
+	out := make(chan ClientLog, 10)
+	done := make(chan struct{})
+	go func() {
+		handleConn(server, out)
+		close(done)
+	}()
+	before := invalidPayloads.Load()
+	client.Write([]byte(`{"hostname":"h1","message":` + "\n" +
+		`{"hostname":"h1","event.source.type":"linux","event.category":"login.audit","message":"ok"}` + "\n"))
+	client.Close()
+	<-done
+	close(out)
+
+	// Without multiline records a broken payload is not raw text.
+	if len(out) != 1 || (<-out).Message != "ok" {
+		t.Fatal("expected only the valid payload")
+	}
+	if invalidPayloads.Load()-before != 1 {
+		t.Fatal("expected the broken payload to be counted")
+	}
+}
+
+func TestHandleConnFramed(t *testing.T) {
+	client, server := net.Pipe()
+	out := make(chan ClientLog, 10)
+	go handleConn(server, out)
+	defer client.Close()
+
+	payloads := []string{
+		`{"hostname":"h1","event.source.type":"linux","event.category":"login.audit","message":"a"}`,
+		`not json`,
+		`{"hostname":"h1","event.source.type":"linux","event.category":"login.audit","message":"b"}`,
+	}
+	go func() {
+		frame.Write(client, frame.Frame{Type: frame.TypeWindow, Seq: uint32(len(payloads))})
+		for i, p := range payloads {
+			frame.Write(client, frame.Frame{Type: frame.TypeData, Seq: uint32(i + 1), Payload: []byte(p)})
+		}
+	}()
+	a, b := <-out, <-out
+	if a.Message != "a" || b.Message != "b" {
+		t.Fatal("expected the two valid events in order")
+	}
+
+	// Nothing is acknowledged until the workers are done with the events.
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if f, err := frame.Read(client); err == nil {
+		t.Fatalf("unexpected ack before processing: %+v", f)
+	}
+	b.done()
+	go a.done()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ack, err := frame.Read(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The invalid payload is acknowledged along with the rest.
+	if ack.Type != frame.TypeAck || ack.Seq != 3 {
+		t.Fatalf("expected ack of the whole window, got %+v", ack)
+	}
+}
+
+func TestTailClientLogAuthLog(t *testing.T) {
+	src := &tail.Source{Source: "linux", Category: "login.audit"}
+	cl, err := tailClientLog(tail.Record{
+		Source: src,
+		Path:   "/var/log/auth.log",
+		Text:   "Jul 29 12:35:24 web01 sshd[4242]: Failed password for alice from 203.0.113.9 port 52144 ssh2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := parseLog(cl)
+	if le.EventCategory != "login.audit" || le.Hostname != "web01" || le.Username != "alice" {
+		t.Fatalf("unexpected entry %+v", le)
+	}
+	if le.Attr("file_path") != "/var/log/auth.log" || le.Attr("src_ip") != "203.0.113.9" {
+		t.Fatalf("unexpected attributes %+v", le.Attributes)
+	}
+
+	src.Format = "json"
+	if _, err := tailClientLog(tail.Record{Source: src, Text: "not json"}); err == nil {
+		t.Fatal("expected error for invalid json line")
+	}
+}
+
+func TestReadJournalExport(t *testing.T) {
+	stream := "__REALTIME_TIMESTAMP=1722256524000000\n" +
+		"_HOSTNAME=web01\n" +
+		"SYSLOG_IDENTIFIER=sshd\n" +
+		"SYSLOG_FACILITY=10\n" +
+		"_PID=4242\n" +
+		"PRIORITY=5\n" +
+		"MESSAGE=Failed password for alice from 203.0.113.9 port 52144 ssh2\n\n" +
+		"_HOSTNAME=web01\n" +
+		"SYSLOG_IDENTIFIER=app\n" +
+		"MESSAGE\n\x05\x00\x00\x00\x00\x00\x00\x00a\nb\x00c\n\n"
+	out := make(chan ClientLog, 4)
+	if err := readJournal(strings.NewReader(stream), "", out); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var got []model.LogEntry
+	for cl := range out {
+		got = append(got, parseLog(cl))
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(got))
+	}
+	le := got[0]
+	if le.Hostname != "web01" || le.EventCategory != "login.audit" || le.Severity != "WARN" || le.Username != "alice" {
+		t.Fatalf("unexpected entry %+v", le)
+	}
+	if le.Attr("program") != "sshd" || le.Attr("pid") != "4242" || le.Attr("src_ip") != "203.0.113.9" {
+		t.Fatalf("unexpected attributes %+v", le.Attributes)
+	}
+	if !le.Timestamp.Equal(time.Unix(1722256524, 0)) || le.RawMessage != "Failed password for alice from 203.0.113.9 port 52144 ssh2" {
+		t.Fatalf("unexpected timestamp or message %+v", le)
+	}
+	if got[1].EventCategory != "journal.log" || got[1].RawMessage != "a\nb\x00c" {
+		t.Fatalf("unexpected binary entry %+v", got[1])
+	}
+}
+
+func TestTailCloseFlushesAuditd(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	os.WriteFile(logPath, []byte(`type=USER_START msg=audit(1722256530.001:4570): pid=4300 uid=0 auid=1000 ses=7 msg='op=PAM:session_open acct="bob" exe="/usr/sbin/sshd" hostname=? addr=198.51.100.4 terminal=ssh res=success'`+"\n"), 0o644)
+	cfg, _ := json.Marshal(map[string]any{
+		"sources":    []map[string]any{{"paths": []string{logPath}, "source": "auditd", "category": "audit.log", "format": "auditd"}},
+		"checkpoint": filepath.Join(dir, "checkpoint.json"),
+	})
+	cfgPath := filepath.Join(dir, "tail.json")
+	os.WriteFile(cfgPath, cfg, 0o644)
+
+	ch := make(chan ClientLog, 10)
+	ti, err := startTail(cfgPath, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := func() int64 {
+		b, err := os.ReadFile(filepath.Join(dir, "checkpoint.json"))
+		if err != nil {
+			return -1
+		}
+		var cps map[string]tail.Checkpoint
+		json.Unmarshal(b, &cps)
+		for _, cp := range cps {
+			return cp.Offset
+		}
+		return -1
+	}
+	for deadline := time.Now().Add(time.Second); offset() < 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The record has no EOE and is still held by the aggregator, so its
+	// offset is not saved yet.
+	if off := offset(); off != 0 {
+		t.Fatalf("expected the held record not to be checkpointed, got %d", off)
+	}
+	if err := ti.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(logPath); offset() != info.Size() {
+		t.Fatalf("expected the offset past the forwarded event, got %d", offset())
+	}
+	select {
+	case cl := <-ch:
+		if cl.Username != "bob" {
+			t.Fatalf("unexpected event %+v", cl)
+		}
+	default:
+		t.Fatal("expected the pending auditd event to be forwarded on close")
+	}
+}
+
+func TestAuditdLoginEvent(t *testing.T) {
+	var events []auditd.Event
+	agg := auditd.NewAggregator(time.Second, func(ev auditd.Event) { events = append(events, ev) })
+	for _, l := range []string{
+		`node=web01 type=USER_AUTH msg=audit(1722256524.120:4567): pid=4242 uid=0 auid=4294967295 ses=4294967295 msg='op=PAM:authentication acct="alice" exe="/usr/sbin/sshd" hostname=203.0.113.9 addr=203.0.113.9 terminal=ssh res=failed'`,
+		`node=web01 type=USER_START msg=audit(1722256530.001:4570): pid=4300 uid=0 auid=1000 ses=7 msg='op=PAM:session_open acct="bob" exe="/usr/sbin/sshd" hostname=? addr=198.51.100.4 terminal=ssh res=success'`,
+	} {
+		r, err := auditd.ParseLine(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		agg.Add(r)
+	}
+	agg.FlushAll()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+
+	failed := parseLog(auditClientLog(events[0]))
+	if failed.EventCategory != "login.audit" || failed.Username != "alice" || failed.Hostname != "web01" || failed.Severity != "WARN" {
+		t.Fatalf("unexpected entry %+v", failed)
+	}
+	if failed.Attr("outcome") != "failure" || failed.Attr("src_ip") != "203.0.113.9" || failed.Attr("session_id") != "" {
+		t.Fatalf("unexpected attributes %+v", failed.Attributes)
+	}
+	if !detect.IsFailedLogin(failed) {
+		t.Fatal("expected auditd failure to count as a failed login")
+	}
+
+	opened := parseLog(auditClientLog(events[1]))
+	if opened.Username != "bob" || opened.Attr("session_state") != "opened" || opened.Attr("session_id") != "7" {
+		t.Fatalf("unexpected session entry %+v", opened)
+	}
+}
+
+func TestParseLogTenant(t *testing.T) {
+	defaultTenant = "team-a"
+	defer func() { defaultTenant = "" }()
+	if e := parseLog(ClientLog{Message: "x"}); e.Tenant != "team-a" {
+		t.Fatalf("expected default tenant, got %q", e.Tenant)
+	}
+	// A tenant named in the payload is ignored.
+	cl, err := decodeClientLog([]byte(`{"message":"x","tenant":"team-b"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := parseLog(cl); e.Tenant != "team-a" {
+		t.Fatalf("expected the payload tenant to be ignored, got %q", e.Tenant)
+	}
+}
+
+func TestConnTenantFromClientCertificate(t *testing.T) {
+	cert := func(org string) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "agent", Organization: []string{org}},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	client, server := net.Pipe()
+	srv := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert("collector")}, ClientAuth: tls.RequireAnyClientCert})
+	cli := tls.Client(client, &tls.Config{Certificates: []tls.Certificate{cert("team-c")}, InsecureSkipVerify: true})
+	out := make(chan ClientLog, 1)
+	go handleConn(srv, out)
+	go func() {
+		cli.Write([]byte(`{"message":"x","tenant":"team-b"}` + "\n"))
+		cli.Close()
+	}()
+	select {
+	case cl := <-out:
+		if cl.Tenant != "team-c" {
+			t.Fatalf("expected the certificate's tenant, got %q", cl.Tenant)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no entry received")
+	}
+}
@@ -2,25 +2,134 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"motadata/internal/alert"
+	"motadata/internal/audit"
+	"motadata/internal/auth"
+	"motadata/internal/ecs"
 	"motadata/internal/model"
+	"motadata/internal/session"
 	"motadata/internal/storage"
+	"motadata/internal/tlsconfig"
 )
 
 type Server struct {
-	store storage.LogStore
+	store  *storage.TenantStore
+	alerts *alert.Evaluator
+	audit  *audit.Log
+
+	mu       sync.Mutex
+	sessions map[string]*session.Correlator // by tenant
+}
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+func NewServer(store *storage.TenantStore) *Server {
+	// main replaces both with on-disk ones when configured.
+	rules, _ := alert.NewRuleStore("")
+	trail, _ := audit.Open("")
+	s := &Server{
+		store:    store,
+		sessions: make(map[string]*session.Correlator),
+		alerts:   alert.NewEvaluator(rules, alert.NewNotifier(httpClient)),
+		audit:    trail,
+	}
+	// Rebuild session state from whatever the store already holds.
+	for _, tenant := range store.Tenants() {
+		if existing, err := store.Query(tenant, storage.QueryFilter{SortBy: "timestamp"}); err == nil {
+			for _, e := range existing {
+				s.sessionsOf(tenant).Observe(e)
+			}
+		}
+	}
+	return s
+}
+
+func (s *Server) sessionsOf(tenant string) *session.Correlator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.sessions[tenant]
+	if !ok {
+		c = session.NewCorrelator(sessionTimeout())
+		s.sessions[tenant] = c
+	}
+	return c
+}
+
+// tenantOf returns the tenant a request acts for and writes an error if
+// it may not. A key bound to a tenant always acts for that tenant; other
+// principals choose one with the X-Tenant-ID header, else fallback (the
+// tenant named by an ingested entry), else the default tenant.
+func tenantOf(w http.ResponseWriter, r *http.Request, fallback string) (string, bool) {
+	p, _ := auth.FromContext(r.Context())
+	tenant := r.Header.Get("X-Tenant-ID")
+	switch {
+	case p.Tenant != "" && tenant != "" && tenant != p.Tenant:
+		http.Error(w, "forbidden for tenant "+tenant, http.StatusForbidden)
+		return "", false
+	case p.Tenant != "":
+		tenant = p.Tenant
+	case tenant == "":
+		tenant = fallback
+	}
+	if tenant == "" {
+		tenant = storage.DefaultTenant
+	}
+	if !storage.ValidTenant(tenant) {
+		http.Error(w, "invalid tenant", http.StatusBadRequest)
+		return "", false
+	}
+	return tenant, true
+}
+
+// globalOnly rejects principals bound to a tenant. Alert rules and their
+// history span all tenants.
+func globalOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p, _ := auth.FromContext(r.Context()); p.Tenant != "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// record adds rec, completed from the request, to the audit trail. The
+// caller must not answer with data when it fails.
+func (s *Server) record(r *http.Request, rec audit.Record) error {
+	p, _ := auth.FromContext(r.Context())
+	rec.Principal, rec.Role = p.Name, string(p.Role)
+	rec.ClientIP = auth.ClientIP(r)
+	rec.Method, rec.Path = r.Method, r.URL.Path
+	if q := r.URL.Query(); len(q) > 0 {
+		rec.Filter = make(map[string]string, len(q))
+		for k, v := range q {
+			rec.Filter[k] = strings.Join(v, ",")
+		}
+	}
+	if _, err := s.audit.Append(rec); err != nil {
+		log.Printf("audit: %v", err)
+		return err
+	}
+	return nil
 }
 
-func NewServer(store storage.LogStore) *Server {
-	return &Server{store: store}
+func sessionTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("SESSION_TIMEOUT")); err == nil {
+		return d
+	}
+	return 24 * time.Hour
 }
 
 func (s *Server) ingestHandler(w http.ResponseWriter, r *http.Request) {
@@ -32,65 +141,375 @@ func (s *Server) ingestHandler(w http.ResponseWriter, r *http.Request) {
 	if entry.Timestamp.IsZero() {
 		entry.Timestamp = time.Now().UTC()
 	}
-	if err := s.store.Ingest(entry); err != nil {
+	tenant, ok := tenantOf(w, r, entry.Tenant)
+	if !ok {
+		return
+	}
+	entry.Tenant = tenant
+	if err := s.store.Ingest(tenant, entry); errors.Is(err, storage.ErrQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
 		http.Error(w, "failed to ingest", http.StatusInternalServerError)
 		return
 	}
+	// A logout that closes a session also stores the session itself, so
+	// /logs can search it (category=session.audit).
+	if sess, ok := s.sessionsOf(tenant).Observe(entry); ok {
+		derived := sess.Entry()
+		derived.Tenant = tenant
+		if err := s.store.Ingest(tenant, derived); err != nil {
+			log.Printf("storing session %s: %v", sess.ID, err)
+		}
+	}
+	s.alerts.Observe(entry)
 	w.WriteHeader(http.StatusAccepted)
 }
 
 func (s *Server) logsHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := tenantOf(w, r, "")
+	if !ok {
+		return
+	}
 	q := r.URL.Query()
-	filter := storage.QueryFilter{}
-	filter.Service = q.Get("service")
-	filter.Level = q.Get("level")
-	filter.Username = q.Get("username")
-	if v := q.Get("is.blacklisted"); v != "" {
-		b := strings.EqualFold(v, "true") || v == "1"
-		filter.IsBlacklisted = &b
+	filter := storage.ParseQueryFilter(q)
+
+	res, err := s.store.Query(tenant, filter)
+	if err != nil {
+		s.record(r, audit.Record{Action: "logs.query", Tenant: tenant, Status: http.StatusInternalServerError})
+		http.Error(w, "query error", http.StatusInternalServerError)
+		return
+	}
+	if s.record(r, audit.Record{Action: "logs.query", Tenant: tenant, Results: len(res), Status: http.StatusOK}) != nil {
+		http.Error(w, "audit unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if strings.EqualFold(q.Get("format"), "ecs") {
+		docs := make([]ecs.Document, 0, len(res))
+		for _, e := range res {
+			docs = append(docs, ecs.FromEntry(e))
+		}
+		json.NewEncoder(w).Encode(docs)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// exportHandler streams the query result as NDJSON for download.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := tenantOf(w, r, "")
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	res, err := s.store.Query(tenant, storage.ParseQueryFilter(q))
+	if err != nil {
+		s.record(r, audit.Record{Action: "logs.export", Tenant: tenant, Status: http.StatusInternalServerError})
+		http.Error(w, "query error", http.StatusInternalServerError)
+		return
+	}
+	if s.record(r, audit.Record{Action: "logs.export", Tenant: tenant, Results: len(res), Status: http.StatusOK}) != nil {
+		http.Error(w, "audit unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	asECS := strings.EqualFold(q.Get("format"), "ecs")
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="logs.ndjson"`)
+	enc := json.NewEncoder(w)
+	for _, e := range res {
+		var err error
+		if asECS {
+			err = enc.Encode(ecs.FromEntry(e))
+		} else {
+			err = enc.Encode(e)
+		}
+		if err != nil {
+			log.Printf("export write error: %v", err)
+			return
+		}
+	}
+}
+
+func (s *Server) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := tenantOf(w, r, "")
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	f := session.Filter{
+		Username: q.Get("username"),
+		Hostname: q.Get("hostname"),
+		State:    q.Get("state"),
 	}
 	if v := q.Get("limit"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
-			filter.Limit = n
+			f.Limit = n
 		}
 	}
-	filter.SortBy = q.Get("sort")
+	// Only ingest creates a tenant's correlator.
+	res := []session.Session{}
+	s.mu.Lock()
+	c := s.sessions[tenant]
+	s.mu.Unlock()
+	if c != nil {
+		res = c.Query(f)
+	}
+	if s.record(r, audit.Record{Action: "sessions.query", Tenant: tenant, Results: len(res), Status: http.StatusOK}) != nil {
+		http.Error(w, "audit unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) listAlertRulesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.alerts.Rules().List())
+}
 
-	res, err := s.store.Query(filter)
+func (s *Server) getAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule, err := s.alerts.Rules().Get(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, "query error", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (s *Server) putAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var rule alert.Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if id, ok := mux.Vars(r)["id"]; ok {
+		rule.ID = id
+	}
+	rules := s.alerts.Rules()
+	prev, err := rules.Get(rule.ID)
+	existed := err == nil
+	saved, err := rules.Put(rule)
+	if err != nil {
+		s.record(r, audit.Record{Action: "alerts.rule.put", Target: rule.ID, Status: http.StatusBadRequest})
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A change that cannot be audited is undone.
+	if s.record(r, audit.Record{Action: "alerts.rule.put", Target: saved.ID, Results: 1, Status: http.StatusCreated}) != nil {
+		if existed {
+			_, err = rules.Put(prev)
+		} else {
+			err = rules.Delete(saved.ID)
+		}
+		if err != nil {
+			log.Printf("undoing unaudited change of alert rule %s: %v", saved.ID, err)
+		}
+		http.Error(w, "audit unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(saved)
+}
+
+func (s *Server) deleteAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	rules := s.alerts.Rules()
+	prev, _ := rules.Get(id)
+	if err := rules.Delete(id); err != nil {
+		s.record(r, audit.Record{Action: "alerts.rule.delete", Target: id, Status: http.StatusNotFound})
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if s.record(r, audit.Record{Action: "alerts.rule.delete", Target: id, Results: 1, Status: http.StatusNoContent}) != nil {
+		if _, err := rules.Put(prev); err != nil {
+			log.Printf("undoing unaudited deletion of alert rule %s: %v", id, err)
+		}
+		http.Error(w, "audit unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) alertHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.alerts.History())
+}
+
+// auditHandler returns audit records, oldest first. Reading the trail is
+// itself recorded.
+func (s *Server) auditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := audit.Filter{Principal: q.Get("principal"), Action: q.Get("action"), Tenant: q.Get("tenant")}
+	for name, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(name); v != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			f.Limit = n
+		}
+	}
+	res := s.audit.Query(f)
+	if s.record(r, audit.Record{Action: "audit.query", Results: len(res), Status: http.StatusOK}) != nil {
+		http.Error(w, "audit unavailable", http.StatusServiceUnavailable)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
+// auditVerifyHandler checks the hash chain of the audit trail.
+func (s *Server) auditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := s.audit.Verify()
+	res := map[string]any{"ok": err == nil, "records": n}
+	if err != nil {
+		res["error"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
-	m := s.store.Metrics()
+	tenant, ok := tenantOf(w, r, "")
+	if !ok {
+		return
+	}
+	m, err := s.store.Metrics(tenant)
+	if err != nil {
+		http.Error(w, "metrics error", http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(m)
 }
 
+// statusWriter remembers the status a handler answered with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// auditDenied records requests refused with 401 or 403, whether by
+// auth.Require, globalOnly or tenantOf.
+func (s *Server) auditDenied(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if sw.status == http.StatusUnauthorized || sw.status == http.StatusForbidden {
+			s.record(r, audit.Record{Action: "access.denied", Tenant: r.Header.Get("X-Tenant-ID"), Status: sw.status})
+		}
+	})
+}
+
+// newRouter registers the API routes with the role each requires.
+func newRouter(srv *Server, authn *auth.Authenticator) *mux.Router {
+	r := mux.NewRouter()
+	r.Use(authn.Middleware(), srv.auditDenied)
+	r.Handle("/ingest", auth.Require(auth.RoleIngest, srv.ingestHandler)).Methods(http.MethodPost)
+	r.Handle("/logs", auth.Require(auth.RoleRead, srv.logsHandler)).Methods(http.MethodGet)
+	r.Handle("/export", auth.Require(auth.RoleRead, srv.exportHandler)).Methods(http.MethodGet)
+	r.Handle("/sessions", auth.Require(auth.RoleRead, srv.sessionsHandler)).Methods(http.MethodGet)
+	r.Handle("/alerts", auth.Require(auth.RoleRead, globalOnly(srv.alertHistoryHandler))).Methods(http.MethodGet)
+	r.Handle("/alerts/rules", auth.Require(auth.RoleRead, globalOnly(srv.listAlertRulesHandler))).Methods(http.MethodGet)
+	r.Handle("/alerts/rules", auth.Require(auth.RoleAdmin, globalOnly(srv.putAlertRuleHandler))).Methods(http.MethodPost)
+	r.Handle("/alerts/rules/{id}", auth.Require(auth.RoleRead, globalOnly(srv.getAlertRuleHandler))).Methods(http.MethodGet)
+	r.Handle("/alerts/rules/{id}", auth.Require(auth.RoleAdmin, globalOnly(srv.putAlertRuleHandler))).Methods(http.MethodPut)
+	r.Handle("/alerts/rules/{id}", auth.Require(auth.RoleAdmin, globalOnly(srv.deleteAlertRuleHandler))).Methods(http.MethodDelete)
+	r.Handle("/audit", auth.Require(auth.RoleAdmin, globalOnly(srv.auditHandler))).Methods(http.MethodGet)
+	r.Handle("/audit/verify", auth.Require(auth.RoleAdmin, globalOnly(srv.auditVerifyHandler))).Methods(http.MethodGet)
+	r.Handle("/metrics", auth.Require(auth.RoleRead, srv.metricsHandler)).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }).Methods(http.MethodGet)
+	return r
+}
+
+// genKey prints a new API key and the keys file entry for it.
+func genKey(name, role, tenant string) {
+	key, err := auth.GenerateKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	k := auth.Key{Name: name, Role: auth.Role(role), Hash: auth.HashKey(key), Tenant: tenant}
+	if _, err := auth.New([]auth.Key{k}); err != nil {
+		log.Fatal(err)
+	}
+	entry, _ := json.Marshal(k)
+	fmt.Printf("key:   %s\nentry: %s\n", key, entry)
+}
+
 func main() {
+	if (len(os.Args) == 4 || len(os.Args) == 5) && os.Args[1] == "genkey" {
+		tenant := ""
+		if len(os.Args) == 5 {
+			tenant = os.Args[4]
+		}
+		genKey(os.Args[2], os.Args[3], tenant)
+		return
+	}
 	storeType := getEnv("STORE", "memory")
-	var store storage.LogStore
+	parts := storage.MemoryPartitions()
 	if storeType == "file" {
-		path := getEnv("STORE_PATH", "/data/logs.jsonl")
-		fs, err := storage.NewFileBackedStore(path)
-		if err != nil {
-			log.Fatalf("failed to init file store: %v", err)
+		parts = storage.FilePartitions(getEnv("STORE_PATH", "/data/logs.jsonl"))
+	}
+	var tenantCfg storage.TenantConfig
+	if path := os.Getenv("TENANTS_CONFIG"); path != "" {
+		var err error
+		if tenantCfg, err = storage.LoadTenantConfig(path); err != nil {
+			log.Fatalf("failed to load tenant config: %v", err)
 		}
-		store = fs
-	} else {
-		store = storage.NewInMemoryStore()
 	}
+	store, err := storage.NewTenantStore(parts, tenantCfg)
+	if err != nil {
+		log.Fatalf("failed to init store: %v", err)
+	}
+	go store.RunRetention(time.Minute, nil)
 	srv := NewServer(store)
+	if path := os.Getenv("ALERT_RULES_PATH"); path != "" {
+		rules, err := alert.NewRuleStore(path)
+		if err != nil {
+			log.Fatalf("failed to load alert rules: %v", err)
+		}
+		srv.alerts = alert.NewEvaluator(rules, alert.NewNotifier(httpClient))
+	}
+	if path := os.Getenv("AUDIT_LOG_PATH"); path != "" {
+		trail, err := audit.Open(path)
+		if err != nil {
+			log.Fatalf("failed to open audit log: %v", err)
+		}
+		if n, err := trail.Verify(); err != nil {
+			log.Printf("audit log %s: %v", path, err)
+		} else {
+			log.Printf("audit log %s: %d records verified", path, n)
+		}
+		srv.audit = trail
+	}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/ingest", srv.ingestHandler).Methods(http.MethodPost)
-	r.HandleFunc("/logs", srv.logsHandler).Methods(http.MethodGet)
-	r.HandleFunc("/metrics", srv.metricsHandler).Methods(http.MethodGet)
-	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }).Methods(http.MethodGet)
+	var authn *auth.Authenticator
+	if path := os.Getenv("AUTH_KEYS_FILE"); path != "" {
+		var err error
+		if authn, err = auth.LoadFile(path); err != nil {
+			log.Fatalf("failed to load API keys: %v", err)
+		}
+		// An audit trail kept in memory would be lost on restart.
+		if os.Getenv("AUDIT_LOG_PATH") == "" {
+			log.Fatalf("AUTH_KEYS_FILE requires AUDIT_LOG_PATH")
+		}
+	} else {
+		log.Printf("AUTH_KEYS_FILE not set; API authentication is disabled")
+	}
+	r := newRouter(srv, authn)
 
 	addr := getEnv("LISTEN_ADDR", ":8000")
 	log.Printf("log-server listening on %s", addr)
@@ -102,6 +521,18 @@ func main() {
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
+	if c := tlsconfig.FromEnv("TLS_"); c.Enabled() {
+		cfg, err := c.Server()
+		if err != nil {
+			log.Fatalf("TLS: %v", err)
+		}
+		srvHTTP.TLSConfig = cfg
+		// The certificate comes from cfg, which reloads it on change.
+		if err := srvHTTP.ListenAndServeTLS("", ""); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if err := srvHTTP.ListenAndServe(); err != nil {
 		log.Fatal(err)
 	}
@@ -5,20 +5,33 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
+	"motadata/internal/audit"
+	"motadata/internal/auth"
 	"motadata/internal/model"
 	"motadata/internal/storage"
 )
 
+func newTestStore(cfg storage.TenantConfig) *storage.TenantStore {
+	ts, err := storage.NewTenantStore(storage.MemoryPartitions(), cfg)
+	if err != nil {
+		panic(err)
+	}
+	return ts
+}
+
 func setupTestServer() (*Server, *mux.Router) {
-	s := NewServer(storage.NewInMemoryStore())
+	s := NewServer(newTestStore(storage.TenantConfig{}))
 	r := mux.NewRouter()
 	r.HandleFunc("/ingest", s.ingestHandler).Methods(http.MethodPost)
 	r.HandleFunc("/logs", s.logsHandler).Methods(http.MethodGet)
 	r.HandleFunc("/metrics", s.metricsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/alerts/rules", s.putAlertRuleHandler).Methods(http.MethodPost)
+	r.HandleFunc("/alerts/rules/{id}", s.deleteAlertRuleHandler).Methods(http.MethodDelete)
 	return s, r
 }
 
@@ -51,3 +64,282 @@ func TestIngestAndQueryRoutes(t *testing.T) {
 		t.Fatalf("expected 200, got %d", w2.Code)
 	}
 }
+
+func TestQueryByAttribute(t *testing.T) {
+	_, r := setupTestServer()
+	for _, ip := range []string{"10.0.0.13", "10.0.0.14"} {
+		body, _ := json.Marshal(model.LogEntry{Username: "bob", Attributes: model.Attributes{"src_ip": ip}})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d", w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs?attr.src_ip=10.0.0.14", nil))
+	var res []model.LogEntry
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(res) != 1 || res[0].Attr("src_ip") != "10.0.0.14" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestLogsAndExportECSFormat(t *testing.T) {
+	s, r := setupTestServer()
+	r.HandleFunc("/export", s.exportHandler).Methods(http.MethodGet)
+	_ = s.store.Ingest(storage.DefaultTenant, model.LogEntry{Timestamp: time.Now().UTC(), Username: "alice", Hostname: "h1", Severity: "INFO", Service: "linux_login"})
+	_ = s.store.Ingest(storage.DefaultTenant, model.LogEntry{Timestamp: time.Now().UTC(), Username: "bob", Hostname: "h2", Severity: "INFO", Service: "linux_login"})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs?format=ecs&username=alice", nil))
+	var docs []map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&docs); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(docs) != 1 || docs[0]["user"].(map[string]any)["name"] != "alice" || docs[0]["host"].(map[string]any)["hostname"] != "h1" {
+		t.Fatalf("unexpected ECS docs: %+v", docs)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export?service=linux_login", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if n := bytes.Count(w.Body.Bytes(), []byte("\n")); n != 2 {
+		t.Fatalf("expected 2 NDJSON lines, got %d", n)
+	}
+}
+
+func TestSessionsEndpoint(t *testing.T) {
+	s, r := setupTestServer()
+	r.HandleFunc("/sessions", s.sessionsHandler).Methods(http.MethodGet)
+	base := time.Now().UTC()
+	for _, e := range []model.LogEntry{
+		{Timestamp: base, EventCategory: "login.audit", Hostname: "h1", Username: "alice", RawMessage: "<86> h1 sudo: pam_unix(sudo:session): session opened for user alice(uid=0)"},
+		{Timestamp: base.Add(time.Minute), EventCategory: "logout.audit", Hostname: "h1", Username: "alice", RawMessage: "<86> h1 systemd: session closed for user alice"},
+	} {
+		body, _ := json.Marshal(e)
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body)))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions?username=alice", nil))
+	var res []map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(res) != 1 || res[0]["state"] != "closed" || res[0]["duration.seconds"] != float64(60) {
+		t.Fatalf("unexpected sessions: %+v", res)
+	}
+
+	// The closed session is stored as an entry of its own.
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs?category=session.audit&username=alice", nil))
+	var logs []model.LogEntry
+	if err := json.NewDecoder(w.Body).Decode(&logs); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Attr("session.duration") != "60" || logs[0].Attr("session.state") != "closed" {
+		t.Fatalf("expected the derived session in /logs, got %+v", logs)
+	}
+}
+
+func TestAlertRuleWebhook(t *testing.T) {
+	got := make(chan string, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("X-Dedupe-Key")
+	}))
+	defer hook.Close()
+	_, r := setupTestServer()
+
+	rule := `{"name":"root logins","query":"username=root","threshold":1,"window":"1m","cooldown":"5m","webhooks":[{"url":"` + hook.URL + `"}]}`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/alerts/rules", bytes.NewBufferString(rule)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	body, _ := json.Marshal(model.LogEntry{Username: "root", RawMessage: "session opened for user root"})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body)))
+	select {
+	case key := <-got:
+		if key == "" {
+			t.Fatalf("expected a dedupe key header")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook not called")
+	}
+}
+
+func TestRouteRoles(t *testing.T) {
+	authn, _ := auth.New([]auth.Key{
+		{Name: "collector", Role: auth.RoleIngest, Hash: auth.HashKey("c")},
+		{Name: "analyst", Role: auth.RoleRead, Hash: auth.HashKey("a")},
+	})
+	r := newRouter(NewServer(newTestStore(storage.TenantConfig{})), authn)
+	do := func(method, path, key, body string) int {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	for _, c := range []struct {
+		method, path, key, body string
+		want                    int
+	}{
+		{http.MethodPost, "/ingest", "", `{"username":"bob"}`, http.StatusUnauthorized},
+		{http.MethodPost, "/ingest", "a", `{"username":"bob"}`, http.StatusForbidden},
+		{http.MethodPost, "/ingest", "c", `{"username":"bob"}`, http.StatusAccepted},
+		{http.MethodGet, "/logs", "c", "", http.StatusForbidden},
+		{http.MethodGet, "/logs", "a", "", http.StatusOK},
+		{http.MethodPost, "/alerts/rules", "a", `{"name":"x","query":"username=root","threshold":1,"window":"1m"}`, http.StatusForbidden},
+		{http.MethodGet, "/healthz", "", "", http.StatusOK},
+	} {
+		if got := do(c.method, c.path, c.key, c.body); got != c.want {
+			t.Fatalf("%s %s with key %q: expected %d, got %d", c.method, c.path, c.key, c.want, got)
+		}
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	authn, _ := auth.New([]auth.Key{
+		{Name: "collector", Role: auth.RoleIngest, Hash: auth.HashKey("c")},
+		{Name: "team-a", Role: auth.RoleRead, Hash: auth.HashKey("a"), Tenant: "team-a"},
+		{Name: "ops", Role: auth.RoleAdmin, Hash: auth.HashKey("o")},
+	})
+	store := newTestStore(storage.TenantConfig{Tenants: map[string]storage.Limits{"team-b": {MaxEntries: 1}}})
+	r := newRouter(NewServer(store), authn)
+	do := func(method, path, key, tenant, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		if tenant != "" {
+			req.Header.Set("X-Tenant-ID", tenant)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The tenant comes from the header or, failing that, the entry.
+	if w := do(http.MethodPost, "/ingest", "c", "team-a", `{"username":"alice"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("ingest for team-a: %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/ingest", "c", "", `{"username":"bob","tenant":"team-b"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("ingest for team-b: %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/ingest", "c", "team-b", `{"username":"bob"}`); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected team-b quota to be exceeded, got %d", w.Code)
+	}
+
+	var res []model.LogEntry
+	json.NewDecoder(do(http.MethodGet, "/logs", "a", "", "").Body).Decode(&res)
+	if len(res) != 1 || res[0].Username != "alice" || res[0].Tenant != "team-a" {
+		t.Fatalf("team-a sees %+v", res)
+	}
+	if w := do(http.MethodGet, "/logs", "a", "team-b", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected a team-a key to be refused team-b, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/alerts", "a", "", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("expected alert history to be refused to a tenant key, got %d", w.Code)
+	}
+	var m storage.Metrics
+	json.NewDecoder(do(http.MethodGet, "/metrics", "o", "team-b", "").Body).Decode(&m)
+	if m.Total != 1 {
+		t.Fatalf("expected team-b metrics only, got %+v", m)
+	}
+	json.NewDecoder(do(http.MethodGet, "/logs", "o", "", "").Body).Decode(&res)
+	if len(res) != 0 {
+		t.Fatalf("default tenant sees %+v", res)
+	}
+}
+
+func TestAuditTrail(t *testing.T) {
+	authn, _ := auth.New([]auth.Key{
+		{Name: "analyst", Role: auth.RoleRead, Hash: auth.HashKey("a")},
+		{Name: "ops", Role: auth.RoleAdmin, Hash: auth.HashKey("o")},
+	})
+	r := newRouter(NewServer(newTestStore(storage.TenantConfig{})), authn)
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.RemoteAddr = "10.1.2.3:4000"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	do(http.MethodGet, "/logs?username=root", "a")
+	do(http.MethodGet, "/export?service=sshd", "a")
+	do(http.MethodDelete, "/alerts/rules/missing", "o")
+
+	if w := do(http.MethodGet, "/audit", "a"); w.Code != http.StatusForbidden {
+		t.Fatalf("expected the audit trail to be refused to a read key, got %d", w.Code)
+	}
+	do(http.MethodGet, "/logs?username=root", "wrong")
+	var recs []audit.Record
+	json.NewDecoder(do(http.MethodGet, "/audit", "o").Body).Decode(&recs)
+	if len(recs) != 5 {
+		t.Fatalf("expected 5 records, got %+v", recs)
+	}
+	q := recs[0]
+	if q.Principal != "analyst" || q.Action != "logs.query" || q.Filter["username"] != "root" || q.ClientIP != "10.1.2.3" || q.Tenant != storage.DefaultTenant {
+		t.Fatalf("unexpected query record %+v", q)
+	}
+	if recs[1].Action != "logs.export" || recs[2].Principal != "ops" || recs[2].Target != "missing" || recs[2].Status != http.StatusNotFound {
+		t.Fatalf("unexpected records %+v", recs[1:])
+	}
+	// Denied attempts are recorded too.
+	if d := recs[3]; d.Action != "access.denied" || d.Principal != "analyst" || d.Path != "/audit" || d.Status != http.StatusForbidden {
+		t.Fatalf("unexpected denied record %+v", d)
+	}
+	if d := recs[4]; d.Action != "access.denied" || d.Principal != "" || d.Filter["username"] != "root" || d.Status != http.StatusUnauthorized {
+		t.Fatalf("unexpected denied record %+v", d)
+	}
+
+	var v struct {
+		OK      bool `json:"ok"`
+		Records int  `json:"records"`
+	}
+	json.NewDecoder(do(http.MethodGet, "/audit/verify", "o").Body).Decode(&v)
+	// The read of /audit was recorded too.
+	if !v.OK || v.Records != 6 {
+		t.Fatalf("unexpected verification %+v", v)
+	}
+}
+
+func TestAdminActionsFailClosed(t *testing.T) {
+	s := NewServer(newTestStore(storage.TenantConfig{}))
+	r := newRouter(s, nil)
+	rule := `{"name":"root logins","query":"username=root","threshold":1,"window":"1m"}`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/alerts/rules", bytes.NewBufferString(rule)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+
+	// From now on every audit write fails.
+	trail, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trail.Close()
+	s.audit = trail
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/alerts/rules", bytes.NewBufferString(rule)))
+	if w.Code != http.StatusServiceUnavailable || len(s.alerts.Rules().List()) != 1 {
+		t.Fatalf("expected the unaudited rule to be refused, got %d and %+v", w.Code, s.alerts.Rules().List())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/alerts/rules/1", bytes.NewBufferString(`{"name":"changed","query":"username=root","threshold":9,"window":"1m"}`)))
+	if got, _ := s.alerts.Rules().Get("1"); w.Code != http.StatusServiceUnavailable || got.Name != "root logins" {
+		t.Fatalf("expected the unaudited change to be undone, got %d and %+v", w.Code, got)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/alerts/rules/1", nil))
+	if _, err := s.alerts.Rules().Get("1"); w.Code != http.StatusServiceUnavailable || err != nil {
+		t.Fatalf("expected the unaudited deletion to be undone, got %d (%v)", w.Code, err)
+	}
+}